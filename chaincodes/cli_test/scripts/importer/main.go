@@ -0,0 +1,420 @@
+// Command importer registers the services of a CSV catalog, such as
+// servicelist.csv, with the service chaincode's registerServicesBatch. It runs
+// in the cli container, where it calls the peer command:
+//
+//	cd /opt/gopath/src/github.com/inklabsfoundation/inkchain/peer
+//	go run scripts/importer/main.go -user user1 -key <private key> scripts/servicelist.csv
+//
+// Each line of the catalog holds a service name, its category and its
+// endpoint; the endpoint goes into the service's metadata. The rows are sent
+// in batches of -batch rows. A name the catalog repeats is only sent once,
+// and rows the chaincode reports as existing are skipped, so an import can
+// run again over a catalog that is partly on the ledger.
+//
+// After every batch the importer records the next row in the progress file
+// (the catalog's name with ".progress" appended); a new run resumes from
+// there, -restart starts from the first row again. Rows the chaincode rejects
+// are appended, with the reason, to the failed file (".failed"), a catalog
+// that can be fixed and imported in turn. With -atomic a batch with a failed
+// row registers nothing: its failed rows go to the failed file with their
+// reason, its new ones with "Rejected with its batch.", and the import goes
+// on with the next batch.
+//
+// The peer returns once the orderer accepted a batch, before it is
+// committed. A batch rejected at commit is not reported here; running the
+// import again with -restart registers whatever is missing.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxBatchSize is the chaincode's limit of rows in a batch
+const MaxBatchSize = 200
+
+var (
+	user      = flag.String("user", "", "name of the developer registering the services")
+	key       = flag.String("key", "", "private key of the developer's address")
+	batchSize = flag.Int("batch", 100, "rows per transaction, at most 200")
+	atomic    = flag.Bool("atomic", false, "reject a whole batch when one of its rows fails")
+	restart   = flag.Bool("restart", false, "ignore the progress file and start from the first row")
+	progress  = flag.String("progress", "", "progress file (default: the catalog with .progress appended)")
+	failed    = flag.String("failed", "", "file of the rejected rows (default: the catalog with .failed appended)")
+
+	peer      = flag.String("peer", "peer", "peer command")
+	orderer   = flag.String("orderer", "orderer.example.com:7050", "orderer address")
+	cafile    = flag.String("cafile", "/opt/gopath/src/github.com/inklabsfoundation/inkchain/peer/crypto/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem", "TLS CA certificate of the orderer, empty without TLS")
+	channel   = flag.String("channel", "mychannel", "channel name")
+	chaincode = flag.String("chaincode", "service", "chaincode name")
+	fee       = flag.String("fee", "10", "fee of a transaction")
+)
+
+// Structure definition for a row sent to registerServicesBatch
+type serviceRow struct {
+	Name        string           `json:"name"`
+	Type        string           `json:"type"`
+	Description string           `json:"description"`
+	Metadata    *serviceMetadata `json:"metadata,omitempty"`
+	line        int              // line in the catalog, blank lines aside
+	endpoint    string
+}
+
+type serviceMetadata struct {
+	EndpointURL string `json:"endpointUrl"`
+}
+
+// Structure definition for the report of registerServicesBatch
+type batchReport struct {
+	Registered int `json:"registered"`
+	Existing   int `json:"existing"`
+	Failed     int `json:"failed"`
+	Rows       []struct {
+		Row    int    `json:"row"`
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"rows"`
+}
+
+// Structure definition for the progress of an import
+type importProgress struct {
+	Catalog    string `json:"catalog"`
+	Next       int    `json:"next"` // index of the next row to send
+	Registered int    `json:"registered"`
+	Existing   int    `json:"existing"`
+	Failed     int    `json:"failed"`
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: importer -user <name> -key <private key> [flags] <catalog.csv>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *user == "" || *key == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *batchSize < 1 || *batchSize > MaxBatchSize {
+		log.Fatalf("The batch size must be between 1 and %d.", MaxBatchSize)
+	}
+	catalog := flag.Arg(0)
+	if *progress == "" {
+		*progress = catalog + ".progress"
+	}
+	if *failed == "" {
+		*failed = catalog + ".failed"
+	}
+
+	rows, rejected, repeated, err := readCatalog(catalog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	state, resumed, err := loadProgress(*progress, catalog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !resumed {
+		// a new import starts a new failed file, with the incomplete rows
+		err = ioutil.WriteFile(*failed, nil, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, row := range rejected {
+			if err = recordFailure(row, "The service name can not be empty."); err != nil {
+				log.Fatal(err)
+			}
+		}
+		state.Failed += len(rejected)
+		if err = saveProgress(*progress, state); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if state.Next >= len(rows) {
+		log.Printf("%s: nothing left to import, %d rows were sent.", catalog, len(rows))
+		return
+	}
+	log.Printf("%s: %d services, %d repeated names left out, starting at row %d.",
+		catalog, len(rows), repeated, state.Next+1)
+
+	for state.Next < len(rows) {
+		end := state.Next + *batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batch := rows[state.Next:end]
+		report, err := registerBatch(batch)
+		rejectedBatch := err == errBatchRejected
+		if err != nil && !rejectedBatch {
+			log.Fatalf("lines %d-%d: %v\nThe import stopped at row %d, run it again to resume.",
+				batch[0].line, batch[len(batch)-1].line, err, state.Next+1)
+		}
+		for _, r := range report.Rows {
+			reason := r.Error
+			if r.Row >= len(batch) || r.Status == "exists" {
+				continue
+			} else if r.Status != "failed" {
+				if !rejectedBatch {
+					continue
+				}
+				reason = "Rejected with its batch."
+			}
+			if err = recordFailure(batch[r.Row], reason); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if rejectedBatch {
+			report.Failed += report.Registered
+			report.Registered = 0
+		}
+
+		state.Next = end
+		state.Registered += report.Registered
+		state.Existing += report.Existing
+		state.Failed += report.Failed
+		if err = saveProgress(*progress, state); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("lines %d-%d: %d registered, %d existing, %d failed",
+			batch[0].line, batch[len(batch)-1].line, report.Registered, report.Existing, report.Failed)
+	}
+	log.Printf("%s: done, %d registered, %d existing, %d failed.", catalog, state.Registered, state.Existing, state.Failed)
+	if state.Failed > 0 {
+		log.Printf("The failed rows are in %s.", *failed)
+	}
+}
+
+// readCatalog reads the rows of a catalog, each name once, and counts the
+// rows left out for a repeated name. Lines without a name are returned
+// apart.
+func readCatalog(path string) (rows []serviceRow, rejected []serviceRow, repeated int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	seen := make(map[string]bool)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, 0, err
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		for len(record) < 3 {
+			record = append(record, "")
+		}
+		row := serviceRow{Name: record[0], Type: record[1], line: line, endpoint: record[2]}
+		if row.endpoint != "" {
+			row.Metadata = &serviceMetadata{EndpointURL: row.endpoint}
+		}
+		if row.Name == "" {
+			rejected = append(rejected, row)
+			continue
+		}
+		if seen[row.Name] {
+			repeated++
+			continue
+		}
+		seen[row.Name] = true
+		rows = append(rows, row)
+	}
+	return rows, rejected, repeated, nil
+}
+
+// loadProgress reads the progress of an import of catalog and reports
+// whether the import resumes. A new import starts when there is none or
+// -restart is set.
+func loadProgress(path string, catalog string) (*importProgress, bool, error) {
+	state := &importProgress{Catalog: catalog}
+	if *restart {
+		return state, false, nil
+	}
+	stateAsBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	err = json.Unmarshal(stateAsBytes, state)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %v", path, err)
+	}
+	if state.Catalog != catalog {
+		return nil, false, fmt.Errorf("%s belongs to the import of %s, use -restart or -progress", path, state.Catalog)
+	}
+	return state, true, nil
+}
+
+// saveProgress replaces the progress file in one step, so an interruption
+// leaves the previous one.
+func saveProgress(path string, state *importProgress) error {
+	stateAsBytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path+".tmp", append(stateAsBytes, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// recordFailure appends a rejected row to the failed file.
+func recordFailure(row serviceRow, reason string) error {
+	file, err := os.OpenFile(*failed, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	writer.Write([]string{row.Name, row.Type, row.endpoint, reason})
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// errBatchRejected is returned with the report of a batch the chaincode
+// rejected in atomic mode.
+var errBatchRejected = errors.New("the batch was rejected")
+
+// registerBatch invokes registerServicesBatch with the peer command and
+// returns the chaincode's report.
+func registerBatch(batch []serviceRow) (*batchReport, error) {
+	rowsAsBytes, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+	invokeArgs := []string{"registerServicesBatch", *user, string(rowsAsBytes)}
+	if *atomic {
+		invokeArgs = append(invokeArgs, "atomic")
+	}
+	ctorAsBytes, err := json.Marshal(map[string][]string{"Args": invokeArgs})
+	if err != nil {
+		return nil, err
+	}
+
+	cmdArgs := []string{"chaincode", "invoke", "-o", *orderer, "-C", *channel, "-n", *chaincode,
+		"-c", string(ctorAsBytes), "-i", *fee, "-z", *key}
+	if *cafile != "" {
+		cmdArgs = append(cmdArgs, "--tls", "true", "--cafile", *cafile)
+	}
+	var output bytes.Buffer
+	cmd := exec.Command(*peer, cmdArgs...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err = cmd.Run(); err != nil {
+		if report := rejectedReport(output.String()); report != nil {
+			return report, errBatchRejected
+		}
+		return nil, fmt.Errorf("%v\n%s", err, output.String())
+	}
+
+	payload, err := invokePayload(output.String())
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, output.String())
+	}
+	report := &batchReport{}
+	err = json.Unmarshal([]byte(payload), report)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected report %q: %v", payload, err)
+	}
+	return report, nil
+}
+
+// rejectedPrefix starts the chaincode's error for a batch rejected in atomic
+// mode, the report follows.
+const rejectedPrefix = "The batch was rejected: "
+
+// rejectedReport returns the report in the peer's output for a rejected
+// batch, nil if the batch failed otherwise. The peer may log the error
+// message escaped.
+func rejectedReport(output string) *batchReport {
+	i := strings.Index(output, rejectedPrefix)
+	if i < 0 {
+		return nil
+	}
+	message := output[i+len(rejectedPrefix):]
+	for _, text := range []string{message, strings.Replace(message, `\"`, `"`, -1)} {
+		report := &batchReport{}
+		if json.NewDecoder(strings.NewReader(text)).Decode(report) == nil {
+			return report
+		}
+	}
+	return nil
+}
+
+// payloadPattern finds the payload in the peer's "Chaincode invoke
+// successful" line, a string in protobuf text format.
+var payloadPattern = regexp.MustCompile(`payload:"((?:[^"\\]|\\.)*)"`)
+
+// invokePayload returns the payload logged by peer chaincode invoke.
+func invokePayload(output string) (string, error) {
+	match := payloadPattern.FindStringSubmatch(output)
+	if match == nil {
+		return "", errors.New("no payload in the peer's output")
+	}
+	return unescape(match[1])
+}
+
+// unescape decodes a string escaped in protobuf text format: C escapes and
+// octal bytes.
+func unescape(s string) (string, error) {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New("truncated escape in the payload")
+		}
+		switch c := s[i]; c {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case '0', '1', '2', '3':
+			if i+3 > len(s) {
+				return "", errors.New("truncated escape in the payload")
+			}
+			b, err := strconv.ParseUint(s[i:i+3], 8, 8)
+			if err != nil {
+				return "", err
+			}
+			out = append(out, byte(b))
+			i += 2
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out), nil
+}
@@ -139,7 +139,7 @@ serviceRangeQuery_Service() {
 # test publish service
 # for init service
 serviceInvoke_PublishService(){
-    peer chaincode invoke -C mychannel -n service --tls $CORE_PEER_TLS_ENABLED --cafile $ORDERER_CA -c '{"Args":["invalidateService","'$1'"]}' -i "10" -z $2 >&log.txt
+    peer chaincode invoke -C mychannel -n service --tls $CORE_PEER_TLS_ENABLED --cafile $ORDERER_CA -c '{"Args":["invalidateService","'$1'","Invalidated by the test script."]}' -i "10" -z $2 >&log.txt
     res=$?
     cat log.txt
     verifyResult $res "service invoke: addService has Failed."
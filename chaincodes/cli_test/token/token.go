@@ -12,7 +12,9 @@ import (
 	"math/big"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gzf09/DSES/chaincodes/events"
 	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
 	pb "github.com/inklabsfoundation/inkchain/protos/peer"
 )
@@ -150,6 +152,31 @@ func (t *tokenChaincode) transfer(stub shim.ChaincodeStubInterface, args []strin
 	if err != nil {
 		return shim.Error("transfer error" + err.Error())
 	}
+
+	// announce the transfer
+	A, err := stub.GetSender()
+	if err != nil {
+		return shim.Error("sender error" + err.Error())
+	}
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("timestamp error" + err.Error())
+	}
+	// announced in the envelope of the chaincodes/events package
+	dataJson, err := json.Marshal(events.TokenTransfer{From: A, To: B, TokenType: BalanceType, Amount: amount.String()})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	eventJson, err := json.Marshal(events.Event{Version: events.Version, Name: Transfer, TxID: stub.GetTxID(),
+		Time: time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(time.UnixDate), Actor: A, Keys: []string{A, B},
+		Data: dataJson})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.SetEvent(Transfer, eventJson)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
@@ -171,7 +198,7 @@ func (t *tokenChaincode) getCounter(stub shim.ChaincodeStubInterface, args []str
 		return shim.Error(jsonResp)
 	}
 
-	jsonResp := "{\"Name\":\"" + A + "\",\"counter\":\"" + string(account.Counter) + "\"}"
+	jsonResp := "{\"Name\":\"" + A + "\",\"counter\":\"" + strconv.FormatUint(account.Counter, 10) + "\"}"
 	fmt.Printf("Query Response:%s\n", jsonResp)
 	return shim.Success([]byte(strconv.FormatUint(account.Counter, 10)))
 }
@@ -0,0 +1,445 @@
+// Package events describes the chaincode events emitted by the DSES
+// chaincodes and decodes them.
+//
+// The functions of the service chaincode changing the ledger, and the token
+// transfer, emit one event per transaction, named after the function. Its
+// payload is a versioned JSON envelope carrying the ledger keys of the
+// records the event is about, the address that sent the transaction and the
+// status of the affected user or service before and after it, with the
+// details of the event under "data".
+//
+//	{
+//	  "version": 1,
+//	  "name": "publishService",
+//	  "txId": "...",
+//	  "time": "Mon Jan  1 00:00:00 UTC 2018",
+//	  "actor": "a5ff00eb44bf19d5dfbde501c90e286badb58df4",
+//	  "keys": ["SER_Twitter"],
+//	  "before": "created",
+//	  "after": "available",
+//	  "data": {"service": "Twitter", "developer": "user2", "mashups": []}
+//	}
+//
+// The chaincodes build their events from the types of this package, so
+// emitters and listeners share one definition. Fields are only ever added to
+// a version; a change that removes or renames a field bumps Version.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version of the event envelope described by this package
+const Version = 1
+
+// Event names, the names of the functions emitting them
+const (
+	RegisterUser      = "registerUser"
+	RemoveUser        = "removeUser"
+	RegisterService   = "registerService"
+	PublishService    = "publishService"
+	InvalidateService = "invalidateService"
+	EditService       = "editService"
+	CreateMashup      = "createMashup"
+	RewardService     = "rewardService"
+	GrantRole         = "grantRole"
+	RevokeRole        = "revokeRole"
+	SuspendService    = "suspendService"
+	ReinstateService  = "reinstateService"
+	SuspendUser       = "suspendUser"
+	ReinstateUser     = "reinstateUser"
+	Transfer          = "transfer"
+
+	OfferServiceTransfer  = "offerServiceTransfer"
+	AcceptServiceTransfer = "acceptServiceTransfer"
+	CancelServiceTransfer = "cancelServiceTransfer"
+
+	AddMaintainer    = "addMaintainer"
+	RemoveMaintainer = "removeMaintainer"
+	ProposeShares    = "proposeShares"
+	ApproveShares    = "approveShares"
+	RejectShares     = "rejectShares"
+
+	EditUser       = "editUser"
+	SetGuardians   = "setGuardians"
+	RotateAddress  = "rotateAddress"
+	RecoverAddress = "recoverAddress"
+	ConfirmAddress = "confirmAddress"
+	CancelRotation = "cancelRotation"
+
+	RegisterServicesBatch = "registerServicesBatch"
+
+	DeprecateService = "deprecateService"
+	SetServicePrice  = "setServicePrice"
+	RateService      = "rateService"
+
+	SetContributionWeights = "setContributionWeights"
+	SetIncentivePolicy     = "setIncentivePolicy"
+	AuthorizeGateway       = "authorizeGateway"
+	RevokeGateway          = "revokeGateway"
+	RecordUsage            = "recordUsage"
+	SettleUsage            = "settleUsage"
+
+	SetSubscriptionPlan    = "setSubscriptionPlan"
+	RemoveSubscriptionPlan = "removeSubscriptionPlan"
+	Subscribe              = "subscribe"
+	RenewSubscription      = "renewSubscription"
+	CancelSubscription     = "cancelSubscription"
+)
+
+// Statuses of a user in the Before and After fields; services use their
+// lifecycle status ("created", "available", "invalid", "deprecated",
+// "suspended"). Role, configuration, gateway and usage events leave them
+// empty.
+const (
+	UserRegistered = "registered"
+	UserSuspended  = "suspended"
+	UserRemoved    = "removed"
+)
+
+// ErrUnsupportedVersion is returned for envelopes of another version.
+var ErrUnsupportedVersion = errors.New("events: unsupported event version")
+
+// Event is the envelope of a chaincode event.
+type Event struct {
+	Version int      `json:"version"`
+	Name    string   `json:"name"`
+	TxID    string   `json:"txId"`
+	Time    string   `json:"time"`  // transaction time, in time.UnixDate layout
+	Actor   string   `json:"actor"` // address that sent the transaction
+	Keys    []string `json:"keys"`  // ledger keys of the records the event is about
+	Before  string   `json:"before,omitempty"`
+	After   string   `json:"after,omitempty"`
+	// Data holds the details of the event, see Decode.
+	Data json.RawMessage `json:"data"`
+}
+
+// User is the data of the registerUser and removeUser events.
+type User struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Orphaned lists the services a removed user left orphaned.
+	Orphaned []string `json:"orphaned,omitempty"`
+}
+
+// UserEdited is the data of the editUser event.
+type UserEdited struct {
+	Name     string `json:"name"`
+	Field    string `json:"field"`
+	OldValue string `json:"oldValue"`
+	NewValue string `json:"newValue"`
+}
+
+// Guardians is the data of the setGuardians event.
+type Guardians struct {
+	Name      string   `json:"name"`
+	Guardians []string `json:"guardians"` // addresses allowed to recover the user
+}
+
+// AddressRotation is the data of the rotateAddress, recoverAddress,
+// confirmAddress and cancelRotation events. Address is the user's address
+// before the transaction.
+type AddressRotation struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	NewAddress string `json:"newAddress"`
+	Recovery   bool   `json:"recovery,omitempty"`
+	ReadyTime  string `json:"readyTime"` // earliest confirmation, in time.UnixDate layout
+}
+
+// ServiceRegistered is the data of the registerService event.
+type ServiceRegistered struct {
+	Service   string `json:"service"`
+	Type      string `json:"type"`
+	Developer string `json:"developer"`
+}
+
+// ImpactedMashup is a mashup whose health changed with the status of a
+// service it is built on.
+type ImpactedMashup struct {
+	Service          string   `json:"service"`
+	Developer        string   `json:"developer"`
+	Health           string   `json:"health"` // "healthy" or "degraded"
+	BrokenComponents []string `json:"brokenComponents"`
+}
+
+// ServicesRegistered is the data of the registerServicesBatch event: the
+// services the batch registered. Rows skipped or rejected are left out.
+type ServicesRegistered struct {
+	Developer string              `json:"developer"`
+	Services  []ServiceRegistered `json:"services"`
+}
+
+// ServicePublished is the data of the publishService event.
+type ServicePublished struct {
+	Service   string           `json:"service"`
+	Developer string           `json:"developer"`
+	Mashups   []ImpactedMashup `json:"mashups"` // mashups repaired by a republish
+}
+
+// ServiceInvalidated is the data of the invalidateService event.
+type ServiceInvalidated struct {
+	Service   string           `json:"service"`
+	Developer string           `json:"developer"`
+	Reason    string           `json:"reason"`
+	Mashups   []ImpactedMashup `json:"mashups"` // mashups degraded by the invalidation
+}
+
+// ServiceModerated is the data of the suspendService and reinstateService
+// events.
+type ServiceModerated struct {
+	Service   string           `json:"service"`
+	Developer string           `json:"developer"`
+	Reason    string           `json:"reason,omitempty"`
+	Mashups   []ImpactedMashup `json:"mashups"` // mashups degraded or repaired
+}
+
+// UserModerated is the data of the suspendUser and reinstateUser events.
+type UserModerated struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Role is the data of the grantRole and revokeRole events.
+type Role struct {
+	Role    string `json:"role"` // "admin" or "moderator"
+	Address string `json:"address"`
+}
+
+// ServiceEdited is the data of the editService event.
+type ServiceEdited struct {
+	Service  string `json:"service"`
+	Field    string `json:"field"`
+	OldValue string `json:"oldValue"`
+	NewValue string `json:"newValue"`
+}
+
+// MashupCreated is the data of the createMashup event.
+type MashupCreated struct {
+	Service     string         `json:"service"`
+	Type        string         `json:"type"`
+	Developer   string         `json:"developer"`
+	Composition map[string]int `json:"composition"`
+}
+
+// ServiceRewarded is the data of the rewardService event.
+type ServiceRewarded struct {
+	Service   string `json:"service"`
+	Developer string `json:"developer"`
+	TokenType string `json:"tokenType"`
+	Amount    string `json:"amount"`
+	Memo      string `json:"memo,omitempty"`
+}
+
+// ServiceTransfer is the data of the offerServiceTransfer,
+// acceptServiceTransfer and cancelServiceTransfer events.
+type ServiceTransfer struct {
+	Service string `json:"service"`
+	From    string `json:"from"` // developer offering the service
+	To      string `json:"to"`   // user offered the service
+}
+
+// Maintainer is a member of a service.
+type Maintainer struct {
+	Name  string `json:"name"`
+	Role  string `json:"role"`  // "owner" or "maintainer"
+	Share int    `json:"share"` // revenue share in basis points
+}
+
+// Maintainers is the data of the addMaintainer, removeMaintainer,
+// proposeShares, approveShares and rejectShares events: the members of the
+// service after the transaction and the shares waiting for the owner's
+// approval, if any.
+type Maintainers struct {
+	Service        string         `json:"service"`
+	Maintainers    []Maintainer   `json:"maintainers"`
+	ProposedShares map[string]int `json:"proposedShares,omitempty"`
+}
+
+// ServiceDeprecated is the data of the deprecateService event.
+type ServiceDeprecated struct {
+	Service   string `json:"service"`
+	Developer string `json:"developer"`
+	Successor string `json:"successor"` // service replacing the deprecated one
+}
+
+// ServicePriced is the data of the setServicePrice event. A free service has
+// neither a token type nor a price.
+type ServicePriced struct {
+	Service   string `json:"service"`
+	TokenType string `json:"tokenType,omitempty"`
+	PerCall   string `json:"perCall,omitempty"`
+}
+
+// ServiceRated is the data of the rateService event.
+type ServiceRated struct {
+	Service       string `json:"service"`
+	User          string `json:"user"`
+	Stars         int    `json:"stars"`
+	PreviousStars int    `json:"previousStars,omitempty"` // when the user rated the service before
+}
+
+// ContributionWeights is the data of the setContributionWeights event: the
+// points of each activity after the transaction.
+type ContributionWeights struct {
+	Publish  int `json:"publish"`
+	Composed int `json:"composed"`
+	Reward   int `json:"reward"`
+	Mashup   int `json:"mashup"`
+}
+
+// IncentivePolicy is the data of the setIncentivePolicy event: the policy
+// after the transaction.
+type IncentivePolicy struct {
+	TokenType    string `json:"tokenType"`
+	ComponentFee string `json:"componentFee"`
+	MinFee       string `json:"minFee"`
+	MaxFee       string `json:"maxFee"`
+	FeeBasis     string `json:"feeBasis"`
+	RoyaltyDecay int    `json:"royaltyDecay"`
+	RoyaltyDepth int    `json:"royaltyDepth"`
+}
+
+// Gateway is the data of the authorizeGateway and revokeGateway events.
+type Gateway struct {
+	Address string `json:"address"`
+}
+
+// UsageRecorded is the data of the recordUsage event.
+type UsageRecorded struct {
+	Period   string `json:"period"` // e.g. "2018-01"
+	Batch    string `json:"batch"`
+	Recorded int    `json:"recorded"` // entries recorded
+	Settled  int    `json:"settled"`  // entries of consumers who settled the period, not recorded
+}
+
+// UsagePayment is a payment of a settlement.
+type UsagePayment struct {
+	Developer string `json:"developer"`
+	TokenType string `json:"tokenType"`
+	Amount    string `json:"amount"`
+}
+
+// UsageSettled is the data of the settleUsage event.
+type UsageSettled struct {
+	Period   string            `json:"period"`
+	Consumer string            `json:"consumer"` // address of the consumer
+	Totals   map[string]string `json:"totals"`   // token type -> amount paid
+	Payments []UsagePayment    `json:"payments"`
+}
+
+// Plan is the data of the setSubscriptionPlan and removeSubscriptionPlan
+// events: the plan offered or withdrawn.
+type Plan struct {
+	Service   string `json:"service"`
+	Plan      string `json:"plan"`
+	TokenType string `json:"tokenType"`
+	Price     string `json:"price"`
+	Duration  string `json:"duration"`
+	Quota     int    `json:"quota"` // calls per term, 0 for unlimited
+}
+
+// Subscription is the data of the subscribe, renewSubscription and
+// cancelSubscription events: the subscription after the transaction.
+type Subscription struct {
+	Service   string `json:"service"`
+	Consumer  string `json:"consumer"` // address of the consumer
+	Plan      string `json:"plan"`
+	TokenType string `json:"tokenType"`
+	Paid      string `json:"paid"` // paid by this transaction
+	Expiry    string `json:"expiry"`
+	Status    string `json:"status"` // "active" or "cancelled"
+}
+
+// TokenTransfer is the data of the transfer event.
+type TokenTransfer struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	TokenType string `json:"tokenType"`
+	Amount    string `json:"amount"`
+}
+
+// newData returns a pointer to the data struct of the named event.
+func newData(name string) (interface{}, error) {
+	switch name {
+	case RegisterUser, RemoveUser:
+		return &User{}, nil
+	case EditUser:
+		return &UserEdited{}, nil
+	case SetGuardians:
+		return &Guardians{}, nil
+	case RotateAddress, RecoverAddress, ConfirmAddress, CancelRotation:
+		return &AddressRotation{}, nil
+	case RegisterService:
+		return &ServiceRegistered{}, nil
+	case RegisterServicesBatch:
+		return &ServicesRegistered{}, nil
+	case PublishService:
+		return &ServicePublished{}, nil
+	case InvalidateService:
+		return &ServiceInvalidated{}, nil
+	case EditService:
+		return &ServiceEdited{}, nil
+	case CreateMashup:
+		return &MashupCreated{}, nil
+	case RewardService:
+		return &ServiceRewarded{}, nil
+	case DeprecateService:
+		return &ServiceDeprecated{}, nil
+	case SetServicePrice:
+		return &ServicePriced{}, nil
+	case RateService:
+		return &ServiceRated{}, nil
+	case SetContributionWeights:
+		return &ContributionWeights{}, nil
+	case SetIncentivePolicy:
+		return &IncentivePolicy{}, nil
+	case AuthorizeGateway, RevokeGateway:
+		return &Gateway{}, nil
+	case RecordUsage:
+		return &UsageRecorded{}, nil
+	case SettleUsage:
+		return &UsageSettled{}, nil
+	case SetSubscriptionPlan, RemoveSubscriptionPlan:
+		return &Plan{}, nil
+	case Subscribe, RenewSubscription, CancelSubscription:
+		return &Subscription{}, nil
+	case GrantRole, RevokeRole:
+		return &Role{}, nil
+	case SuspendService, ReinstateService:
+		return &ServiceModerated{}, nil
+	case SuspendUser, ReinstateUser:
+		return &UserModerated{}, nil
+	case Transfer:
+		return &TokenTransfer{}, nil
+	case OfferServiceTransfer, AcceptServiceTransfer, CancelServiceTransfer:
+		return &ServiceTransfer{}, nil
+	case AddMaintainer, RemoveMaintainer, ProposeShares, ApproveShares, RejectShares:
+		return &Maintainers{}, nil
+	}
+	return nil, fmt.Errorf("events: unknown event %q", name)
+}
+
+// Decode reads an event payload. It returns the envelope and its data as a
+// pointer to the struct of the event, such as *ServicePublished for a
+// publishService event.
+func Decode(payload []byte) (*Event, interface{}, error) {
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, nil, fmt.Errorf("events: %v", err)
+	}
+	if event.Version != Version {
+		return nil, nil, ErrUnsupportedVersion
+	}
+	data, err := newData(event.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(event.Data, data); err != nil {
+		return nil, nil, fmt.Errorf("events: %s data: %v", event.Name, err)
+	}
+	return &event, data, nil
+}
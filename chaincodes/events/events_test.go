@@ -0,0 +1,109 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	payload := []byte(`{"version":1,"name":"invalidateService","txId":"tx1","time":"Mon Jan  1 00:00:00 UTC 2018",
+		"actor":"a5ff","keys":["SER_YouTube","SER_MapVideos"],"before":"available","after":"invalid",
+		"data":{"service":"YouTube","developer":"user2","reason":"Shut down.",
+			"mashups":[{"service":"MapVideos","developer":"user3","health":"degraded","brokenComponents":["YouTube"]}]}}`)
+
+	event, data, err := Decode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Name != InvalidateService || event.Actor != "a5ff" || len(event.Keys) != 2 || event.Before != "available" || event.After != "invalid" {
+		t.Fatalf("event = %+v", event)
+	}
+	invalidated, ok := data.(*ServiceInvalidated)
+	if !ok {
+		t.Fatalf("data = %T", data)
+	}
+	if invalidated.Reason != "Shut down." || len(invalidated.Mashups) != 1 || invalidated.Mashups[0].BrokenComponents[0] != "YouTube" {
+		t.Fatalf("data = %+v", invalidated)
+	}
+}
+
+func TestDecodeTypes(t *testing.T) {
+	for name, want := range map[string]interface{}{
+		RegisterUser:      &User{},
+		RemoveUser:        &User{},
+		RegisterService:   &ServiceRegistered{},
+		PublishService:    &ServicePublished{},
+		EditService:       &ServiceEdited{},
+		CreateMashup:      &MashupCreated{},
+		RewardService:     &ServiceRewarded{},
+		Transfer:          &TokenTransfer{},
+		InvalidateService: &ServiceInvalidated{},
+		GrantRole:         &Role{},
+		RevokeRole:        &Role{},
+		SuspendService:    &ServiceModerated{},
+		ReinstateService:  &ServiceModerated{},
+		SuspendUser:       &UserModerated{},
+		ReinstateUser:     &UserModerated{},
+
+		OfferServiceTransfer:  &ServiceTransfer{},
+		AcceptServiceTransfer: &ServiceTransfer{},
+		CancelServiceTransfer: &ServiceTransfer{},
+
+		AddMaintainer:    &Maintainers{},
+		RemoveMaintainer: &Maintainers{},
+		ProposeShares:    &Maintainers{},
+		ApproveShares:    &Maintainers{},
+		RejectShares:     &Maintainers{},
+
+		EditUser:       &UserEdited{},
+		SetGuardians:   &Guardians{},
+		RotateAddress:  &AddressRotation{},
+		RecoverAddress: &AddressRotation{},
+		ConfirmAddress: &AddressRotation{},
+		CancelRotation: &AddressRotation{},
+
+		RegisterServicesBatch: &ServicesRegistered{},
+
+		DeprecateService: &ServiceDeprecated{},
+		SetServicePrice:  &ServicePriced{},
+		RateService:      &ServiceRated{},
+
+		SetContributionWeights: &ContributionWeights{},
+		SetIncentivePolicy:     &IncentivePolicy{},
+		AuthorizeGateway:       &Gateway{},
+		RevokeGateway:          &Gateway{},
+		RecordUsage:            &UsageRecorded{},
+		SettleUsage:            &UsageSettled{},
+
+		SetSubscriptionPlan:    &Plan{},
+		RemoveSubscriptionPlan: &Plan{},
+		Subscribe:              &Subscription{},
+		RenewSubscription:      &Subscription{},
+		CancelSubscription:     &Subscription{},
+	} {
+		_, data, err := Decode([]byte(`{"version":1,"name":"` + name + `","keys":[],"data":{}}`))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, want := fmt.Sprintf("%T", data), fmt.Sprintf("%T", want); got != want {
+			t.Fatalf("%s data = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, payload := range []string{
+		`not json`,
+		`{"version":2,"name":"registerUser","data":{}}`,
+		`{"name":"registerUser","data":{}}`,
+		`{"version":1,"name":"burn","data":{}}`,
+		`{"version":1,"name":"registerUser","data":[]}`,
+	} {
+		if _, _, err := Decode([]byte(payload)); err == nil {
+			t.Fatalf("decoded %s", payload)
+		}
+	}
+	if _, _, err := Decode([]byte(`{"version":2,"name":"registerUser","data":{}}`)); err != ErrUnsupportedVersion {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+const sender = "07caf88941eafcaaa3370657fccc261acb75dfba"
+
+// echoChaincode answers every invoke with its function name and arguments.
+type echoChaincode struct{}
+
+func (e *echoChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (e *echoChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function == "fail" {
+		return shim.Error("failed on request")
+	}
+	out := function
+	for _, arg := range args {
+		out += "," + arg
+	}
+	return shim.Success([]byte(out))
+}
+
+func newPassthruStub() *shimtest.Stub {
+	stub := shimtest.NewStub("passthru", new(PassthruChaincode))
+	stub.Invokables["echo"] = shimtest.NewStub("echo", new(echoChaincode))
+	return stub
+}
+
+func TestInit(t *testing.T) {
+	stub := newPassthruStub()
+
+	res := stub.InitAs(sender, "init")
+	if res.Status != shim.OK || string(res.Payload) != "init" {
+		t.Fatalf("unexpected Init response: %d %s %s", res.Status, res.Payload, res.Message)
+	}
+	if res = stub.InitAs(sender, "raise-error"); res.Status == shim.OK {
+		t.Fatal("expected Init to fail when the function contains \"error\"")
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	stub := newPassthruStub()
+
+	res := stub.InvokeAs(sender, "echo", "hello", "a", "b")
+	if res.Status != shim.OK || string(res.Payload) != "hello,a,b" {
+		t.Fatalf("unexpected passthru response: %d %s %s", res.Status, res.Payload, res.Message)
+	}
+	if res = stub.InvokeAs(sender, "echo", "fail"); res.Status == shim.OK || res.Message != "failed on request" {
+		t.Fatalf("error of the called chaincode not passed through: %d %s", res.Status, res.Message)
+	}
+	if res = stub.InvokeAs(sender, "missing", "hello"); res.Status == shim.OK {
+		t.Fatal("expected error for an unknown chaincode")
+	}
+	if res = stub.InvokeAs(sender, ""); res.Status == shim.OK {
+		t.Fatal("expected error without a chaincode ID")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Batch registration
+// ==================================================================================
+//
+// registerServicesBatch registers up to MaxBatchSize services of one
+// developer in a single transaction, for catalogs too large to register one
+// service per transaction. Each row is checked like a registerService call
+// and the result is a report with one entry per row:
+//
+//	{
+//	  "registered": 2, "existing": 1, "failed": 1,
+//	  "rows": [
+//	    {"row": 0, "name": "Bing Maps", "status": "registered"},
+//	    {"row": 1, "name": "Twitter", "status": "exists"},
+//	    {"row": 2, "name": "", "status": "failed", "error": "The service name can not be empty."},
+//	    {"row": 3, "name": "OSM", "status": "registered"}
+//	  ]
+//	}
+//
+// A row naming a service that already exists, on the ledger or earlier in
+// the batch, is skipped rather than failed, so a batch can be submitted again
+// after an interruption. By default the other rows are registered when some
+// fail; in atomic mode a failed row rejects the whole transaction and the
+// report comes back in the error. The transaction emits one event listing
+// the services it registered.
+
+// MaxBatchSize is the largest number of rows in a batch
+const MaxBatchSize = 200
+
+// Definitions of the status of a row in a batch
+const (
+	B_Registered = "registered"
+	B_Exists     = "exists"
+	B_Failed     = "failed"
+)
+
+// Structure definition for a row of a batch
+type batchRow struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	Description string          `json:"description"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"` // as in registerService
+}
+
+// Structure definition for the result of a row
+type batchResult struct {
+	Row    int    `json:"row"` // index in the batch, from 0
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Structure definition for the report of a batch
+type batchReport struct {
+	Registered int           `json:"registered"`
+	Existing   int           `json:"existing"`
+	Failed     int           `json:"failed"`
+	Rows       []batchResult `json:"rows"`
+}
+
+// ===============================================================
+// registerServicesBatch: register many services of a developer
+// ===============================================================
+func (t *serviceChaincode) registerServicesBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var user_name string
+	var rows []batchRow
+	var atomic bool
+	var err error
+
+	user_name = args[0]
+	err = json.Unmarshal([]byte(args[1]), &rows)
+	if err != nil {
+		return shim.Error("Error unmarshal services: " + err.Error())
+	}
+	if len(rows) == 0 {
+		return shim.Error("The batch is empty.")
+	}
+	if len(rows) > MaxBatchSize {
+		return shim.Error("A batch can not have more than " + strconv.Itoa(MaxBatchSize) + " services.")
+	}
+	if len(args) > 2 && args[2] != "" {
+		if args[2] != "atomic" {
+			return shim.Error("Unknown batch mode: " + args[2])
+		}
+		atomic = true
+	}
+
+	// STEP 1: check the developer once for the whole batch
+	userJSON, err := checkRegistrant(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: check every row before writing any
+	report := batchReport{Rows: make([]batchResult, len(rows))}
+	metadata := make([]*serviceMetadata, len(rows))
+	// the ledger does not show this transaction's writes, names are tracked here
+	seen := make(map[string]bool)
+	for i, row := range rows {
+		result := &report.Rows[i]
+		result.Row = i
+		result.Name = row.Name
+		if seen[row.Name] {
+			result.Status = B_Exists
+			report.Existing++
+			continue
+		}
+		metadata[i], err = checkBatchRow(stub, &row)
+		if err == errServiceExists {
+			result.Status = B_Exists
+			report.Existing++
+			seen[row.Name] = true
+		} else if err != nil {
+			result.Status = B_Failed
+			result.Error = err.Error()
+			report.Failed++
+		} else {
+			result.Status = B_Registered
+			report.Registered++
+			seen[row.Name] = true
+		}
+	}
+
+	reportAsBytes, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if atomic && report.Failed > 0 {
+		return shim.Error("The batch was rejected: " + string(reportAsBytes))
+	}
+
+	// STEP 3: register the accepted rows
+	keys := []string{}
+	registered := []events.ServiceRegistered{}
+	for i, result := range report.Rows {
+		if result.Status != B_Registered {
+			continue
+		}
+		row := rows[i]
+		err = putNewService(stub, userJSON, row.Name, row.Type, row.Description, metadata[i])
+		if err != nil {
+			return shim.Error("Fail to register " + row.Name + ": " + err.Error())
+		}
+		keys = append(keys, ServicePrefix+row.Name)
+		registered = append(registered, events.ServiceRegistered{Service: row.Name, Type: row.Type, Developer: user_name})
+	}
+
+	if len(registered) > 0 {
+		err = emitEvent(stub, RegisterServicesBatch, keys, "", S_Created,
+			events.ServicesRegistered{Developer: user_name, Services: registered})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(reportAsBytes)
+}
+
+// errServiceExists marks a row naming a service on the ledger.
+var errServiceExists = errors.New("This service already exists.")
+
+// checkBatchRow checks a row as registerService checks its arguments and
+// returns the row's metadata.
+func checkBatchRow(stub shim.ChaincodeStubInterface, row *batchRow) (*serviceMetadata, error) {
+	if row.Name == "" {
+		return nil, errors.New("The service name can not be empty.")
+	}
+
+	serviceAsBytes, err := stub.GetState(ServicePrefix + row.Name)
+	if err != nil {
+		return nil, errors.New("Fail to get service: " + err.Error())
+	} else if serviceAsBytes != nil {
+		return nil, errServiceExists
+	}
+
+	if len(row.Metadata) == 0 {
+		return nil, nil
+	}
+	return parseMetadata(string(row.Metadata), nil)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+)
+
+// batchStatuses describes a batch report as "name:status" rows.
+func batchStatuses(report batchReport) string {
+	rows := []string{}
+	for _, r := range report.Rows {
+		rows = append(rows, r.Name+":"+r.Status)
+	}
+	return strings.Join(rows, ",")
+}
+
+func TestRegisterServicesBatch(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	var report batchReport
+	payload := mustInvoke(t, stub, addr1, RegisterServicesBatch, "user1", `[
+		{"name":"Bing Maps","type":"Mapping","description":"Maps API.","metadata":{"endpointUrl":"/api/bing-maps","protocol":"rest"}},
+		{"name":"Twitter","type":"Social"},
+		{"name":"","type":"Mapping"},
+		{"name":"OSM","type":"Mapping","metadata":{"protocol":"ws"}},
+		{"name":"Bing Maps","type":"Mapping"},
+		{"name":"Flickr","type":"Photo","description":"Photos API."}]`)
+	if err := json.Unmarshal(payload, &report); err != nil {
+		t.Fatalf("unmarshal report %s: %v", payload, err)
+	}
+	if got := batchStatuses(report); got != "Bing Maps:registered,Twitter:exists,:failed,OSM:failed,Bing Maps:exists,Flickr:registered" ||
+		report.Registered != 2 || report.Existing != 2 || report.Failed != 2 {
+		t.Fatalf("report = %s", payload)
+	}
+	if report.Rows[3].Row != 3 || !strings.Contains(report.Rows[3].Error, "protocol") {
+		t.Fatalf("failed row = %+v", report.Rows[3])
+	}
+	if envelope, data := lastEvent(t, stub); envelope != "registerServicesBatch "+addr1+" SER_Bing Maps,SER_Flickr >created" ||
+		len(data.(*events.ServicesRegistered).Services) != 2 ||
+		data.(*events.ServicesRegistered).Services[1] != (events.ServiceRegistered{Service: "Flickr", Type: "Photo", Developer: "user1"}) {
+		t.Fatalf("event = %s %+v", envelope, data)
+	}
+
+	// the registered rows are ordinary services
+	if s := getService(t, stub, "Bing Maps"); s.Developer != "user1" || s.Status != S_Created ||
+		s.Metadata == nil || s.Metadata.Protocol != "REST" {
+		t.Fatalf("service = %+v", s)
+	}
+	if s := getService(t, stub, "Twitter"); s.Developer != "user2" {
+		t.Fatalf("existing service = %+v", s)
+	}
+	if history := getHistory(t, stub, "Flickr"); len(history) != 1 || history[0].To != S_Created {
+		t.Fatalf("history = %+v", history)
+	}
+	if got := serviceNames(queryPage(t, stub, QueryServiceByUser, "user1")); got != "Bing Maps,Flickr,Google Maps" {
+		t.Fatalf("services of user1 = %s", got)
+	}
+	mustInvoke(t, stub, addr1, PublishService, "Flickr")
+
+	// the same batch again registers nothing and emits no event
+	payload = mustInvoke(t, stub, addr1, RegisterServicesBatch, "user1", `[{"name":"Bing Maps","type":"Mapping"}]`)
+	if err := json.Unmarshal(payload, &report); err != nil || report.Existing != 1 || report.Registered != 0 {
+		t.Fatalf("report = %s", payload)
+	}
+	if e := stub.LastEvent(); e != nil && e.TxID == stub.GetTxID() {
+		t.Fatalf("event = %s", e.Payload)
+	}
+}
+
+func TestAtomicBatch(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	// a failed row rejects the batch, with the report
+	message := mustFail(t, stub, addr1, RegisterServicesBatch, "user1",
+		`[{"name":"Bing Maps","type":"Mapping"},{"name":"OSM","type":"Mapping","metadata":{"docsUrl":"/docs"}}]`, "atomic")
+	if !strings.Contains(message, `"name":"OSM","status":"failed"`) {
+		t.Fatalf("message = %s", message)
+	}
+	mustFail(t, stub, addr1, QueryService, "Bing Maps")
+
+	// existing rows are skipped
+	payload := mustInvoke(t, stub, addr1, RegisterServicesBatch, "user1",
+		`[{"name":"Bing Maps","type":"Mapping"},{"name":"YouTube","type":"Video"}]`, "atomic")
+	var report batchReport
+	if err := json.Unmarshal(payload, &report); err != nil || batchStatuses(report) != "Bing Maps:registered,YouTube:exists" {
+		t.Fatalf("report = %s", payload)
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	rows := make([]string, MaxBatchSize+1)
+	for i := range rows {
+		rows[i] = fmt.Sprintf(`{"name":"Service %d","type":"Misc"}`, i)
+	}
+	mustFail(t, stub, addr1, RegisterServicesBatch, "user1", "["+strings.Join(rows, ",")+"]")
+	mustInvoke(t, stub, addr1, RegisterServicesBatch, "user1", "["+strings.Join(rows[1:], ",")+"]")
+	if s := getService(t, stub, fmt.Sprintf("Service %d", MaxBatchSize)); s.Type != "Misc" {
+		t.Fatalf("service = %+v", s)
+	}
+
+	mustFail(t, stub, addr1, RegisterServicesBatch, "user1", `[]`)
+	mustFail(t, stub, addr1, RegisterServicesBatch, "user1", `{"name":"OSM","type":"Mapping"}`)
+	mustFail(t, stub, addr1, RegisterServicesBatch, "user1", `[{"name":"OSM","type":"Mapping"}]`, "all")
+
+	// the developer sends the batch and is active
+	mustFail(t, stub, addr2, RegisterServicesBatch, "user1", `[{"name":"OSM","type":"Mapping"}]`)
+	mustFail(t, stub, addr1, RegisterServicesBatch, "user9", `[{"name":"OSM","type":"Mapping"}]`)
+	mustInvoke(t, stub, addr1, SuspendUser, "user2", "Spam.")
+	mustFail(t, stub, addr2, RegisterServicesBatch, "user2", `[{"name":"OSM","type":"Mapping"}]`)
+	mustFail(t, stub, addr1, QueryService, "OSM")
+}
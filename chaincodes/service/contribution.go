@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Contribution accounting
+// ==================================================================================
+//
+// A user's "Contribution" grows with the user's activity in the ecosystem.
+// Every activity adds its weight in points; the weights are stored on the
+// ledger and can be changed by the chaincode admin. Points are computed when
+// the activity happens, so changing a weight does not rewrite past scores.
+
+// Contribution activities
+const (
+	ActivityPublish  = "publish"  // the user's service is published for the first time
+	ActivityComposed = "composed" // the user's service is composed into another developer's mashup
+	ActivityReward   = "reward"   // the user's service is rewarded
+	ActivityMashup   = "mashup"   // the user creates a mashup of another developer's services
+)
+
+// Structure definition for the contribution weights
+type contributionWeights struct {
+	Publish  int `json:"publish"`
+	Composed int `json:"composed"`
+	Reward   int `json:"reward"`
+	Mashup   int `json:"mashup"`
+}
+
+// Weights written by Init when the ledger holds none
+var defaultContributionWeights = contributionWeights{
+	Publish:  10,
+	Composed: 5,
+	Reward:   2,
+	Mashup:   3,
+}
+
+func (w *contributionWeights) weight(activity string) int {
+	switch activity {
+	case ActivityPublish:
+		return w.Publish
+	case ActivityComposed:
+		return w.Composed
+	case ActivityReward:
+		return w.Reward
+	case ActivityMashup:
+		return w.Mashup
+	}
+	return 0
+}
+
+// Structure definition for the score of one activity
+type activityScore struct {
+	Count  int `json:"count"`
+	Points int `json:"points"`
+}
+
+// Structure definition for the response of queryContribution
+type contributionBreakdown struct {
+	Name         string                   `json:"name"`
+	Contribution int                      `json:"contribution"`
+	Activities   map[string]activityScore `json:"activities"`
+}
+
+// contributionCredits collects the activities of one transaction,
+// user name -> activity -> number of times.
+type contributionCredits map[string]map[string]int
+
+func (c contributionCredits) add(user_name string, activity string) {
+	if c[user_name] == nil {
+		c[user_name] = make(map[string]int)
+	}
+	c[user_name][activity]++
+}
+
+// getContributionWeights reads the weights from the ledger.
+func getContributionWeights(stub shim.ChaincodeStubInterface) (*contributionWeights, error) {
+	weightsAsBytes, err := stub.GetState(ContributionWeightsKey)
+	if err != nil {
+		return nil, errors.New("Fail to get contribution weights: " + err.Error())
+	}
+	weights := defaultContributionWeights
+	if weightsAsBytes == nil {
+		return &weights, nil
+	}
+	err = json.Unmarshal(weightsAsBytes, &weights)
+	if err != nil {
+		return nil, errors.New("Error unmarshal contribution weights.")
+	}
+	return &weights, nil
+}
+
+// creditContributions adds the points of the collected activities to the
+// users and moves them on the leaderboards. It returns the new contribution
+// of every credited user.
+// Every user record is read and written once, as writes of a transaction
+// are not visible to its later reads.
+func creditContributions(stub shim.ChaincodeStubInterface, credits contributionCredits) (map[string]int, error) {
+	scores := make(map[string]int)
+	if len(credits) == 0 {
+		return scores, nil
+	}
+	weights, err := getContributionWeights(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(credits))
+	for name := range credits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		user_key := UserPrefix + name
+		userAsBytes, err := stub.GetState(user_key)
+		if err != nil {
+			return nil, errors.New("Fail to get user: " + err.Error())
+		} else if userAsBytes == nil {
+			return nil, errors.New("This user does not exist: " + name)
+		}
+		var userJSON user
+		err = json.Unmarshal(userAsBytes, &userJSON)
+		if err != nil {
+			return nil, errors.New("Error unmarshal user bytes.")
+		}
+		// removed users are not credited any more
+		if userJSON.Removed {
+			continue
+		}
+		if userJSON.Activities == nil {
+			userJSON.Activities = make(map[string]activityScore)
+		}
+		old_contribution := userJSON.Contribution
+		for activity, times := range credits[name] {
+			points := weights.weight(activity) * times
+			score := userJSON.Activities[activity]
+			score.Count += times
+			score.Points += points
+			userJSON.Activities[activity] = score
+			userJSON.Contribution += points
+		}
+
+		userJSONasBytes, err := json.Marshal(userJSON)
+		if err != nil {
+			return nil, err
+		}
+		err = stub.PutState(user_key, userJSONasBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		err = moveLeaderboardEntries(stub, name, old_contribution, userJSON.Contribution)
+		if err != nil {
+			return nil, err
+		}
+		scores[name] = userJSON.Contribution
+	}
+	return scores, nil
+}
+
+// ===============================================================
+// queryContribution: query a user's contribution by activity type
+// ===============================================================
+func (t *serviceChaincode) queryContribution(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var user_name string
+	var err error
+
+	user_name = args[0]
+
+	// check if user exists
+	userAsBytes, err := stub.GetState(UserPrefix + user_name)
+	if err != nil {
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error("This user does not exist: " + user_name)
+	}
+	var userJSON user
+	err = json.Unmarshal(userAsBytes, &userJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal user bytes.")
+	}
+
+	// list every activity, including the ones without points yet
+	breakdown := contributionBreakdown{userJSON.Name, userJSON.Contribution, make(map[string]activityScore)}
+	for _, activity := range []string{ActivityPublish, ActivityComposed, ActivityReward, ActivityMashup} {
+		breakdown.Activities[activity] = userJSON.Activities[activity]
+	}
+
+	breakdownAsBytes, err := json.Marshal(breakdown)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(breakdownAsBytes)
+}
+
+// ===============================================================
+// setContributionWeights: update the weights of the activities
+// args[0] is a JSON document, omitted activities keep their weight
+// ===============================================================
+func (t *serviceChaincode) setContributionWeights(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// STEP 0: only admins can change the weights
+	_, err := authorize(stub, nil, R_Admin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: merge the new weights into the current ones
+	weights, err := getContributionWeights(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = json.Unmarshal([]byte(args[0]), weights)
+	if err != nil {
+		return shim.Error("Error unmarshal contribution weights: " + err.Error())
+	}
+	if weights.Publish < 0 || weights.Composed < 0 || weights.Reward < 0 || weights.Mashup < 0 {
+		return shim.Error("Contribution weights can not be negative.")
+	}
+
+	// STEP 2: store the weights
+	weightsAsBytes, err := json.Marshal(weights)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(ContributionWeightsKey, weightsAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, SetContributionWeights, []string{ContributionWeightsKey}, "", "",
+		events.ContributionWeights{Publish: weights.Publish, Composed: weights.Composed, Reward: weights.Reward, Mashup: weights.Mashup})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(weightsAsBytes)
+}
+
+// ===============================================================
+// queryContributionWeights: query the weights of the activities
+// ===============================================================
+func (t *serviceChaincode) queryContributionWeights(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	weights, err := getContributionWeights(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	weightsAsBytes, err := json.Marshal(weights)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(weightsAsBytes)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+func getContribution(t *testing.T, stub *shimtest.Stub, name string) contributionBreakdown {
+	t.Helper()
+	var breakdown contributionBreakdown
+	payload := mustInvoke(t, stub, addr1, QueryContribution, name)
+	if err := json.Unmarshal(payload, &breakdown); err != nil {
+		t.Fatalf("unmarshal contribution %s: %v", payload, err)
+	}
+	return breakdown
+}
+
+func TestContribution(t *testing.T) {
+	stub := newEcosystemStub(t)
+	w := defaultContributionWeights
+
+	// publishing counts once, republishing after an invalidation does not
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+	mustInvoke(t, stub, addr1, InvalidateService, "Google Maps", "Maintenance.")
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+
+	// user3 composes one service of user1 and two of user2,
+	// user2 then composes its own service with one of user1
+	mustInvoke(t, stub, addr3, CreateMashup, "MapTweets", "Mapping", "Tweets on a map.",
+		"Google Maps", "Twitter", "YouTube")
+	mustInvoke(t, stub, addr2, CreateMashup, "MapVideos", "Mapping", "Videos on a map.",
+		"Google Maps", "YouTube")
+
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	mustInvoke(t, stub, addr3, RewardService, "Twitter", tokenType, "5")
+
+	user1 := getContribution(t, stub, "user1")
+	if want := w.Publish + 2*w.Composed; user1.Contribution != want {
+		t.Fatalf("user1 contribution = %d, want %d: %+v", user1.Contribution, want, user1)
+	}
+	if s := user1.Activities[ActivityComposed]; s.Count != 2 || s.Points != 2*w.Composed {
+		t.Fatalf("user1 composed score = %+v", s)
+	}
+	if s := user1.Activities[ActivityPublish]; s.Count != 1 {
+		t.Fatalf("user1 publish score = %+v", s)
+	}
+
+	user2 := getContribution(t, stub, "user2")
+	if want := w.Publish + 2*w.Composed + w.Mashup + w.Reward; user2.Contribution != want {
+		t.Fatalf("user2 contribution = %d, want %d: %+v", user2.Contribution, want, user2)
+	}
+	if s := user2.Activities[ActivityReward]; s.Count != 1 || s.Points != w.Reward {
+		t.Fatalf("user2 reward score = %+v", s)
+	}
+
+	user3 := getContribution(t, stub, "user3")
+	if user3.Contribution != w.Mashup || len(user3.Activities) != 4 {
+		t.Fatalf("unexpected user3 contribution: %+v", user3)
+	}
+	if u := getUser(t, stub, "user3"); u.Contribution != w.Mashup {
+		t.Fatalf("user record contribution = %d, want %d", u.Contribution, w.Mashup)
+	}
+
+	mustFail(t, stub, addr1, QueryContribution, "nobody")
+	mustFail(t, stub, addr1, QueryContribution)
+}
+
+func TestSelfMashupContribution(t *testing.T) {
+	stub := newEcosystemStub(t)
+	w := defaultContributionWeights
+
+	// a mashup of one's own services earns no points
+	mustInvoke(t, stub, addr2, CreateMashup, "TweetVideos", "Social", "Tweets and videos.", "Twitter", "YouTube")
+	if u := getContribution(t, stub, "user2"); u.Contribution != 0 {
+		t.Fatalf("user2 contribution = %+v", u)
+	}
+
+	// nor does one of services the creator maintains
+	mustInvoke(t, stub, addr2, AddMaintainer, "Twitter", "user3")
+	mustInvoke(t, stub, addr3, CreateMashup, "MoreTweets", "Social", "More tweets.", "Twitter")
+	if u2, u3 := getContribution(t, stub, "user2"), getContribution(t, stub, "user3"); u2.Contribution != 0 || u3.Contribution != 0 {
+		t.Fatalf("contributions = %+v, %+v", u2, u3)
+	}
+
+	// one foreign component is enough
+	mustInvoke(t, stub, addr3, CreateMashup, "MapTweets", "Mapping", "Tweets on a map.", "Twitter", "Google Maps")
+	if u1, u3 := getContribution(t, stub, "user1"), getContribution(t, stub, "user3"); u1.Contribution != w.Composed || u3.Contribution != w.Mashup {
+		t.Fatalf("contributions = %+v, %+v", u1, u3)
+	}
+}
+
+func TestContributionWeights(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	var weights contributionWeights
+	if err := json.Unmarshal(mustInvoke(t, stub, addr2, QueryContributionWeights), &weights); err != nil {
+		t.Fatal(err)
+	}
+	if weights != defaultContributionWeights {
+		t.Fatalf("initial weights = %+v", weights)
+	}
+
+	// only the admin, the instantiator, can change the weights
+	mustFail(t, stub, addr2, SetContributionWeights, `{"publish":100}`)
+	mustFail(t, stub, addr1, SetContributionWeights, `{"publish":-1}`)
+	mustFail(t, stub, addr1, SetContributionWeights, `publish=100`)
+	mustInvoke(t, stub, addr1, SetContributionWeights, `{"publish":100}`)
+
+	if err := json.Unmarshal(mustInvoke(t, stub, addr2, QueryContributionWeights), &weights); err != nil {
+		t.Fatal(err)
+	}
+	want := defaultContributionWeights
+	want.Publish = 100
+	if weights != want {
+		t.Fatalf("weights = %+v, want %+v", weights, want)
+	}
+
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	if c := getContribution(t, stub, "user2"); c.Contribution != 100 {
+		t.Fatalf("contribution with the new weight = %d, want 100", c.Contribution)
+	}
+
+	// an upgrade keeps the admin and the weights
+	stub.InitAs(addr2)
+	mustFail(t, stub, addr2, SetContributionWeights, `{"publish":1}`)
+	if err := json.Unmarshal(mustInvoke(t, stub, addr2, QueryContributionWeights), &weights); err != nil || weights != want {
+		t.Fatalf("weights after upgrade = %+v, %v", weights, err)
+	}
+}
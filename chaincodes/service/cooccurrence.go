@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Service co-occurrence
+// ==================================================================================
+//
+// For a plain (non-mashup) service, "Composition" is its co-occurrence
+// document: how many mashups composed it together with each other service.
+// "ComposedCount" counts the mashups composing a service at all.
+// createMashup updates the documents of all its components in the same
+// transaction as the mashup itself.
+
+// Structure definition for one row of a co-occurrence document
+type coOccurrence struct {
+	Service string `json:"service"`
+	Count   int    `json:"count"`
+}
+
+// recordCoOccurrence counts one more mashup for every component and, for
+// the plain services among them, one more use together with each of the
+// other components, and stores them.
+// components maps the service names to their records as read in the
+// transaction; every record is written once.
+func recordCoOccurrence(stub shim.ChaincodeStubInterface, components map[string]*service) error {
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		serviceJSON := components[name]
+		serviceJSON.ComposedCount++
+		// a mashup's Composition lists its own components
+		if !serviceJSON.IsMashup {
+			if serviceJSON.Composition == nil {
+				serviceJSON.Composition = make(map[string]int)
+			}
+			for _, other := range names {
+				if other != name {
+					serviceJSON.Composition[other]++
+				}
+			}
+		}
+
+		serviceJSONasBytes, err := json.Marshal(serviceJSON)
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(ServicePrefix+name, serviceJSONasBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedCoOccurrence lists a co-occurrence document by count, then by name.
+func sortedCoOccurrence(composition map[string]int) []coOccurrence {
+	rows := make([]coOccurrence, 0, len(composition))
+	for name, count := range composition {
+		rows = append(rows, coOccurrence{name, count})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].Service < rows[j].Service
+	})
+	return rows
+}
+
+// getPlainService reads a service that must exist and must not be a mashup.
+func getPlainService(stub shim.ChaincodeStubInterface, service_name string) (*service, error) {
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return nil, err
+	}
+	if serviceJSON.IsMashup {
+		return nil, errors.New("Co-occurrence is only recorded for services, not mashups: " + service_name)
+	}
+	return serviceJSON, nil
+}
+
+// ==================================================================
+// queryCoOccurrence: query the services most often composed together
+// with a service, sorted by count
+// ==================================================================
+func (t *serviceChaincode) queryCoOccurrence(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var err error
+
+	service_name = args[0]
+	top := 0
+	if len(args) > 1 && args[1] != "" {
+		top, err = strconv.Atoi(args[1])
+		if err != nil || top <= 0 {
+			return shim.Error("Expecting positive integer value for the number of services.")
+		}
+	}
+
+	serviceJSON, err := getPlainService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	rows := sortedCoOccurrence(serviceJSON.Composition)
+	if top > 0 && len(rows) > top {
+		rows = rows[:top]
+	}
+
+	rowsAsBytes, err := json.Marshal(rows)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(rowsAsBytes)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+func getCoOccurrence(t *testing.T, stub *shimtest.Stub, args ...string) string {
+	t.Helper()
+	var rows []coOccurrence
+	payload := mustInvoke(t, stub, addr1, QueryCoOccurrence, args...)
+	if err := json.Unmarshal(payload, &rows); err != nil {
+		t.Fatalf("unmarshal co-occurrence %s: %v", payload, err)
+	}
+	out := make([]string, len(rows))
+	for i, r := range rows {
+		out[i] = fmt.Sprintf("%s:%d", r.Service, r.Count)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestCoOccurrence(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, RegisterService, "Flickr", "Photos", "Photos API.", "user2")
+
+	mustInvoke(t, stub, addr3, CreateMashup, "MapTweets", "Mapping", "Tweets on a map.", "Google Maps", "Twitter")
+	mustInvoke(t, stub, addr3, CreateMashup, "MapMedia", "Mapping", "Media on a map.",
+		"Google Maps", "YouTube", "Flickr", "Twitter")
+	mustInvoke(t, stub, addr3, CreateMashup, "MapPhotos", "Mapping", "Photos on a map.", "Google Maps", "Flickr")
+
+	if got := getCoOccurrence(t, stub, "Google Maps"); got != "Flickr:2,Twitter:2,YouTube:1" {
+		t.Fatalf("Google Maps co-occurrence = %s", got)
+	}
+	if got := getCoOccurrence(t, stub, "Google Maps", "1"); got != "Flickr:2" {
+		t.Fatalf("Google Maps top 1 = %s", got)
+	}
+	if got := getCoOccurrence(t, stub, "YouTube"); got != "Flickr:1,Google Maps:1,Twitter:1" {
+		t.Fatalf("YouTube co-occurrence = %s", got)
+	}
+
+	// mashups keep listing their components
+	if m := getService(t, stub, "MapTweets"); len(m.Composition) != 2 {
+		t.Fatalf("mashup composition changed: %+v", m.Composition)
+	}
+	mustFail(t, stub, addr1, QueryCoOccurrence, "MapTweets")
+
+	if got := getCoOccurrence(t, stub, "Flickr"); got != "Google Maps:2,Twitter:1,YouTube:1" {
+		t.Fatalf("Flickr co-occurrence = %s", got)
+	}
+	mustFail(t, stub, addr1, QueryCoOccurrence, "Bing Maps")
+	mustFail(t, stub, addr1, QueryCoOccurrence, "Google Maps", "-1")
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Mashup dependency graph
+// ==================================================================================
+//
+// A mashup depends on the services of its "Composition", which can be mashups
+// themselves. queryDependencies walks these edges down from a mashup;
+// queryDependents walks them up from a service through the service~mashup
+// ("used by") index that createMashup maintains.
+//
+// The walk is breadth-first, so every service is reported with its shortest
+// distance from the queried one, and stops at the depth limit.
+
+// Depth limits of the dependency queries
+const (
+	DefaultDependencyDepth = 10
+	MaxDependencyDepth     = 50
+)
+
+// Directions of a dependency walk
+const (
+	DirectionDependencies = "dependencies"
+	DirectionDependents   = "dependents"
+)
+
+// Structure definition for a service reached by a dependency walk
+type dependencyNode struct {
+	Service  string `json:"service"`
+	Depth    int    `json:"depth"`
+	Status   string `json:"status"`
+	IsMashup bool   `json:"isMashup"`
+}
+
+// Structure definition for an edge "From composes To"
+type dependencyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Structure definition for the result of a dependency walk
+type dependencyGraph struct {
+	Service   string           `json:"service"`
+	Direction string           `json:"direction"`
+	Nodes     []dependencyNode `json:"nodes"`
+	Edges     []dependencyEdge `json:"edges"`
+	MaxDepth  int              `json:"maxDepth"`  // deepest level reached
+	Truncated bool             `json:"truncated"` // the depth limit hid further services
+	HasCycle  bool             `json:"hasCycle"`
+	Cycle     []string         `json:"cycle,omitempty"` // one cycle found, first service repeated last
+}
+
+// addUsedByIndex records that mashup_name composes service_name.
+func addUsedByIndex(stub shim.ChaincodeStubInterface, service_name string, mashup_name string) error {
+	index_key, err := stub.CreateCompositeKey(UsedByIndex, []string{service_name, mashup_name})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(index_key, []byte{0x00})
+}
+
+// usedBy lists the mashups composing service_name.
+func usedBy(stub shim.ChaincodeStubInterface, service_name string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(UsedByIndex, []string{service_name})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	mashups := []string{}
+	for resultsIterator.HasNext() {
+		indexResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		mashups = append(mashups, keyParts[1])
+	}
+	return mashups, nil
+}
+
+// readService reads an existing service.
+func readService(stub shim.ChaincodeStubInterface, service_name string) (*service, error) {
+	serviceAsBytes, err := stub.GetState(ServicePrefix + service_name)
+	if err != nil {
+		return nil, errors.New("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return nil, errors.New("This service does not exist: " + service_name)
+	}
+	var serviceJSON service
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return nil, errors.New("Error unmarshal service bytes.")
+	}
+	return &serviceJSON, nil
+}
+
+// neighbours lists the services one step away from serviceJSON in direction.
+func neighbours(stub shim.ChaincodeStubInterface, serviceJSON *service, direction string) ([]string, error) {
+	if direction == DirectionDependents {
+		return usedBy(stub, serviceJSON.Name)
+	}
+	// a plain service's Composition is its co-occurrence document
+	if !serviceJSON.IsMashup {
+		return nil, nil
+	}
+	names := make([]string, 0, len(serviceJSON.Composition))
+	for name := range serviceJSON.Composition {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// walkDependencies walks the dependency graph from root in direction,
+// at most max_depth steps away.
+func walkDependencies(stub shim.ChaincodeStubInterface, root string, direction string, max_depth int) (*dependencyGraph, error) {
+	rootJSON, err := readService(stub, root)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &dependencyGraph{Service: root, Direction: direction,
+		Nodes: []dependencyNode{}, Edges: []dependencyEdge{}}
+	depth := map[string]int{root: 0}
+	adjacency := make(map[string][]string)
+	level := []*service{rootJSON}
+
+	// STEP 0: breadth-first walk, level by level
+	for d := 0; len(level) > 0; d++ {
+		var next []*service
+		for _, current := range level {
+			names, err := neighbours(stub, current, direction)
+			if err != nil {
+				return nil, err
+			}
+			if d == max_depth {
+				if len(names) > 0 {
+					graph.Truncated = true
+				}
+				continue
+			}
+			for _, name := range names {
+				adjacency[current.Name] = append(adjacency[current.Name], name)
+				if direction == DirectionDependents {
+					graph.Edges = append(graph.Edges, dependencyEdge{name, current.Name})
+				} else {
+					graph.Edges = append(graph.Edges, dependencyEdge{current.Name, name})
+				}
+				if _, seen := depth[name]; seen {
+					continue
+				}
+				depth[name] = d + 1
+				nodeJSON, err := readService(stub, name)
+				if err != nil {
+					return nil, err
+				}
+				graph.Nodes = append(graph.Nodes, dependencyNode{name, d + 1, nodeJSON.Status, nodeJSON.IsMashup})
+				if d+1 > graph.MaxDepth {
+					graph.MaxDepth = d + 1
+				}
+				next = append(next, nodeJSON)
+			}
+		}
+		level = next
+	}
+
+	// STEP 1: look for a cycle among the walked edges
+	graph.Cycle = findCycle(root, adjacency)
+	graph.HasCycle = graph.Cycle != nil
+	return graph, nil
+}
+
+// findCycle returns one cycle reachable from root in adjacency, or nil.
+func findCycle(root string, adjacency map[string][]string) []string {
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[string]int)
+	var path []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = onPath
+		path = append(path, name)
+		for _, next := range adjacency[name] {
+			switch state[next] {
+			case onPath:
+				// the cycle runs from next's position on the path back to next
+				for i, p := range path {
+					if p == next {
+						return append(append([]string{}, path[i:]...), next)
+					}
+				}
+			case unvisited:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		return nil
+	}
+	return visit(root)
+}
+
+// parseDependencyDepth reads a depth limit argument, "" means the default one.
+func parseDependencyDepth(arg string) (int, error) {
+	if arg == "" {
+		return DefaultDependencyDepth, nil
+	}
+	max_depth, err := strconv.Atoi(arg)
+	if err != nil || max_depth <= 0 {
+		return 0, errors.New("Expecting positive integer value for depth.")
+	}
+	if max_depth > MaxDependencyDepth {
+		max_depth = MaxDependencyDepth
+	}
+	return max_depth, nil
+}
+
+// ==========================================================================
+// queryDependencies: query the services a mashup depends on, transitively
+// ==========================================================================
+func (t *serviceChaincode) queryDependencies(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.queryDependencyGraph(stub, args, DirectionDependencies)
+}
+
+// ==========================================================================
+// queryDependents: query the mashups depending on a service, transitively
+// ==========================================================================
+func (t *serviceChaincode) queryDependents(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.queryDependencyGraph(stub, args, DirectionDependents)
+}
+
+func (t *serviceChaincode) queryDependencyGraph(stub shim.ChaincodeStubInterface, args []string, direction string) pb.Response {
+	max_depth := DefaultDependencyDepth
+	var err error
+	if len(args) > 1 {
+		max_depth, err = parseDependencyDepth(args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	graph, err := walkDependencies(stub, args[0], direction, max_depth)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	graphAsBytes, err := json.Marshal(graph)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(graphAsBytes)
+}
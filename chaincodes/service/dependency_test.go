@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+func getDependencyGraph(t *testing.T, stub *shimtest.Stub, function string, args ...string) dependencyGraph {
+	t.Helper()
+	var graph dependencyGraph
+	payload := mustInvoke(t, stub, addr1, function, args...)
+	if err := json.Unmarshal(payload, &graph); err != nil {
+		t.Fatalf("unmarshal dependency graph %s: %v", payload, err)
+	}
+	return graph
+}
+
+func nodeDepths(graph dependencyGraph) string {
+	rows := make([]string, len(graph.Nodes))
+	for i, n := range graph.Nodes {
+		rows[i] = fmt.Sprintf("%s:%d", n.Service, n.Depth)
+	}
+	return strings.Join(rows, ",")
+}
+
+// putMashup stores a mashup of components and its used-by index entries
+// directly, to build graphs createMashup can not.
+func putMashup(t *testing.T, stub *shimtest.Stub, name string, components ...string) {
+	t.Helper()
+	putMashupBy(t, stub, "", name, components...)
+}
+
+// putMashupBy is putMashup for a mashup of the given developer.
+func putMashupBy(t *testing.T, stub *shimtest.Stub, developer string, name string, components ...string) {
+	t.Helper()
+	composition := make(map[string]int)
+	for _, c := range components {
+		composition[c]++
+		key, _ := stub.CreateCompositeKey(UsedByIndex, []string{c, name})
+		stub.State[key] = []byte{0x00}
+	}
+	mashupAsBytes, _ := json.Marshal(service{Name: name, Type: "Mashup", Developer: developer,
+		Status: S_Created, IsMashup: true, Composition: composition})
+	stub.State[ServicePrefix+name] = mashupAsBytes
+}
+
+func TestDependencies(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr3, CreateMashup, "MapVideos", "Video", "Videos on a map.", "Google Maps", "YouTube")
+	mustInvoke(t, stub, addr3, CreateMashup, "SocialVideos", "Video", "Shared videos.", "Twitter", "YouTube")
+
+	graph := getDependencyGraph(t, stub, QueryDependencies, "MapVideos")
+	if got := nodeDepths(graph); got != "Google Maps:1,YouTube:1" {
+		t.Fatalf("dependencies of MapVideos = %s", got)
+	}
+	if graph.MaxDepth != 1 || graph.Truncated || graph.HasCycle || len(graph.Edges) != 2 {
+		t.Fatalf("unexpected graph: %+v", graph)
+	}
+	if e := graph.Edges[0]; e.From != "MapVideos" || e.To != "Google Maps" {
+		t.Fatalf("edge = %+v", e)
+	}
+
+	graph = getDependencyGraph(t, stub, QueryDependents, "YouTube")
+	if got := nodeDepths(graph); got != "MapVideos:1,SocialVideos:1" {
+		t.Fatalf("dependents of YouTube = %s", got)
+	}
+	if e := graph.Edges[0]; e.From != "MapVideos" || e.To != "YouTube" {
+		t.Fatalf("edge = %+v", e)
+	}
+
+	// a plain service depends on nothing, its co-occurrence is not a dependency
+	if graph := getDependencyGraph(t, stub, QueryDependencies, "YouTube"); len(graph.Nodes) != 0 {
+		t.Fatalf("dependencies of YouTube = %s", nodeDepths(graph))
+	}
+
+	mustFail(t, stub, addr1, QueryDependencies, "Nothing")
+	mustFail(t, stub, addr1, QueryDependents, "YouTube", "0")
+	mustFail(t, stub, addr1, QueryDependents, "YouTube", "deep")
+	mustFail(t, stub, addr1, QueryDependents)
+}
+
+func TestDependencyDepth(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr3, CreateMashup, "MapVideos", "Video", "Videos on a map.", "Google Maps", "YouTube")
+	putMashup(t, stub, "Level2", "MapVideos", "Twitter")
+	putMashup(t, stub, "Level3", "Level2", "YouTube")
+
+	// YouTube is reached directly and through two mashups, the shortest depth wins
+	graph := getDependencyGraph(t, stub, QueryDependencies, "Level3")
+	if got := nodeDepths(graph); got != "Level2:1,YouTube:1,MapVideos:2,Twitter:2,Google Maps:3" {
+		t.Fatalf("dependencies of Level3 = %s", got)
+	}
+	if graph.MaxDepth != 3 || graph.Truncated || graph.HasCycle {
+		t.Fatalf("unexpected graph: %+v", graph)
+	}
+
+	graph = getDependencyGraph(t, stub, QueryDependencies, "Level3", "2")
+	if graph.MaxDepth != 2 || !graph.Truncated || strings.Contains(nodeDepths(graph), "Google Maps") {
+		t.Fatalf("depth limited graph: %+v", graph)
+	}
+
+	graph = getDependencyGraph(t, stub, QueryDependents, "Google Maps")
+	if got := nodeDepths(graph); got != "MapVideos:1,Level2:2,Level3:3" {
+		t.Fatalf("dependents of Google Maps = %s", got)
+	}
+}
+
+func TestDependencyCycle(t *testing.T) {
+	stub := newEcosystemStub(t)
+	putMashup(t, stub, "A", "B", "Twitter")
+	putMashup(t, stub, "B", "C")
+	putMashup(t, stub, "C", "A")
+
+	graph := getDependencyGraph(t, stub, QueryDependencies, "A")
+	if !graph.HasCycle || strings.Join(graph.Cycle, ">") != "A>B>C>A" {
+		t.Fatalf("cycle = %v", graph.Cycle)
+	}
+	if got := nodeDepths(graph); got != "B:1,Twitter:1,C:2" {
+		t.Fatalf("dependencies of A = %s", got)
+	}
+
+	graph = getDependencyGraph(t, stub, QueryDependents, "Twitter")
+	if !graph.HasCycle || strings.Join(graph.Cycle, ">") != "A>C>B>A" {
+		t.Fatalf("cycle = %v", graph.Cycle)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+)
+
+// Chaincode events
+// ==================================================================================
+//
+// Every function changing the ledger emits one event named after it. Its
+// payload is a versioned envelope with the ledger keys of the records the
+// event is about, the sender's address and the status of the user or service
+// before and after the transaction, left empty by configuration, gateway and
+// usage events; the details go under "data".
+//
+// The envelope and the data of every event are the types of the
+// chaincodes/events package, which decodes them for listeners. Only add
+// fields within a version.
+
+// Statuses of a user in events
+const (
+	U_Registered = events.UserRegistered
+	U_Suspended  = events.UserSuspended
+	U_Removed    = events.UserRemoved
+)
+
+// emitEvent sets the event of the transaction. A transaction has one event,
+// so a function emits it once, after its last write.
+func emitEvent(stub shim.ChaincodeStubInterface, name string, keys []string, before string, after string, data interface{}) error {
+	actor, err := senderAddress(stub)
+	if err != nil {
+		return err
+	}
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	dataAsBytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	eventAsBytes, err := json.Marshal(events.Event{Version: events.Version, Name: name, TxID: stub.GetTxID(),
+		Time: txTime.Format(time.UnixDate), Actor: actor, Keys: keys, Before: before, After: after, Data: dataAsBytes})
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(name, eventAsBytes)
+}
+
+// impactKeys lists the key of a service and of the mashups its status
+// change affected.
+func impactKeys(service_name string, mashups []events.ImpactedMashup) []string {
+	keys := []string{ServicePrefix + service_name}
+	for _, mashup := range mashups {
+		keys = append(keys, ServicePrefix+mashup.Service)
+	}
+	return keys
+}
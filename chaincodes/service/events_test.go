@@ -0,0 +1,174 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+// lastEvent decodes the event of the stub's last transaction with the
+// published schema and describes its envelope as
+// "name actor keys before>after".
+func lastEvent(t *testing.T, stub *shimtest.Stub) (string, interface{}) {
+	t.Helper()
+	e := stub.LastEvent()
+	if e == nil || e.TxID != stub.GetTxID() {
+		t.Fatal("the last transaction emitted no event")
+	}
+	event, data, err := events.Decode(e.Payload)
+	if err != nil {
+		t.Fatalf("decode event %s: %v", e.Payload, err)
+	}
+	if event.Name != e.Name || event.TxID != e.TxID || event.Time == "" {
+		t.Fatalf("event %s in %s", e.Payload, e.Name)
+	}
+	return event.Name + " " + event.Actor + " " + strings.Join(event.Keys, ",") + " " + event.Before + ">" + event.After, data
+}
+
+func TestMutationsEmitEvents(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	steps := []struct {
+		sender   string
+		function string
+		args     []string
+		envelope string
+		data     interface{}
+	}{
+		{addr4, RegisterUser, []string{"user4", "A new developer."},
+			"registerUser " + addr4 + " USER_user4 >registered",
+			&events.User{Name: "user4", Address: addr4}},
+		{addr4, RegisterService, []string{"Flickr", "Photo", "Photos API.", "user4"},
+			"registerService " + addr4 + " SER_Flickr >created",
+			&events.ServiceRegistered{Service: "Flickr", Type: "Photo", Developer: "user4"}},
+		{addr4, PublishService, []string{"Flickr"},
+			"publishService " + addr4 + " SER_Flickr created>available",
+			&events.ServicePublished{Service: "Flickr", Developer: "user4", Mashups: []events.ImpactedMashup{}}},
+		{addr4, EditService, []string{"Flickr", "Description", "Photos and albums API."},
+			"editService " + addr4 + " SER_Flickr available>available",
+			&events.ServiceEdited{Service: "Flickr", Field: "Description", OldValue: "Photos API.", NewValue: "Photos and albums API."}},
+		{addr3, CreateMashup, []string{"PhotoMap", "Mapping", "Photos on a map.", "Google Maps", "Flickr"},
+			"createMashup " + addr3 + " SER_PhotoMap >created",
+			&events.MashupCreated{Service: "PhotoMap", Type: "Mapping", Developer: "user3",
+				Composition: map[string]int{"Google Maps": 1, "Flickr": 1}}},
+		{addr1, RewardService, []string{"Flickr", tokenType, "10", "Nice photos."},
+			"rewardService " + addr1 + " SER_Flickr,REWARD_{tx} available>available",
+			&events.ServiceRewarded{Service: "Flickr", Developer: "user4", TokenType: tokenType, Amount: "10", Memo: "Nice photos."}},
+		{addr4, SetServicePrice, []string{"Flickr", tokenType, "2"},
+			"setServicePrice " + addr4 + " SER_Flickr available>available",
+			&events.ServicePriced{Service: "Flickr", TokenType: tokenType, PerCall: "2"}},
+		{addr3, RateService, []string{"Flickr", "4"},
+			"rateService " + addr3 + " SER_Flickr,USER_user3 available>available",
+			&events.ServiceRated{Service: "Flickr", User: "user3", Stars: 4}},
+		{addr3, RateService, []string{"Flickr", "2", "Slower now."},
+			"rateService " + addr3 + " SER_Flickr,USER_user3 available>available",
+			&events.ServiceRated{Service: "Flickr", User: "user3", Stars: 2, PreviousStars: 4}},
+		{addr4, DeprecateService, []string{"Flickr", "Google Maps"},
+			"deprecateService " + addr4 + " SER_Flickr,SER_Google Maps available>deprecated",
+			&events.ServiceDeprecated{Service: "Flickr", Developer: "user4", Successor: "Google Maps"}},
+		{addr4, InvalidateService, []string{"Flickr", "Shut down."},
+			"invalidateService " + addr4 + " SER_Flickr,SER_PhotoMap deprecated>invalid",
+			&events.ServiceInvalidated{Service: "Flickr", Developer: "user4", Reason: "Shut down.",
+				Mashups: []events.ImpactedMashup{{Service: "PhotoMap", Developer: "user3", Health: H_Degraded, BrokenComponents: []string{"Flickr"}}}}},
+		{addr4, RemoveUser, []string{"user4", RemoveOrphan},
+			"removeUser " + addr4 + " USER_user4,SER_Flickr registered>removed",
+			&events.User{Name: "user4", Address: addr4, Orphaned: []string{"Flickr"}}},
+	}
+	for _, step := range steps {
+		mustInvoke(t, stub, step.sender, step.function, step.args...)
+		envelope, data := lastEvent(t, stub)
+		if want := strings.Replace(step.envelope, "{tx}", stub.GetTxID(), 1); envelope != want {
+			t.Fatalf("%s envelope = %s, want %s", step.function, envelope, want)
+		}
+		if !reflect.DeepEqual(data, step.data) {
+			t.Fatalf("%s data = %+v, want %+v", step.function, data, step.data)
+		}
+	}
+
+	// failed transactions emit nothing
+	count := len(stub.Events)
+	mustFail(t, stub, addr1, PublishService, "Twitter")
+	if len(stub.Events) != count {
+		t.Fatalf("a failed transaction emitted %+v", stub.LastEvent())
+	}
+}
+
+func TestOperationsEmitEvents(t *testing.T) {
+	stub := newMeteredStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	key := func(index string, attributes ...string) string {
+		k, err := stub.CreateCompositeKey(index, attributes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	expiry := func(d time.Duration) string { return start.Add(d).Format(time.UnixDate) }
+	stub.SetTime(start)
+
+	steps := []struct {
+		sender   string
+		function string
+		args     []string
+		keys     []string
+		status   string // before and after
+		data     interface{}
+	}{
+		{addr1, SetContributionWeights, []string{`{"publish":12}`},
+			[]string{ContributionWeightsKey}, "",
+			&events.ContributionWeights{Publish: 12, Composed: 5, Reward: 2, Mashup: 3}},
+		{addr1, SetIncentivePolicy, []string{`{"royaltyDepth":2}`},
+			[]string{IncentivePolicyKey}, "",
+			&events.IncentivePolicy{TokenType: "INK", ComponentFee: "10", MinFee: "0", MaxFee: "0",
+				FeeBasis: FeePerDeveloper, RoyaltyDepth: 2}},
+		{addr1, AuthorizeGateway, []string{addr4},
+			[]string{key(GatewayIndex, addr4)}, "", &events.Gateway{Address: addr4}},
+		{addr1, RevokeGateway, []string{addr4},
+			[]string{key(GatewayIndex, addr4)}, "", &events.Gateway{Address: addr4}},
+		{addr2, SetSubscriptionPlan, []string{"Twitter", "basic", tokenType, "100", "720h", "1000"},
+			[]string{"SER_Twitter", key(PlanIndex, "Twitter", "basic")}, S_Available,
+			&events.Plan{Service: "Twitter", Plan: "basic", TokenType: tokenType, Price: "100", Duration: "720h0m0s", Quota: 1000}},
+		{addr3, Subscribe, []string{"Twitter", "basic"},
+			[]string{"SER_Twitter", key(SubscriptionIndex, "Twitter", addr3)}, S_Available,
+			&events.Subscription{Service: "Twitter", Consumer: addr3, Plan: "basic", TokenType: tokenType,
+				Paid: "100", Expiry: expiry(720 * time.Hour), Status: SubActive}},
+		{addr3, RenewSubscription, []string{"Twitter"},
+			[]string{"SER_Twitter", key(SubscriptionIndex, "Twitter", addr3)}, S_Available,
+			&events.Subscription{Service: "Twitter", Consumer: addr3, Plan: "basic", TokenType: tokenType,
+				Paid: "100", Expiry: expiry(1440 * time.Hour), Status: SubActive}},
+		{addr3, CancelSubscription, []string{"Twitter"},
+			[]string{"SER_Twitter", key(SubscriptionIndex, "Twitter", addr3)}, S_Available,
+			&events.Subscription{Service: "Twitter", Consumer: addr3, Plan: "basic", TokenType: tokenType,
+				Paid: "0", Expiry: expiry(1440 * time.Hour), Status: SubCancelled}},
+		{addr2, RemoveSubscriptionPlan, []string{"Twitter", "basic"},
+			[]string{"SER_Twitter", key(PlanIndex, "Twitter", "basic")}, S_Available,
+			&events.Plan{Service: "Twitter", Plan: "basic", TokenType: tokenType, Price: "100", Duration: "720h0m0s", Quota: 1000}},
+		{gateway, RecordUsage, []string{"2018-03", "b1", batch(usage(addr3, "YouTube", 5))},
+			[]string{key(UsageBatchIndex, gateway, "b1"), key(UsageIndex, "2018-03", addr3, "YouTube", "CCT")}, "",
+			&events.UsageRecorded{Period: "2018-03", Batch: "b1", Recorded: 1}},
+	}
+	for _, step := range steps {
+		mustInvoke(t, stub, step.sender, step.function, step.args...)
+		envelope, data := lastEvent(t, stub)
+		if want := step.function + " " + step.sender + " " + strings.Join(step.keys, ",") + " " + step.status + ">" + step.status; envelope != want {
+			t.Fatalf("%s envelope = %q, want %q", step.function, envelope, want)
+		}
+		if !reflect.DeepEqual(data, step.data) {
+			t.Fatalf("%s data = %+v, want %+v", step.function, data, step.data)
+		}
+	}
+
+	// settlement pays the developers
+	stub.SetTime(time.Date(2018, 4, 5, 0, 0, 0, 0, time.UTC))
+	mustInvoke(t, stub, addr3, SettleUsage, "2018-03")
+	if envelope, data := lastEvent(t, stub); envelope != "settleUsage "+addr3+" "+key(UsageStatementIndex, "2018-03", addr3)+" >" ||
+		!reflect.DeepEqual(data, &events.UsageSettled{Period: "2018-03", Consumer: addr3, Totals: map[string]string{"CCT": "15"},
+			Payments: []events.UsagePayment{{Developer: "user2", TokenType: "CCT", Amount: "15"}}}) {
+		t.Fatalf("event = %q %+v", envelope, data)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+)
+
+// Mashup health
+// ==================================================================================
+//
+// A mashup can not work while one of its components is broken: invalid,
+// suspended, or a degraded mashup itself. Its "BrokenComponents" lists them and its "Health"
+// is "degraded" while the list is not empty. Health is kept apart from the
+// lifecycle status, which only the developer changes.
+//
+// Invalidating a service breaks it for every mashup built on it, directly or
+// through other mashups; republishing it repairs them. Both walk the
+// service~mashup index and list the mashups whose health changed in their
+// event.
+
+// Health of a mashup, healthy mashups leave it empty
+const H_Degraded = "degraded"
+
+// isBroken tells whether the mashups composing serviceJSON can not work.
+func isBroken(serviceJSON *service) bool {
+	return serviceJSON.Status == S_Invalid || serviceJSON.Status == S_Suspended || len(serviceJSON.BrokenComponents) > 0
+}
+
+// setComponentHealth marks component as broken or working in mashupJSON,
+// and tells whether anything changed.
+func setComponentHealth(mashupJSON *service, component string, broken bool) bool {
+	i := sort.SearchStrings(mashupJSON.BrokenComponents, component)
+	listed := i < len(mashupJSON.BrokenComponents) && mashupJSON.BrokenComponents[i] == component
+	if broken == listed {
+		return false
+	}
+	if broken {
+		mashupJSON.BrokenComponents = append(mashupJSON.BrokenComponents, "")
+		copy(mashupJSON.BrokenComponents[i+1:], mashupJSON.BrokenComponents[i:])
+		mashupJSON.BrokenComponents[i] = component
+	} else {
+		mashupJSON.BrokenComponents = append(mashupJSON.BrokenComponents[:i], mashupJSON.BrokenComponents[i+1:]...)
+	}
+	if len(mashupJSON.BrokenComponents) > 0 {
+		mashupJSON.Health = H_Degraded
+	} else {
+		mashupJSON.BrokenComponents = nil
+		mashupJSON.Health = ""
+	}
+	return true
+}
+
+// cascadeHealth updates the health of the mashups built on serviceJSON after
+// its status changed, stores them and returns them for the caller's event.
+// serviceJSON must already be stored by the caller.
+// Every mashup is read and written once, as writes of a transaction are not
+// visible to its later reads.
+func cascadeHealth(stub shim.ChaincodeStubInterface, serviceJSON *service) ([]events.ImpactedMashup, error) {
+	loaded := map[string]*service{serviceJSON.Name: serviceJSON}
+	changed := make(map[string]bool)
+
+	// STEP 0: walk up the dependents while their brokenness flips
+	queue := []*service{serviceJSON}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		broken := isBroken(current)
+
+		mashups, err := usedBy(stub, current.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range mashups {
+			mashupJSON, ok := loaded[name]
+			if !ok {
+				mashupJSON, err = readService(stub, name)
+				if err != nil {
+					return nil, err
+				}
+				loaded[name] = mashupJSON
+			}
+			was_broken := isBroken(mashupJSON)
+			if !setComponentHealth(mashupJSON, current.Name, broken) {
+				continue
+			}
+			changed[name] = true
+			if isBroken(mashupJSON) != was_broken {
+				queue = append(queue, mashupJSON)
+			}
+		}
+	}
+	impacted := []events.ImpactedMashup{}
+	if len(changed) == 0 {
+		return impacted, nil
+	}
+
+	// STEP 1: store the affected mashups
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		mashupJSON := loaded[name]
+		mashupJSONasBytes, err := json.Marshal(mashupJSON)
+		if err != nil {
+			return nil, err
+		}
+		err = stub.PutState(ServicePrefix+name, mashupJSONasBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		health := mashupJSON.Health
+		if health == "" {
+			health = "healthy"
+		}
+		broken := mashupJSON.BrokenComponents
+		if broken == nil {
+			broken = []string{}
+		}
+		impacted = append(impacted, events.ImpactedMashup{Service: name, Developer: mashupJSON.Developer, Health: health, BrokenComponents: broken})
+	}
+
+	return impacted, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+// healthOf describes a service as "health[broken components]".
+func healthOf(t *testing.T, stub *shimtest.Stub, name string) string {
+	t.Helper()
+	s := getService(t, stub, name)
+	return fmt.Sprintf("%s%v", s.Health, s.BrokenComponents)
+}
+
+// lastImpact describes the mashups listed by the publishService or
+// invalidateService event of the stub's last transaction, "" if it listed
+// none.
+func lastImpact(t *testing.T, stub *shimtest.Stub) string {
+	t.Helper()
+	e := stub.LastEvent()
+	if e == nil || e.TxID != stub.GetTxID() {
+		return ""
+	}
+	event, data, err := events.Decode(e.Payload)
+	if err != nil {
+		t.Fatalf("decode event %s: %v", e.Payload, err)
+	}
+	var service string
+	var mashups []events.ImpactedMashup
+	switch data := data.(type) {
+	case *events.ServicePublished:
+		service, mashups = data.Service, data.Mashups
+	case *events.ServiceInvalidated:
+		service, mashups = data.Service, data.Mashups
+	}
+	if len(mashups) == 0 {
+		return ""
+	}
+	rows := make([]string, len(mashups))
+	for i, m := range mashups {
+		rows[i] = fmt.Sprintf("%s:%s%v", m.Service, m.Health, m.BrokenComponents)
+	}
+	return service + "=" + event.After + " " + strings.Join(rows, ",")
+}
+
+func TestInvalidationDegradesMashups(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr3, CreateMashup, "MapVideos", "Video", "Videos on a map.", "Google Maps", "YouTube")
+	mustInvoke(t, stub, addr3, CreateMashup, "SocialVideos", "Video", "Shared videos.", "Twitter", "YouTube")
+	if got := healthOf(t, stub, "MapVideos"); got != "[]" {
+		t.Fatalf("new mashup health = %s", got)
+	}
+
+	mustInvoke(t, stub, addr2, InvalidateService, "YouTube", "Terms of use changed.")
+	if got := lastImpact(t, stub); got != "YouTube=invalid MapVideos:degraded[YouTube],SocialVideos:degraded[YouTube]" {
+		t.Fatalf("impact = %s", got)
+	}
+	mustInvoke(t, stub, addr2, InvalidateService, "Twitter", "Shut down.")
+	if got := lastImpact(t, stub); got != "Twitter=invalid SocialVideos:degraded[Twitter YouTube]" {
+		t.Fatalf("impact = %s", got)
+	}
+	// the lifecycle status is left to the mashup's developer
+	if s := getService(t, stub, "SocialVideos"); s.Status != S_Created {
+		t.Fatalf("status = %s", s.Status)
+	}
+
+	// republishing repairs the mashups without other broken components
+	mustInvoke(t, stub, addr2, PublishService, "YouTube")
+	if got := lastImpact(t, stub); got != "YouTube=available MapVideos:healthy[],SocialVideos:degraded[Twitter]" {
+		t.Fatalf("impact = %s", got)
+	}
+	if got := healthOf(t, stub, "MapVideos"); got != "[]" {
+		t.Fatalf("MapVideos health = %s", got)
+	}
+	if got := healthOf(t, stub, "SocialVideos"); got != "degraded[Twitter]" {
+		t.Fatalf("SocialVideos health = %s", got)
+	}
+
+	// a first publish affects nobody
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+	if got := lastImpact(t, stub); got != "" {
+		t.Fatalf("impact = %s", got)
+	}
+
+	// mashups of broken services start degraded
+	mustInvoke(t, stub, addr3, CreateMashup, "Tweets", "Social", "Tweets only.", "Twitter")
+	if got := healthOf(t, stub, "Tweets"); got != "degraded[Twitter]" {
+		t.Fatalf("Tweets health = %s", got)
+	}
+}
+
+func TestDegradationCascades(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr3, CreateMashup, "MapVideos", "Video", "Videos on a map.", "Google Maps", "YouTube")
+	putMashup(t, stub, "Level2", "MapVideos", "Twitter")
+	putMashup(t, stub, "Level3", "Level2")
+
+	mustInvoke(t, stub, addr1, InvalidateService, "Google Maps", "Key revoked.")
+	want := "Google Maps=invalid Level2:degraded[MapVideos],Level3:degraded[Level2],MapVideos:degraded[Google Maps]"
+	if got := lastImpact(t, stub); got != want {
+		t.Fatalf("impact = %s, want %s", got, want)
+	}
+
+	// Level2 stays degraded while MapVideos is, whatever breaks next
+	mustInvoke(t, stub, addr2, InvalidateService, "YouTube", "Terms of use changed.")
+	if got := lastImpact(t, stub); got != "YouTube=invalid MapVideos:degraded[Google Maps YouTube]" {
+		t.Fatalf("impact = %s", got)
+	}
+
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+	if got := lastImpact(t, stub); got != "Google Maps=available MapVideos:degraded[YouTube]" {
+		t.Fatalf("impact = %s", got)
+	}
+	mustInvoke(t, stub, addr2, PublishService, "YouTube")
+	want = "YouTube=available Level2:healthy[],Level3:healthy[],MapVideos:healthy[]"
+	if got := lastImpact(t, stub); got != want {
+		t.Fatalf("impact = %s, want %s", got, want)
+	}
+	if got := healthOf(t, stub, "Level3"); got != "[]" {
+		t.Fatalf("Level3 health = %s", got)
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Incentive policy
+// ==================================================================================
+//
+// Creating a mashup pays the developers of the services it composes. The
+// policy deciding how much is stored on the ledger, written by Init and
+// changed by the chaincode admin:
+//
+//	fee = componentFee * units, bounded by minFee and maxFee (0: no bound)
+//
+// The fee basis decides the units and how the fee is split over them:
+//
+//	developer: one unit per distinct developer of the components, shared
+//	           evenly by the developer's components
+//	service:   one unit per component
+//	weighted:  one unit per use of a component, as counted in the mashup's
+//	           "Composition"
+//
+// Remainders of the divisions go to the first units by name.
+//
+// When a component is itself a mashup, "royaltyDecay" basis points of its
+// share flow down to its own components, split by the same basis, and so on
+// for "royaltyDepth" levels below the mashup's own components. Every level
+// passes on the same fraction of what it got, so royalties decay
+// geometrically. The payments of every mashup are recorded on the ledger.
+
+// Fee bases of the incentive policy
+const (
+	FeePerDeveloper = "developer"
+	FeePerService   = "service"
+	FeeWeighted     = "weighted"
+)
+
+// Basis points of a whole share
+const MaxBasisPoints = 10000
+
+// Structure definition for the incentive policy, amounts are decimal strings
+type incentivePolicy struct {
+	TokenType    string `json:"tokenType"`
+	ComponentFee string `json:"componentFee"`
+	MinFee       string `json:"minFee"`
+	MaxFee       string `json:"maxFee"`
+	FeeBasis     string `json:"feeBasis"`
+	RoyaltyDecay int    `json:"royaltyDecay"` // basis points of a mashup component's share passed down
+	RoyaltyDepth int    `json:"royaltyDepth"` // levels royalties flow down
+}
+
+// Policy written by Init when the ledger holds none
+var defaultIncentivePolicy = incentivePolicy{
+	TokenType:    "INK",
+	ComponentFee: "10",
+	MinFee:       "0",
+	MaxFee:       "0",
+	FeeBasis:     FeePerDeveloper,
+	RoyaltyDecay: 0,
+	RoyaltyDepth: 3,
+}
+
+// Structure definition for a payment out of a mashup's fee
+type payment struct {
+	Service   string `json:"service"` // the component paid for
+	Developer string `json:"developer"`
+	Depth     int    `json:"depth"` // 1 for the mashup's own components
+	Amount    string `json:"amount"`
+}
+
+// Structure definition for the payout record of a mashup
+type payout struct {
+	Mashup    string    `json:"mashup"`
+	Payer     string    `json:"payer"` // address of the mashup's creator
+	TokenType string    `json:"tokenType"`
+	Fee       string    `json:"fee"`
+	FeeBasis  string    `json:"feeBasis"`
+	Payments  []payment `json:"payments"`
+	TxID      string    `json:"txId"`
+	Time      string    `json:"time"`
+}
+
+// Structure definition for a unit of the fee: services of one developer
+// sharing a weight
+type feeUnit struct {
+	Developer string
+	Services  []string
+	Weight    int
+}
+
+// parseAmount reads a non-negative decimal amount.
+func parseAmount(field string, amount string) (*big.Int, error) {
+	value, ok := big.NewInt(0).SetString(amount, 10)
+	if !ok || value.Sign() < 0 {
+		return nil, errors.New("Expecting non-negative integer value for " + field + ".")
+	}
+	return value, nil
+}
+
+// validate checks the policy's fields.
+func (p *incentivePolicy) validate() error {
+	if p.TokenType == "" {
+		return errors.New("The incentive token type can not be empty.")
+	}
+	if _, err := parseAmount("componentFee", p.ComponentFee); err != nil {
+		return err
+	}
+	min_fee, err := parseAmount("minFee", p.MinFee)
+	if err != nil {
+		return err
+	}
+	max_fee, err := parseAmount("maxFee", p.MaxFee)
+	if err != nil {
+		return err
+	}
+	if max_fee.Sign() > 0 && min_fee.Cmp(max_fee) > 0 {
+		return errors.New("The minimum fee can not exceed the maximum fee.")
+	}
+	if p.FeeBasis != FeePerDeveloper && p.FeeBasis != FeePerService && p.FeeBasis != FeeWeighted {
+		return errors.New("Unknown fee basis: " + p.FeeBasis)
+	}
+	if p.RoyaltyDecay < 0 || p.RoyaltyDecay > MaxBasisPoints {
+		return errors.New("The royalty decay must be between 0 and 10000 basis points.")
+	}
+	if p.RoyaltyDepth < 0 {
+		return errors.New("The royalty depth can not be negative.")
+	}
+	return nil
+}
+
+// fee computes the fee for the given number of units.
+func (p *incentivePolicy) fee(units int) *big.Int {
+	component_fee, _ := parseAmount("componentFee", p.ComponentFee)
+	min_fee, _ := parseAmount("minFee", p.MinFee)
+	max_fee, _ := parseAmount("maxFee", p.MaxFee)
+
+	fee := component_fee.Mul(component_fee, big.NewInt(int64(units)))
+	if fee.Cmp(min_fee) < 0 {
+		fee = min_fee
+	}
+	if max_fee.Sign() > 0 && fee.Cmp(max_fee) > 0 {
+		fee = max_fee
+	}
+	return fee
+}
+
+// units groups the components of a composition into fee units, sorted by
+// name. components maps the services of the composition to their records.
+func (p *incentivePolicy) units(composition map[string]int, components map[string]*service) []feeUnit {
+	names := make([]string, 0, len(composition))
+	for name := range composition {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	units := []feeUnit{}
+	switch p.FeeBasis {
+	case FeePerDeveloper:
+		index := make(map[string]int)
+		for _, name := range names {
+			developer := components[name].Developer
+			i, ok := index[developer]
+			if !ok {
+				i = len(units)
+				index[developer] = i
+				units = append(units, feeUnit{developer, nil, 1})
+			}
+			units[i].Services = append(units[i].Services, name)
+		}
+		sort.Slice(units, func(i, j int) bool { return units[i].Developer < units[j].Developer })
+	default:
+		for _, name := range names {
+			weight := 1
+			if p.FeeBasis == FeeWeighted && composition[name] > 1 {
+				weight = composition[name]
+			}
+			units = append(units, feeUnit{components[name].Developer, []string{name}, weight})
+		}
+	}
+	return units
+}
+
+// splitEvenly divides amount into n parts, the first ones get the remainder.
+func splitEvenly(amount *big.Int, n int) []*big.Int {
+	share, remainder := big.NewInt(0).DivMod(amount, big.NewInt(int64(n)), big.NewInt(0))
+	parts := make([]*big.Int, n)
+	for i := range parts {
+		parts[i] = big.NewInt(0).Set(share)
+		if int64(i) < remainder.Int64() {
+			parts[i].Add(parts[i], big.NewInt(1))
+		}
+	}
+	return parts
+}
+
+// split divides amount over the units by weight, then evenly over the
+// services of every unit. It returns service name -> amount.
+func split(amount *big.Int, units []feeUnit) map[string]*big.Int {
+	shares := make(map[string]*big.Int)
+	total_weight := 0
+	for _, unit := range units {
+		total_weight += unit.Weight
+	}
+	if total_weight == 0 {
+		return shares
+	}
+
+	unit_amounts := make([]*big.Int, len(units))
+	left := big.NewInt(0).Set(amount)
+	for i, unit := range units {
+		unit_amounts[i] = big.NewInt(0).Mul(amount, big.NewInt(int64(unit.Weight)))
+		unit_amounts[i].Quo(unit_amounts[i], big.NewInt(int64(total_weight)))
+		left.Sub(left, unit_amounts[i])
+	}
+	// the floored divisions leave less than one per unit
+	for i := 0; left.Sign() > 0; i++ {
+		unit_amounts[i].Add(unit_amounts[i], big.NewInt(1))
+		left.Sub(left, big.NewInt(1))
+	}
+
+	for i, unit := range units {
+		for j, part := range splitEvenly(unit_amounts[i], len(unit.Services)) {
+			shares[unit.Services[j]] = part
+		}
+	}
+	return shares
+}
+
+// distribute splits amount over the components of a composition and
+// appends the payments, passing royalties down through mashup components.
+// path holds the mashups above, to stop on cycles.
+func (p *incentivePolicy) distribute(stub shim.ChaincodeStubInterface, composition map[string]int, components map[string]*service,
+	amount *big.Int, depth int, path map[string]bool, payments []payment) ([]payment, error) {
+	shares := split(amount, p.units(composition, components))
+
+	names := make([]string, 0, len(shares))
+	for name := range shares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		component := components[name]
+		keep := shares[name]
+
+		// STEP 0: pass the royalties of a mashup component down
+		if component.IsMashup && len(component.Composition) > 0 && !path[name] &&
+			depth <= p.RoyaltyDepth && p.RoyaltyDecay > 0 {
+			down := big.NewInt(0).Mul(keep, big.NewInt(int64(p.RoyaltyDecay)))
+			down.Quo(down, big.NewInt(MaxBasisPoints))
+			if down.Sign() > 0 {
+				children := make(map[string]*service)
+				for child := range component.Composition {
+					childJSON, err := readService(stub, child)
+					if err != nil {
+						return nil, err
+					}
+					children[child] = childJSON
+				}
+				path[name] = true
+				var err error
+				payments, err = p.distribute(stub, component.Composition, children, down, depth+1, path, payments)
+				if err != nil {
+					return nil, err
+				}
+				delete(path, name)
+				keep = big.NewInt(0).Sub(keep, down)
+			}
+		}
+
+		// STEP 1: the component's members keep the rest by share
+		split, err := splitShares(stub, component, keep)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range split {
+			payments = append(payments, payment{name, part.Developer, depth, part.Amount})
+		}
+	}
+	return payments, nil
+}
+
+// getIncentivePolicy reads the policy from the ledger.
+func getIncentivePolicy(stub shim.ChaincodeStubInterface) (*incentivePolicy, error) {
+	policyAsBytes, err := stub.GetState(IncentivePolicyKey)
+	if err != nil {
+		return nil, errors.New("Fail to get the incentive policy: " + err.Error())
+	}
+	policy := defaultIncentivePolicy
+	if policyAsBytes == nil {
+		return &policy, nil
+	}
+	err = json.Unmarshal(policyAsBytes, &policy)
+	if err != nil {
+		return nil, errors.New("Error unmarshal incentive policy.")
+	}
+	return &policy, nil
+}
+
+// payIncentives transfers the fee of a new mashup from the sender to the
+// developers of its components and records the payout.
+// components maps the services of the mashup's Composition to their records.
+func payIncentives(stub shim.ChaincodeStubInterface, mashup *service, components map[string]*service) error {
+	policy, err := getIncentivePolicy(stub)
+	if err != nil {
+		return err
+	}
+	payer, err := senderAddress(stub)
+	if err != nil {
+		return errors.New("Fail to get the sender's address.")
+	}
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	// STEP 0: compute the fee and split it
+	units := policy.units(mashup.Composition, components)
+	total_units := 0
+	for _, unit := range units {
+		total_units += unit.Weight
+	}
+	fee := policy.fee(total_units)
+	payments, err := policy.distribute(stub, mashup.Composition, components, fee, 1,
+		map[string]bool{mashup.Name: true}, []payment{})
+	if err != nil {
+		return err
+	}
+
+	// STEP 1: pay every developer once
+	totals := make(map[string]*big.Int)
+	for _, pay := range payments {
+		amount, _ := big.NewInt(0).SetString(pay.Amount, 10)
+		if totals[pay.Developer] == nil {
+			totals[pay.Developer] = big.NewInt(0)
+		}
+		totals[pay.Developer].Add(totals[pay.Developer], amount)
+	}
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		// get the developer's address
+		address, err := developerAddress(stub, name)
+		if err != nil {
+			return err
+		}
+		// from the mashup developer to the invoked service's developer
+		err = stub.Transfer(address, policy.TokenType, totals[name])
+		if err != nil {
+			return errors.New("Error when making transfer.")
+		}
+	}
+
+	// STEP 2: record the payout
+	record := &payout{mashup.Name, payer, policy.TokenType, fee.String(), policy.FeeBasis,
+		payments, stub.GetTxID(), txTime.Format(time.UnixDate)}
+	recordAsBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(PayoutPrefix+mashup.Name, recordAsBytes)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ===============================================================
+// setIncentivePolicy: update the incentive policy
+// args[0] is a JSON document, omitted fields keep their value
+// ===============================================================
+func (t *serviceChaincode) setIncentivePolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// STEP 0: only admins can change the policy
+	_, err := authorize(stub, nil, R_Admin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: merge the new fields into the current policy
+	policy, err := getIncentivePolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = json.Unmarshal([]byte(args[0]), policy)
+	if err != nil {
+		return shim.Error("Error unmarshal incentive policy: " + err.Error())
+	}
+	err = policy.validate()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: store the policy
+	policyAsBytes, err := json.Marshal(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(IncentivePolicyKey, policyAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, SetIncentivePolicy, []string{IncentivePolicyKey}, "", "",
+		events.IncentivePolicy{TokenType: policy.TokenType, ComponentFee: policy.ComponentFee, MinFee: policy.MinFee,
+			MaxFee: policy.MaxFee, FeeBasis: policy.FeeBasis, RoyaltyDecay: policy.RoyaltyDecay, RoyaltyDepth: policy.RoyaltyDepth})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(policyAsBytes)
+}
+
+// ===============================================================
+// queryIncentivePolicy: query the current incentive policy
+// ===============================================================
+func (t *serviceChaincode) queryIncentivePolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	policy, err := getIncentivePolicy(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policyAsBytes, err := json.Marshal(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(policyAsBytes)
+}
+
+// ===============================================================
+// queryPayout: query how the fee of a mashup was paid out
+// ===============================================================
+func (t *serviceChaincode) queryPayout(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var mashup_name string
+
+	mashup_name = args[0]
+
+	payoutAsBytes, err := stub.GetState(PayoutPrefix + mashup_name)
+	if err != nil {
+		return shim.Error("Fail to get payout: " + err.Error())
+	} else if payoutAsBytes == nil {
+		return shim.Error("No payout recorded for mashup: " + mashup_name)
+	}
+	return shim.Success(payoutAsBytes)
+}
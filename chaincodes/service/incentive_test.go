@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+func queryPolicy(t *testing.T, stub *shimtest.Stub) incentivePolicy {
+	t.Helper()
+	var policy incentivePolicy
+	payload := mustInvoke(t, stub, addr2, QueryIncentivePolicy)
+	if err := json.Unmarshal(payload, &policy); err != nil {
+		t.Fatalf("unmarshal incentive policy %s: %v", payload, err)
+	}
+	return policy
+}
+
+func TestIncentivePolicy(t *testing.T) {
+	stub := newServiceStub(t)
+	if got := queryPolicy(t, stub); got != defaultIncentivePolicy {
+		t.Fatalf("initial policy = %+v", got)
+	}
+
+	// omitted fields keep their value
+	mustInvoke(t, stub, addr1, SetIncentivePolicy, `{"componentFee":"20","feeBasis":"service"}`)
+	want := defaultIncentivePolicy
+	want.ComponentFee, want.FeeBasis = "20", FeePerService
+	if got := queryPolicy(t, stub); got != want {
+		t.Fatalf("policy = %+v, want %+v", got, want)
+	}
+
+	// Init on upgrade keeps the policy
+	stub.InitAs(addr1)
+	if got := queryPolicy(t, stub); got != want {
+		t.Fatalf("policy after upgrade = %+v", got)
+	}
+
+	mustFail(t, stub, addr2, SetIncentivePolicy, `{"componentFee":"1"}`)
+	mustFail(t, stub, addr1, SetIncentivePolicy, `{"componentFee":"-1"}`)
+	mustFail(t, stub, addr1, SetIncentivePolicy, `{"minFee":"ten"}`)
+	mustFail(t, stub, addr1, SetIncentivePolicy, `{"minFee":"50","maxFee":"40"}`)
+	mustFail(t, stub, addr1, SetIncentivePolicy, `{"feeBasis":"mashup"}`)
+	mustFail(t, stub, addr1, SetIncentivePolicy, `{"tokenType":""}`)
+	mustFail(t, stub, addr1, SetIncentivePolicy, `{"royaltyDecay":10001}`)
+	mustFail(t, stub, addr1, SetIncentivePolicy, `{"royaltyDepth":-1}`)
+	mustFail(t, stub, addr1, SetIncentivePolicy, `not json`)
+	if got := queryPolicy(t, stub); got != want {
+		t.Fatalf("policy after rejected updates = %+v", got)
+	}
+}
+
+func TestMashupFees(t *testing.T) {
+	for _, c := range []struct {
+		policy           string
+		user1, user2     string // balances after the mashup
+		user3, otherType string
+	}{
+		// the default: 10 per developer
+		{`{}`, "1010", "1010", "980", "0"},
+		// 10 per service, user2 develops two of them
+		{`{"feeBasis":"service"}`, "1010", "1020", "970", "0"},
+		// 3 units of 10 capped at 25, the remainder goes to the first unit
+		{`{"feeBasis":"service","maxFee":"25"}`, "1009", "1016", "975", "0"},
+		// 2 units of 10 raised to 31
+		{`{"minFee":"31"}`, "1016", "1015", "969", "0"},
+		// free mashups
+		{`{"componentFee":"0"}`, "1000", "1000", "1000", "0"},
+		// fees paid in another token
+		{`{"tokenType":"CCT"}`, "1000", "1000", "1000", "10"},
+	} {
+		stub := newEcosystemStub(t)
+		stub.SetBalance(addr3, "CCT", 100)
+		mustInvoke(t, stub, addr1, SetIncentivePolicy, c.policy)
+		mustInvoke(t, stub, addr3, CreateMashup, "Everything", "All", "All services.", "Google Maps", "Twitter", "YouTube")
+
+		got := []string{balance(stub, addr1), balance(stub, addr2), balance(stub, addr3)}
+		if got[0] != c.user1 || got[1] != c.user2 || got[2] != c.user3 {
+			t.Errorf("policy %s: balances = %v", c.policy, got)
+		}
+		if b := stub.Balance(addr2, "CCT"); c.otherType != "0" && (b == nil || b.String() != c.otherType) {
+			t.Errorf("policy %s: CCT balance of user2 = %v", c.policy, b)
+		}
+	}
+}
+
+func getPayout(t *testing.T, stub *shimtest.Stub, mashup string) (payout, string) {
+	t.Helper()
+	var record payout
+	payload := mustInvoke(t, stub, addr1, QueryPayout, mashup)
+	if err := json.Unmarshal(payload, &record); err != nil {
+		t.Fatalf("unmarshal payout %s: %v", payload, err)
+	}
+	rows := make([]string, len(record.Payments))
+	for i, p := range record.Payments {
+		rows[i] = fmt.Sprintf("%s:%s:%d:%s", p.Service, p.Developer, p.Depth, p.Amount)
+	}
+	return record, strings.Join(rows, ",")
+}
+
+func TestWeightedMashupFees(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr1, SetIncentivePolicy, `{"feeBasis":"weighted"}`)
+
+	// Google Maps is used twice
+	mustInvoke(t, stub, addr3, CreateMashup, "RouteVideos", "Video", "Videos along a route.", "Google Maps", "YouTube", "Google Maps")
+	if s := getService(t, stub, "RouteVideos"); s.Composition["Google Maps"] != 2 {
+		t.Fatalf("composition = %v", s.Composition)
+	}
+	if b1, b2 := balance(stub, addr1), balance(stub, addr2); b1 != "1020" || b2 != "1010" {
+		t.Fatalf("balances = %s, %s", b1, b2)
+	}
+
+	record, payments := getPayout(t, stub, "RouteVideos")
+	if payments != "Google Maps:user1:1:20,YouTube:user2:1:10" {
+		t.Fatalf("payments = %s", payments)
+	}
+	if record.Payer != addr3 || record.Fee != "30" || record.FeeBasis != FeeWeighted || record.TokenType != tokenType || record.TxID == "" {
+		t.Fatalf("payout = %+v", record)
+	}
+	mustFail(t, stub, addr1, QueryPayout, "Google Maps")
+}
+
+func TestMashupRoyalties(t *testing.T) {
+	for _, c := range []struct {
+		policy              string
+		payments            string
+		user1, user2, user3 string
+	}{
+		// no royalties by default
+		{`{"feeBasis":"service"}`,
+			"Inner:user3:1:10,Twitter:user2:1:10",
+			"980", "1010", "1010"},
+		// half of Inner's share flows down to its components
+		{`{"feeBasis":"service","royaltyDecay":5000}`,
+			"Google Maps:user1:2:3,YouTube:user2:2:2,Inner:user3:1:5,Twitter:user2:1:10",
+			"983", "1012", "1005"},
+		// royalties stop at the depth limit
+		{`{"feeBasis":"service","royaltyDecay":5000,"royaltyDepth":0}`,
+			"Inner:user3:1:10,Twitter:user2:1:10",
+			"980", "1010", "1010"},
+		// all of it flows down, split by developer
+		{`{"royaltyDecay":10000}`,
+			"Google Maps:user1:2:5,YouTube:user2:2:5,Twitter:user2:1:10",
+			"985", "1015", "1000"},
+	} {
+		stub := newEcosystemStub(t)
+		putMashupBy(t, stub, "user3", "Inner", "Google Maps", "YouTube")
+		mustInvoke(t, stub, addr1, SetIncentivePolicy, c.policy)
+		mustInvoke(t, stub, addr1, CreateMashup, "Outer", "Social", "Tweets about the inner mashup.", "Inner", "Twitter")
+
+		if _, payments := getPayout(t, stub, "Outer"); payments != c.payments {
+			t.Errorf("policy %s: payments = %s", c.policy, payments)
+		}
+		got := []string{balance(stub, addr1), balance(stub, addr2), balance(stub, addr3)}
+		if got[0] != c.user1 || got[1] != c.user2 || got[2] != c.user3 {
+			t.Errorf("policy %s: balances = %v", c.policy, got)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Contribution leaderboard
+// ==================================================================================
+//
+// The leaderboard is a composite-key index ordered by contribution, so the top
+// users are the first entries of a range scan. Scores are stored inverted
+// (maxBoardScore - contribution) and zero-padded, which makes the highest
+// contribution sort first; ties are broken by user name.
+//
+//	contribution~user:       [inverted score, user]
+//	type~contribution~user:  [service type, inverted score, user]
+//	developer~type~service:  [user, service type, service]
+//
+// A user is on the board of a service type while developing at least one
+// service of that type. Every entry is moved whenever the contribution changes.
+
+const maxBoardScore = 999999999999
+
+// Default number of users returned by queryLeaderboard
+const DefaultLeaderboardSize = 10
+
+// Structure definition for one row of the leaderboard
+type leaderboardEntry struct {
+	Rank         int    `json:"rank"`
+	Name         string `json:"name"`
+	Contribution int    `json:"contribution"`
+}
+
+// boardScore encodes a contribution so that higher scores sort first.
+func boardScore(contribution int) string {
+	return fmt.Sprintf("%012d", maxBoardScore-contribution)
+}
+
+// addLeaderboardEntry puts a newly registered user on the leaderboard.
+func addLeaderboardEntry(stub shim.ChaincodeStubInterface, user_name string, contribution int) error {
+	board_key, err := stub.CreateCompositeKey(ContributionBoardIndex, []string{boardScore(contribution), user_name})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(board_key, []byte{0x00})
+}
+
+// removeLeaderboardEntries takes a user off the leaderboard of every type.
+func removeLeaderboardEntries(stub shim.ChaincodeStubInterface, user_name string, contribution int) error {
+	return moveLeaderboardEntries(stub, user_name, contribution, -1)
+}
+
+// moveLeaderboardEntries moves a user's entries from score "from" to score "to".
+// A negative "to" only removes the entries.
+func moveLeaderboardEntries(stub shim.ChaincodeStubInterface, user_name string, from int, to int) error {
+	if from == to {
+		return nil
+	}
+	types, err := developerTypes(stub, user_name)
+	if err != nil {
+		return err
+	}
+
+	// the global board, then the board of every type the user develops
+	boards := [][]string{{ContributionBoardIndex}}
+	for _, service_type := range types {
+		boards = append(boards, []string{TypeContributionBoardIndex, service_type})
+	}
+	for _, board := range boards {
+		old_key, err := stub.CreateCompositeKey(board[0], append(board[1:], boardScore(from), user_name))
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(old_key)
+		if err != nil {
+			return err
+		}
+		if to < 0 {
+			continue
+		}
+		new_key, err := stub.CreateCompositeKey(board[0], append(board[1:], boardScore(to), user_name))
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(new_key, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// developerTypes lists the service types a user develops.
+func developerTypes(stub shim.ChaincodeStubInterface, user_name string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(DeveloperTypeIndex, []string{user_name})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	types := []string{}
+	for resultsIterator.HasNext() {
+		indexResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		// entries are ordered by type, skip the other services of the same type
+		if len(types) == 0 || types[len(types)-1] != keyParts[1] {
+			types = append(types, keyParts[1])
+		}
+	}
+	return types, nil
+}
+
+// indexDeveloperType records that developer develops service_name of
+// service_type, and puts the developer on that type's board with the
+// given contribution.
+func indexDeveloperType(stub shim.ChaincodeStubInterface, developer string, service_type string, service_name string, contribution int) error {
+	type_key, err := stub.CreateCompositeKey(DeveloperTypeIndex, []string{developer, service_type, service_name})
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(type_key, []byte{0x00})
+	if err != nil {
+		return err
+	}
+	board_key, err := stub.CreateCompositeKey(TypeContributionBoardIndex, []string{service_type, boardScore(contribution), developer})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(board_key, []byte{0x00})
+}
+
+// unindexDeveloperType drops service_name from the developer's services of
+// service_type, and takes the developer off that type's board when it was
+// the developer's last service of the type.
+func unindexDeveloperType(stub shim.ChaincodeStubInterface, developer string, service_type string, service_name string, contribution int) error {
+	type_key, err := stub.CreateCompositeKey(DeveloperTypeIndex, []string{developer, service_type, service_name})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(type_key)
+	if err != nil {
+		return err
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(DeveloperTypeIndex, []string{developer, service_type})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		indexResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		if indexResponse.Key != type_key {
+			// another service of the type is left
+			return nil
+		}
+	}
+
+	board_key, err := stub.CreateCompositeKey(TypeContributionBoardIndex, []string{service_type, boardScore(contribution), developer})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(board_key)
+}
+
+// ===================================================================
+// queryLeaderboard: query the top users by contribution
+// optionally limited to the developers of a given service type
+// ===================================================================
+func (t *serviceChaincode) queryLeaderboard(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_type string
+	var err error
+
+	top := DefaultLeaderboardSize
+	if len(args) > 0 && args[0] != "" {
+		top, err = strconv.Atoi(args[0])
+		if err != nil || top <= 0 {
+			return shim.Error("Expecting positive integer value for the number of users.")
+		}
+		if top > MaxPageSize {
+			top = MaxPageSize
+		}
+	}
+	if len(args) > 1 {
+		service_type = args[1]
+	}
+
+	// STEP 0: pick the board
+	var resultsIterator shim.StateQueryIteratorInterface
+	if service_type == "" {
+		resultsIterator, err = stub.GetStateByPartialCompositeKey(ContributionBoardIndex, []string{})
+	} else {
+		resultsIterator, err = stub.GetStateByPartialCompositeKey(TypeContributionBoardIndex, []string{service_type})
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	// STEP 1: the first entries are the top users
+	board := []leaderboardEntry{}
+	for resultsIterator.HasNext() && len(board) < top {
+		indexResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		score, err := strconv.Atoi(keyParts[len(keyParts)-2])
+		if err != nil {
+			return shim.Error("Error parse leaderboard score.")
+		}
+		board = append(board, leaderboardEntry{len(board) + 1, keyParts[len(keyParts)-1], maxBoardScore - score})
+	}
+
+	boardAsBytes, err := json.Marshal(board)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(boardAsBytes)
+}
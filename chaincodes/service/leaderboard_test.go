@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+func getLeaderboard(t *testing.T, stub *shimtest.Stub, args ...string) string {
+	t.Helper()
+	var board []leaderboardEntry
+	payload := mustInvoke(t, stub, addr1, QueryLeaderboard, args...)
+	if err := json.Unmarshal(payload, &board); err != nil {
+		t.Fatalf("unmarshal leaderboard %s: %v", payload, err)
+	}
+	rows := make([]string, len(board))
+	for i, e := range board {
+		rows[i] = fmt.Sprintf("%d:%s:%d", e.Rank, e.Name, e.Contribution)
+	}
+	return strings.Join(rows, ",")
+}
+
+func TestLeaderboard(t *testing.T) {
+	stub := newEcosystemStub(t)
+	w := defaultContributionWeights
+
+	if got := getLeaderboard(t, stub); got != "1:user1:0,2:user2:0,3:user3:0" {
+		t.Fatalf("initial leaderboard = %s", got)
+	}
+
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	mustInvoke(t, stub, addr2, PublishService, "YouTube")
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+	mustInvoke(t, stub, addr3, CreateMashup, "MapVideos", "Video", "Videos on a map.", "Google Maps", "YouTube")
+
+	want := fmt.Sprintf("1:user2:%d,2:user1:%d,3:user3:%d",
+		2*w.Publish+w.Composed, w.Publish+w.Composed, w.Mashup)
+	if got := getLeaderboard(t, stub); got != want {
+		t.Fatalf("leaderboard = %s, want %s", got, want)
+	}
+	if got := getLeaderboard(t, stub, "2"); strings.Count(got, ",") != 1 {
+		t.Fatalf("top 2 = %s", got)
+	}
+
+	// type boards only rank the developers of the type
+	want = fmt.Sprintf("1:user2:%d,2:user3:%d", 2*w.Publish+w.Composed, w.Mashup)
+	if got := getLeaderboard(t, stub, "", "Video"); got != want {
+		t.Fatalf("Video leaderboard = %s, want %s", got, want)
+	}
+	if got := getLeaderboard(t, stub, "", "Mapping"); got != fmt.Sprintf("1:user1:%d", w.Publish+w.Composed) {
+		t.Fatalf("Mapping leaderboard = %s", got)
+	}
+	if got := getLeaderboard(t, stub, "", "Photos"); got != "" {
+		t.Fatalf("Photos leaderboard = %s", got)
+	}
+
+	mustFail(t, stub, addr1, QueryLeaderboard, "zero")
+	mustFail(t, stub, addr1, QueryLeaderboard, "1", "Video", "extra")
+}
+
+func TestLeaderboardFollowsTypeEdits(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+
+	// user2 leaves "Social" once its only Social service changes type
+	mustInvoke(t, stub, addr2, EditService, "Twitter", "Type", "Video")
+	if got := getLeaderboard(t, stub, "", "Social"); got != "" {
+		t.Fatalf("Social leaderboard = %s", got)
+	}
+	if got := getLeaderboard(t, stub, "", "Video"); got != fmt.Sprintf("1:user2:%d", defaultContributionWeights.Publish) {
+		t.Fatalf("Video leaderboard = %s", got)
+	}
+	// user2 still develops YouTube, so the Video entry stays
+	mustInvoke(t, stub, addr2, EditService, "Twitter", "Type", "Social")
+	if got := getLeaderboard(t, stub, "", "Video"); got == "" {
+		t.Fatal("user2 dropped from the Video leaderboard")
+	}
+
+	// removed users leave every board
+	mustInvoke(t, stub, addr2, RemoveUser, "user2", RemoveOrphan)
+	if got := getLeaderboard(t, stub); got != "1:user1:0,2:user3:0" {
+		t.Fatalf("leaderboard after removal = %s", got)
+	}
+	if got := getLeaderboard(t, stub, "", "Video"); got != "" {
+		t.Fatalf("Video leaderboard after removal = %s", got)
+	}
+}
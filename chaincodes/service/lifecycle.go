@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Service lifecycle
+// ==================================================================================
+//
+//	created ----> available ----> deprecated
+//	   |            ^    |            |
+//	   |            |    v            |
+//	   +--------> invalid <-----------+
+//
+// A created service becomes available when its developer publishes it.
+// Any live service can be invalidated with a reason, and an invalid service
+// can be published again. An available service can be deprecated in favour
+// of a successor service. Every status change is appended to the service's
+// transition log.
+//
+// Moderators can also suspend a service in any status with a reason. A
+// suspended service has no way out of the lifecycle but reinstatement by a
+// moderator, see roles.go.
+
+// allowedTransitions lists, for every status, the statuses it may move to.
+var allowedTransitions = map[string][]string{
+	S_Created:    {S_Available, S_Invalid, S_Suspended},
+	S_Available:  {S_Invalid, S_Deprecated, S_Suspended},
+	S_Invalid:    {S_Available, S_Suspended},
+	S_Deprecated: {S_Invalid, S_Suspended},
+	S_Suspended:  {},
+}
+
+// Errors of the service lifecycle
+var (
+	ErrReasonRequired    = errors.New("A reason is required to invalidate or suspend a service.")
+	ErrSuccessorRequired = errors.New("A successor service is required to deprecate a service.")
+)
+
+// IllegalTransitionError is returned for a status change the lifecycle does not allow.
+type IllegalTransitionError struct {
+	Service string
+	From    string
+	To      string
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("Illegal status transition of service %s: %s -> %s.", e.Service, e.From, e.To)
+}
+
+// InvalidSuccessorError is returned when a service can not replace a deprecated one.
+type InvalidSuccessorError struct {
+	Service   string
+	Successor string
+	Cause     string
+}
+
+func (e *InvalidSuccessorError) Error() string {
+	return fmt.Sprintf("Service %s can not succeed %s: %s.", e.Successor, e.Service, e.Cause)
+}
+
+// Structure definition for one entry of a service's transition log
+type transition struct {
+	Service   string `json:"service"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason,omitempty"`
+	Successor string `json:"successor,omitempty"`
+	// a transfer keeps the status and records the developers instead
+	PreviousDeveloper string `json:"previousDeveloper,omitempty"`
+	Developer         string `json:"developer,omitempty"`
+	Actor             string `json:"actor"` // address of the invoker
+	TxID              string `json:"txId"`
+	Time              string `json:"time"`
+}
+
+// isValidStatus reports whether status is a known service status.
+func isValidStatus(status string) bool {
+	_, ok := allowedTransitions[status]
+	return ok
+}
+
+// canTransition reports whether a service may move from one status to another.
+func canTransition(from string, to string) bool {
+	for _, next := range allowedTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionService moves serviceJSON to status "to" and logs the change.
+// The caller stores the updated service.
+func transitionService(stub shim.ChaincodeStubInterface, serviceJSON *service, to string, reason string, successor string) error {
+	from := serviceJSON.Status
+	if !canTransition(from, to) {
+		return &IllegalTransitionError{serviceJSON.Name, from, to}
+	}
+	if (to == S_Invalid || to == S_Suspended) && reason == "" {
+		return ErrReasonRequired
+	}
+	if to == S_Deprecated && successor == "" {
+		return ErrSuccessorRequired
+	}
+
+	serviceJSON.Status = to
+	if to == S_Deprecated {
+		serviceJSON.Successor = successor
+	} else if to != S_Suspended {
+		// a suspended service keeps its successor for its reinstatement
+		serviceJSON.Successor = ""
+	}
+
+	return logTransition(stub, serviceJSON.Name, from, to, reason, successor)
+}
+
+// logTransition appends an entry to the transition log of service_name.
+func logTransition(stub shim.ChaincodeStubInterface, service_name string, from string, to string, reason string, successor string) error {
+	return logEntry(stub, &transition{Service: service_name, From: from, To: to, Reason: reason, Successor: successor})
+}
+
+// logEntry stamps entry with the invoker and the transaction and appends it
+// to its service's log. Log keys are ordered by transaction time, so the log
+// reads chronologically.
+func logEntry(stub shim.ChaincodeStubInterface, entry *transition) error {
+	actor, err := senderAddress(stub)
+	if err != nil {
+		return errors.New("Fail to get the sender's address.")
+	}
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	entry.Actor = actor
+	entry.TxID = stub.GetTxID()
+	entry.Time = txTime.Format(time.UnixDate)
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	log_key, err := stub.CreateCompositeKey(ServiceTransitionIndex,
+		[]string{entry.Service, txTime.Format(sortableTimeLayout), stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(log_key, entryAsBytes)
+}
+
+// txTimestamp returns the transaction's timestamp in UTC.
+func txTimestamp(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, errors.New("Fail to get the transaction timestamp.")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// =================================================================
+// deprecateService: deprecate an available service for a successor
+// =================================================================
+func (t *serviceChaincode) deprecateService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var successor_name string
+	var err error
+
+	service_name = args[0]
+	successor_name = args[1]
+
+	// STEP 0: check if service exists
+	service_key := ServicePrefix + service_name
+	serviceAsBytes, err := stub.GetState(service_key)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exists: " + service_name)
+	}
+
+	var serviceJSON service
+	err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+
+	// STEP 1: check whether it is the service's developer's invocation
+	_, err = authorize(stub, &serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: check the successor, it must be another live service
+	if successor_name == service_name {
+		return shim.Error((&InvalidSuccessorError{service_name, successor_name, "a service can not succeed itself"}).Error())
+	}
+	successorAsBytes, err := stub.GetState(ServicePrefix + successor_name)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if successorAsBytes == nil {
+		return shim.Error((&InvalidSuccessorError{service_name, successor_name, "it does not exist"}).Error())
+	}
+	var successorJSON service
+	err = json.Unmarshal(successorAsBytes, &successorJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal service bytes.")
+	}
+	if successorJSON.Status == S_Invalid || successorJSON.Status == S_Deprecated || successorJSON.Status == S_Suspended {
+		return shim.Error((&InvalidSuccessorError{service_name, successor_name, "it is " + successorJSON.Status}).Error())
+	}
+
+	// STEP 3: deprecate the service and store it
+	err = transitionService(stub, &serviceJSON, S_Deprecated, "", successor_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(service_key, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, DeprecateService, []string{service_key, ServicePrefix + successor_name}, S_Available, S_Deprecated,
+		events.ServiceDeprecated{Service: service_name, Developer: serviceJSON.Developer, Successor: successor_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Deprecate Service success."))
+}
+
+// =============================================================
+// queryServiceHistory: query the transition log of a service
+// =============================================================
+func (t *serviceChaincode) queryServiceHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var err error
+
+	service_name = args[0]
+
+	// check if service exists
+	serviceAsBytes, err := stub.GetState(ServicePrefix + service_name)
+	if err != nil {
+		return shim.Error("Fail to get service: " + err.Error())
+	} else if serviceAsBytes == nil {
+		return shim.Error("This service does not exist: " + service_name)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(ServiceTransitionIndex, []string{service_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	history := []transition{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var entry transition
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return shim.Error("Error unmarshal transition bytes.")
+		}
+		history = append(history, entry)
+	}
+
+	historyAsBytes, err := json.Marshal(history)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(historyAsBytes)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+func getHistory(t *testing.T, stub *shimtest.Stub, name string) []transition {
+	t.Helper()
+	var history []transition
+	payload := mustInvoke(t, stub, addr1, QueryServiceHistory, name)
+	if err := json.Unmarshal(payload, &history); err != nil {
+		t.Fatalf("unmarshal history %s: %v", payload, err)
+	}
+	return history
+}
+
+func TestAllowedTransitions(t *testing.T) {
+	legal := []string{
+		"created>available", "created>invalid", "created>suspended",
+		"available>invalid", "available>deprecated", "available>suspended",
+		"invalid>available", "invalid>suspended",
+		"deprecated>invalid", "deprecated>suspended",
+	}
+	statuses := []string{S_Created, S_Available, S_Invalid, S_Deprecated, S_Suspended}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := false
+			for _, l := range legal {
+				if l == from+">"+to {
+					want = true
+				}
+			}
+			if got := canTransition(from, to); got != want {
+				t.Errorf("canTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	if isValidStatus("deleted") || !isValidStatus(S_Deprecated) {
+		t.Error("unexpected isValidStatus result")
+	}
+}
+
+func TestServiceLifecycle(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	// an invalid service needs an explicit publish to come back
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+	msg := mustFail(t, stub, addr1, PublishService, "Google Maps")
+	if !strings.Contains(msg, "Illegal status transition") {
+		t.Fatalf("unexpected error for a second publish: %s", msg)
+	}
+	mustFail(t, stub, addr1, InvalidateService, "Google Maps", "")
+	mustInvoke(t, stub, addr1, InvalidateService, "Google Maps", "Key revoked.")
+	mustFail(t, stub, addr1, InvalidateService, "Google Maps", "Twice.")
+	mustFail(t, stub, addr1, DeprecateService, "Google Maps", "Twitter")
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+	if s := getService(t, stub, "Google Maps"); s.Status != S_Available {
+		t.Fatalf("status = %s, want %s", s.Status, S_Available)
+	}
+
+	// a failed transition leaves no log entry
+	if n := len(getHistory(t, stub, "Google Maps")); n != 4 {
+		t.Fatalf("history has %d entries, want 4", n)
+	}
+}
+
+func TestDeprecateService(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr1, RegisterService, "Google Maps v2", "Mapping", "Maps API v2.", "user1")
+
+	// only available services can be deprecated
+	mustFail(t, stub, addr1, DeprecateService, "Google Maps", "Google Maps v2")
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+
+	mustFail(t, stub, addr2, DeprecateService, "Google Maps", "Google Maps v2")
+	mustFail(t, stub, addr1, DeprecateService, "Google Maps", "Google Maps")
+	mustFail(t, stub, addr1, DeprecateService, "Google Maps", "Bing Maps")
+	mustInvoke(t, stub, addr2, InvalidateService, "YouTube", "Gone.")
+	mustFail(t, stub, addr1, DeprecateService, "Google Maps", "YouTube")
+
+	mustInvoke(t, stub, addr1, DeprecateService, "Google Maps", "Google Maps v2")
+	s := getService(t, stub, "Google Maps")
+	if s.Status != S_Deprecated || s.Successor != "Google Maps v2" {
+		t.Fatalf("unexpected deprecated service: %+v", s)
+	}
+	mustFail(t, stub, addr1, PublishService, "Google Maps")
+
+	// invalidating a deprecated service clears its successor
+	mustInvoke(t, stub, addr1, InvalidateService, "Google Maps", "Retired.")
+	if s = getService(t, stub, "Google Maps"); s.Status != S_Invalid || s.Successor != "" {
+		t.Fatalf("unexpected invalidated service: %+v", s)
+	}
+	mustFail(t, stub, addr1, DeprecateService, "Google Maps")
+}
+
+func TestQueryServiceHistory(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+	stub.Advance(time.Hour)
+	mustInvoke(t, stub, addr1, InvalidateService, "Google Maps", "Key revoked.")
+	stub.Advance(time.Hour)
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+
+	history := getHistory(t, stub, "Google Maps")
+	var steps []string
+	for _, h := range history {
+		steps = append(steps, h.From+">"+h.To)
+	}
+	if got := strings.Join(steps, ","); got != ">created,created>available,available>invalid,invalid>available" {
+		t.Fatalf("history = %s", got)
+	}
+	if h := history[2]; h.Reason != "Key revoked." || h.Actor != addr1 || h.TxID == "" || h.Time == "" {
+		t.Fatalf("unexpected invalidation entry: %+v", h)
+	}
+
+	// logs are kept per service
+	if n := len(getHistory(t, stub, "Twitter")); n != 1 {
+		t.Fatalf("Twitter history has %d entries, want 1", n)
+	}
+	mustFail(t, stub, addr1, QueryServiceHistory, "Bing Maps")
+	mustFail(t, stub, addr1, QueryServiceHistory)
+}
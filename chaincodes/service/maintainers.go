@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Maintainers and revenue shares
+// ==================================================================================
+//
+// A service is kept by its members: its "Developer", the owner, and the
+// maintainers the owner adds. Every member has a revenue share in basis
+// points; the shares add up to 10000 and the owner holds them all until
+// they are changed. A service without a maintainer list is owned by its
+// developer alone.
+//
+// Maintainers edit and publish the service. Everything else, transferring,
+// invalidating, deprecating and pricing it, is left to the owner.
+//
+// The fees of createMashup, the rewards of rewardService, the usage paid
+// with settleUsage and the plans bought with subscribe and renewSubscription
+// are split over the members by share, the owner gets the remainder of the divisions and
+// the shares of removed or suspended maintainers, who earn nothing.
+// Any member proposes new shares; the owner's proposals apply at once, the
+// others wait for the owner to approve or reject them. Adding a maintainer
+// does not change the shares, the owner gets back the share of a removed one.
+
+// Roles of the members of a service
+const (
+	M_Owner      = "owner"
+	M_Maintainer = "maintainer"
+)
+
+// Most maintainers of a service, besides its owner
+const MaxMaintainers = 10
+
+// Structure definition for a member of a service
+type maintainer struct {
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	Share int    `json:"share"` // basis points of the service's incentives
+}
+
+// Structure definition for shares waiting for the owner's approval
+type shareProposal struct {
+	Shares   map[string]int `json:"shares"` // member name -> basis points
+	Proposer string         `json:"proposer"`
+	Time     string         `json:"time"`
+}
+
+// Structure definition for a member's part of an amount
+type sharePayment struct {
+	Developer string `json:"developer"`
+	Amount    string `json:"amount"`
+}
+
+// members lists the members of a service, the owner first.
+func members(serviceJSON *service) []maintainer {
+	if len(serviceJSON.Maintainers) == 0 {
+		return []maintainer{{serviceJSON.Developer, M_Owner, MaxBasisPoints}}
+	}
+	return serviceJSON.Maintainers
+}
+
+// memberIndex returns the position of user_name among the members, -1 if
+// it is not one.
+func memberIndex(serviceJSON *service, user_name string) int {
+	for i, member := range members(serviceJSON) {
+		if member.Name == user_name {
+			return i
+		}
+	}
+	return -1
+}
+
+// isMember tells whether user_name develops or maintains a service.
+func isMember(stub shim.ChaincodeStubInterface, serviceJSON *service, user_name string) (bool, error) {
+	devJSON, err := lookupDeveloper(stub, serviceJSON.Developer)
+	if err != nil {
+		return false, err
+	}
+	return devJSON.Name == user_name || memberIndex(serviceJSON, user_name) >= 0, nil
+}
+
+// splitShares divides amount over the members of a service by share. The
+// owner gets the remainder, and the parts of removed or suspended
+// maintainers; members whose part is zero are left out.
+func splitShares(stub shim.ChaincodeStubInterface, serviceJSON *service, amount *big.Int) ([]sharePayment, error) {
+	list := members(serviceJSON)
+	parts := make([]*big.Int, len(list))
+	left := big.NewInt(0).Set(amount)
+	for i, member := range list {
+		parts[i] = big.NewInt(0)
+		if i > 0 && member.Share > 0 {
+			userJSON, err := lookupDeveloper(stub, member.Name)
+			if err != nil {
+				return nil, err
+			}
+			if userJSON.Removed || userJSON.Suspension != nil {
+				continue
+			}
+		}
+		parts[i].Mul(amount, big.NewInt(int64(member.Share)))
+		parts[i].Quo(parts[i], big.NewInt(MaxBasisPoints))
+		left.Sub(left, parts[i])
+	}
+	parts[0].Add(parts[0], left)
+
+	payments := []sharePayment{}
+	for i, member := range list {
+		if parts[i].Sign() > 0 {
+			payments = append(payments, sharePayment{member.Name, parts[i].String()})
+		}
+	}
+	return payments, nil
+}
+
+// handOver makes new_owner the owner of the members of a transferred
+// service. A maintainer taking over keeps its share on top of the owner's.
+// Pending shares are dropped.
+func handOver(serviceJSON *service, new_owner string) {
+	serviceJSON.ProposedShares = nil
+	if len(serviceJSON.Maintainers) == 0 {
+		return
+	}
+	list := []maintainer{{new_owner, M_Owner, serviceJSON.Maintainers[0].Share}}
+	for _, member := range serviceJSON.Maintainers[1:] {
+		if member.Name == new_owner {
+			list[0].Share += member.Share
+		} else {
+			list = append(list, member)
+		}
+	}
+	serviceJSON.Maintainers = list
+	if len(list) == 1 {
+		serviceJSON.Maintainers = nil
+	}
+}
+
+// authorizeMaintainer makes sure the invoker is the owner or an active
+// maintainer of serviceJSON. It returns the invoker's address.
+func authorizeMaintainer(stub shim.ChaincodeStubInterface, serviceJSON *service) (string, error) {
+	senderAdd, err := authorize(stub, serviceJSON)
+	if err == nil {
+		return senderAdd, nil
+	}
+	senderAdd, err = senderAddress(stub)
+	if err != nil {
+		return "", errors.New("Fail to get the sender's address.")
+	}
+	userJSON, err := userByAddress(stub, senderAdd)
+	if err != nil {
+		return "", err
+	}
+	if userJSON == nil || memberIndex(serviceJSON, userJSON.Name) < 1 {
+		return "", errors.New("Authority err! Not invoked by the service's developer or a maintainer.")
+	}
+	err = checkActiveUser(stub, userJSON.Name)
+	if err != nil {
+		return "", err
+	}
+	return senderAdd, nil
+}
+
+// ===============================================================
+// addMaintainer: add a maintainer to a service, owner only
+// ===============================================================
+func (t *serviceChaincode) addMaintainer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var user_name string
+	var err error
+
+	service_name = args[0]
+	user_name = args[1]
+
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: only the owner adds maintainers
+	_, err = authorize(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: the maintainer is another active user
+	err = checkActiveUser(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if memberIndex(serviceJSON, user_name) >= 0 {
+		return shim.Error("This user already maintains the service: " + user_name)
+	}
+	if len(members(serviceJSON)) > MaxMaintainers {
+		return shim.Error("A service can not have more than " + strconv.Itoa(MaxMaintainers) + " maintainers.")
+	}
+
+	// STEP 2: add it without a share
+	serviceJSON.Maintainers = append(members(serviceJSON), maintainer{user_name, M_Maintainer, 0})
+
+	return storeMaintainers(stub, serviceJSON, AddMaintainer, user_name)
+}
+
+// ===============================================================
+// removeMaintainer: remove a maintainer, by the owner or itself
+// ===============================================================
+func (t *serviceChaincode) removeMaintainer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var user_name string
+	var err error
+
+	service_name = args[0]
+	user_name = args[1]
+
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	i := memberIndex(serviceJSON, user_name)
+	if i < 1 {
+		return shim.Error("This user is not a maintainer of the service: " + user_name)
+	}
+
+	// STEP 0: the owner removes maintainers, a maintainer may leave
+	_, err = authorize(stub, serviceJSON)
+	if err != nil {
+		userJSON, err := readUser(stub, user_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		senderAdd, err := senderAddress(stub)
+		if err != nil {
+			return shim.Error("Fail to get the sender's address.")
+		}
+		if senderAdd != userJSON.Address {
+			return shim.Error("Authority err! Not invoked by the service's developer or the maintainer.")
+		}
+	}
+
+	// STEP 1: its share goes back to the owner, pending shares are dropped
+	list := serviceJSON.Maintainers
+	list[0].Share += list[i].Share
+	serviceJSON.Maintainers = append(list[:i], list[i+1:]...)
+	if len(serviceJSON.Maintainers) == 1 {
+		serviceJSON.Maintainers = nil
+	}
+	serviceJSON.ProposedShares = nil
+
+	return storeMaintainers(stub, serviceJSON, RemoveMaintainer, user_name)
+}
+
+// ===============================================================
+// proposeShares: propose the revenue shares of the members
+// args[1] is a JSON object of member name -> basis points,
+// omitted members get no share
+// ===============================================================
+func (t *serviceChaincode) proposeShares(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var err error
+
+	service_name = args[0]
+
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: any member proposes
+	senderAdd, err := authorizeMaintainer(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: check the shares
+	var shares map[string]int
+	err = json.Unmarshal([]byte(args[1]), &shares)
+	if err != nil {
+		return shim.Error("Error unmarshal shares: " + err.Error())
+	}
+	total := 0
+	for name, share := range shares {
+		if memberIndex(serviceJSON, name) < 0 {
+			return shim.Error("This user is not a member of the service: " + name)
+		}
+		if share < 0 || share > MaxBasisPoints {
+			return shim.Error("A share must be between 0 and 10000 basis points.")
+		}
+		total += share
+	}
+	if total != MaxBasisPoints {
+		return shim.Error("The shares must add up to 10000 basis points.")
+	}
+
+	// STEP 2: the owner's shares apply, the others wait for its approval
+	owner, err := lookupDeveloper(stub, serviceJSON.Developer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if senderAdd == owner.Address {
+		applyShares(serviceJSON, shares)
+		return storeMaintainers(stub, serviceJSON, ProposeShares, "")
+	}
+	proposer, err := userByAddress(stub, senderAdd)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	serviceJSON.ProposedShares = &shareProposal{shares, proposer.Name, txTime.Format(time.UnixDate)}
+
+	return storeMaintainers(stub, serviceJSON, ProposeShares, "")
+}
+
+// ===============================================================
+// approveShares: apply the proposed shares, owner only
+// ===============================================================
+func (t *serviceChaincode) approveShares(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.decideShares(stub, args[0], true)
+}
+
+// ===============================================================
+// rejectShares: drop the proposed shares, owner only
+// ===============================================================
+func (t *serviceChaincode) rejectShares(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.decideShares(stub, args[0], false)
+}
+
+func (t *serviceChaincode) decideShares(stub shim.ChaincodeStubInterface, service_name string, approved bool) pb.Response {
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: only the owner decides
+	_, err = authorize(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if serviceJSON.ProposedShares == nil {
+		return shim.Error("No shares are proposed for this service: " + service_name)
+	}
+
+	// STEP 1: apply or drop the proposal
+	if approved {
+		applyShares(serviceJSON, serviceJSON.ProposedShares.Shares)
+		return storeMaintainers(stub, serviceJSON, ApproveShares, "")
+	}
+	serviceJSON.ProposedShares = nil
+	return storeMaintainers(stub, serviceJSON, RejectShares, "")
+}
+
+// applyShares sets the shares of the members and drops the proposal.
+func applyShares(serviceJSON *service, shares map[string]int) {
+	list := members(serviceJSON)
+	for i := range list {
+		list[i].Share = shares[list[i].Name]
+	}
+	serviceJSON.Maintainers = list
+	serviceJSON.ProposedShares = nil
+}
+
+// storeMaintainers stores a service whose members changed and emits the
+// event, about user_name when not empty.
+func storeMaintainers(stub shim.ChaincodeStubInterface, serviceJSON *service, name string, user_name string) pb.Response {
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(ServicePrefix+serviceJSON.Name, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	keys := []string{ServicePrefix + serviceJSON.Name}
+	if user_name != "" {
+		keys = append(keys, UserPrefix+user_name)
+	}
+	data := events.Maintainers{Service: serviceJSON.Name, Maintainers: []events.Maintainer{}}
+	for _, member := range members(serviceJSON) {
+		data.Maintainers = append(data.Maintainers, events.Maintainer{Name: member.Name, Role: member.Role, Share: member.Share})
+	}
+	if serviceJSON.ProposedShares != nil {
+		data.ProposedShares = serviceJSON.ProposedShares.Shares
+	}
+	err = emitEvent(stub, name, keys, serviceJSON.Status, serviceJSON.Status, data)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(serviceJSONasBytes)
+}
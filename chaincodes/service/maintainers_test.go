@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+func memberShares(s service) string {
+	rows := []string{}
+	for _, m := range members(&s) {
+		rows = append(rows, fmt.Sprintf("%s:%s:%d", m.Name, m.Role, m.Share))
+	}
+	return strings.Join(rows, ",")
+}
+
+// rewardSplit describes the split of the last reward to a developer.
+func rewardSplit(t *testing.T, stub *shimtest.Stub, developer string) string {
+	t.Helper()
+	rewards := getRewards(t, stub, QueryDeveloperRewards, developer).Rewards
+	rows := []string{}
+	for _, part := range rewards[len(rewards)-1].Split {
+		rows = append(rows, part.Developer+":"+part.Amount)
+	}
+	return strings.Join(rows, ",")
+}
+
+func TestMaintainers(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	// only the owner adds active users once
+	mustFail(t, stub, addr3, AddMaintainer, "Twitter", "user3")
+	mustFail(t, stub, addr2, AddMaintainer, "Twitter", "user2")
+	mustFail(t, stub, addr2, AddMaintainer, "Twitter", "user9")
+	mustInvoke(t, stub, addr2, AddMaintainer, "Twitter", "user3")
+	if envelope, data := lastEvent(t, stub); envelope != "addMaintainer "+addr2+" SER_Twitter,USER_user3 created>created" ||
+		len(data.(*events.Maintainers).Maintainers) != 2 {
+		t.Fatalf("event = %s %+v", envelope, data)
+	}
+	mustFail(t, stub, addr2, AddMaintainer, "Twitter", "user3")
+	if got := memberShares(getService(t, stub, "Twitter")); got != "user2:owner:10000,user3:maintainer:0" {
+		t.Fatalf("members = %s", got)
+	}
+
+	// maintainers edit and publish, the rest is left to the owner
+	mustInvoke(t, stub, addr3, EditService, "Twitter", "Description", "Tweets and threads API.")
+	mustInvoke(t, stub, addr3, PublishService, "Twitter")
+	mustFail(t, stub, addr3, InvalidateService, "Twitter", "Mine now.")
+	mustFail(t, stub, addr3, DeprecateService, "Twitter", "YouTube")
+	mustFail(t, stub, addr3, OfferServiceTransfer, "Twitter", "user3")
+	mustFail(t, stub, addr3, AddMaintainer, "Twitter", "user1")
+	mustFail(t, stub, addr3, EditService, "YouTube", "Description", "Not mine.")
+
+	// a suspended maintainer does not
+	mustInvoke(t, stub, addr1, SuspendUser, "user3", "Spam.")
+	mustFail(t, stub, addr3, EditService, "Twitter", "Description", "Buy now!")
+	mustInvoke(t, stub, addr1, ReinstateUser, "user3")
+
+	// the owner removes maintainers, a maintainer leaves
+	mustInvoke(t, stub, addr2, AddMaintainer, "Twitter", "user1")
+	mustFail(t, stub, addr3, RemoveMaintainer, "Twitter", "user1")
+	mustFail(t, stub, addr2, RemoveMaintainer, "Twitter", "user2")
+	mustInvoke(t, stub, addr2, RemoveMaintainer, "Twitter", "user1")
+	mustInvoke(t, stub, addr3, RemoveMaintainer, "Twitter", "user3")
+	mustFail(t, stub, addr3, EditService, "Twitter", "Description", "Gone.")
+	if s := getService(t, stub, "Twitter"); s.Maintainers != nil {
+		t.Fatalf("members = %s", memberShares(s))
+	}
+}
+
+func TestRevenueShares(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	mustInvoke(t, stub, addr2, AddMaintainer, "Twitter", "user3")
+
+	// shares cover members only and add up to 10000
+	mustFail(t, stub, addr3, ProposeShares, "Twitter", `{"user2":7000,"user3":2000}`)
+	mustFail(t, stub, addr3, ProposeShares, "Twitter", `{"user2":7000,"user1":3000}`)
+	mustFail(t, stub, addr3, ProposeShares, "Twitter", `{"user2":11000,"user3":-1000}`)
+	mustFail(t, stub, addr1, ProposeShares, "Twitter", `{"user2":7000,"user3":3000}`)
+	mustFail(t, stub, addr3, ProposeShares, "Twitter", `not json`)
+
+	// a maintainer's proposal waits for the owner
+	mustInvoke(t, stub, addr3, ProposeShares, "Twitter", `{"user2":7000,"user3":3000}`)
+	if s := getService(t, stub, "Twitter"); s.ProposedShares == nil || s.ProposedShares.Proposer != "user3" ||
+		memberShares(s) != "user2:owner:10000,user3:maintainer:0" {
+		t.Fatalf("service = %+v", s)
+	}
+	mustFail(t, stub, addr3, ApproveShares, "Twitter")
+	mustInvoke(t, stub, addr2, RejectShares, "Twitter")
+	mustFail(t, stub, addr2, ApproveShares, "Twitter")
+	mustInvoke(t, stub, addr3, ProposeShares, "Twitter", `{"user2":7000,"user3":3000}`)
+	mustInvoke(t, stub, addr2, ApproveShares, "Twitter")
+	if envelope, data := lastEvent(t, stub); envelope != "approveShares "+addr2+" SER_Twitter available>available" ||
+		data.(*events.Maintainers).Maintainers[1] != (events.Maintainer{Name: "user3", Role: M_Maintainer, Share: 3000}) {
+		t.Fatalf("event = %s %+v", envelope, data)
+	}
+
+	// rewards and mashup fees are split by share
+	mustInvoke(t, stub, addr1, RewardService, "Twitter", tokenType, "11")
+	if b2, b3 := balance(stub, addr2), balance(stub, addr3); b2 != "1008" || b3 != "1003" {
+		t.Fatalf("balances after the reward = %s, %s", b2, b3)
+	}
+	if got := rewardSplit(t, stub, "user2"); got != "user2:8,user3:3" {
+		t.Fatalf("reward split = %s", got)
+	}
+	mustInvoke(t, stub, addr1, CreateMashup, "MapTweets", "Mapping", "Tweets on a map.", "Google Maps", "Twitter")
+	if _, payments := getPayout(t, stub, "MapTweets"); payments != "Google Maps:user1:1:10,Twitter:user2:1:7,Twitter:user3:1:3" {
+		t.Fatalf("payments = %s", payments)
+	}
+
+	// the owner's proposals apply at once
+	mustInvoke(t, stub, addr2, ProposeShares, "Twitter", `{"user3":10000}`)
+	if got := memberShares(getService(t, stub, "Twitter")); got != "user2:owner:0,user3:maintainer:10000" {
+		t.Fatalf("members = %s", got)
+	}
+
+	// the share of a removed maintainer goes back to the owner
+	mustInvoke(t, stub, addr2, AddMaintainer, "Twitter", "user1")
+	mustInvoke(t, stub, addr2, ProposeShares, "Twitter", `{"user1":5000,"user3":5000}`)
+	mustInvoke(t, stub, addr2, RemoveMaintainer, "Twitter", "user1")
+	if got := memberShares(getService(t, stub, "Twitter")); got != "user2:owner:5000,user3:maintainer:5000" {
+		t.Fatalf("members = %s", got)
+	}
+
+	// a maintainer taking the service over keeps its share
+	mustInvoke(t, stub, addr2, OfferServiceTransfer, "Twitter", "user3")
+	mustInvoke(t, stub, addr3, AcceptServiceTransfer, "Twitter")
+	if s := getService(t, stub, "Twitter"); s.Developer != "user3" || memberShares(s) != "user3:owner:10000" {
+		t.Fatalf("members after the transfer = %s", memberShares(s))
+	}
+}
+
+func TestInactiveMaintainersEarnNothing(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	mustInvoke(t, stub, addr2, AddMaintainer, "Twitter", "user3")
+	mustInvoke(t, stub, addr2, ProposeShares, "Twitter", `{"user2":7000,"user3":3000}`)
+
+	// the owner gets the share of a suspended maintainer
+	mustInvoke(t, stub, addr1, SuspendUser, "user3", "Spam.")
+	mustInvoke(t, stub, addr1, RewardService, "Twitter", tokenType, "10")
+	if b2, b3 := balance(stub, addr2), balance(stub, addr3); b2 != "1010" || b3 != "1000" {
+		t.Fatalf("balances with a suspended maintainer = %s, %s", b2, b3)
+	}
+	mustInvoke(t, stub, addr1, ReinstateUser, "user3")
+	mustInvoke(t, stub, addr1, RewardService, "Twitter", tokenType, "10")
+	if b2, b3 := balance(stub, addr2), balance(stub, addr3); b2 != "1017" || b3 != "1003" {
+		t.Fatalf("balances after the reinstatement = %s, %s", b2, b3)
+	}
+
+	// and of a removed one, mashup fees included
+	mustInvoke(t, stub, addr3, RemoveUser, "user3")
+	mustInvoke(t, stub, addr1, RewardService, "Twitter", tokenType, "10")
+	if b2, b3 := balance(stub, addr2), balance(stub, addr3); b2 != "1027" || b3 != "1003" {
+		t.Fatalf("balances with a removed maintainer = %s, %s", b2, b3)
+	}
+	mustInvoke(t, stub, addr1, CreateMashup, "MapTweets", "Mapping", "Tweets on a map.", "Google Maps", "Twitter")
+	if _, payments := getPayout(t, stub, "MapTweets"); payments != "Google Maps:user1:1:10,Twitter:user2:1:10" {
+		t.Fatalf("payments = %s", payments)
+	}
+}
+
+func TestMembersShareUsageAndSubscriptions(t *testing.T) {
+	stub := newMeteredStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	mustInvoke(t, stub, addr2, AddMaintainer, "Twitter", "user1")
+	mustInvoke(t, stub, addr2, ProposeShares, "Twitter", `{"user2":7000,"user1":3000}`)
+
+	// settled usage is paid by share
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-01", "b1", batch(usage(addr3, "Twitter", 10)))
+	stub.SetTime(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC).Add(SettlementGrace))
+	mustInvoke(t, stub, addr3, SettleUsage, "2018-01")
+	if b1, b2 := balance(stub, addr1), balance(stub, addr2); b1 != "1006" || b2 != "1014" {
+		t.Fatalf("balances after the settlement = %s, %s", b1, b2)
+	}
+
+	// and so are the plans
+	mustInvoke(t, stub, addr2, SetSubscriptionPlan, "Twitter", "basic", tokenType, "100", "720h", "1000")
+	mustInvoke(t, stub, addr3, Subscribe, "Twitter", "basic")
+	mustInvoke(t, stub, addr3, RenewSubscription, "Twitter")
+	if b1, b2, b3 := balance(stub, addr1), balance(stub, addr2), balance(stub, addr3); b1 != "1066" || b2 != "1154" || b3 != "780" {
+		t.Fatalf("balances after the subscription = %s, %s, %s", b1, b2, b3)
+	}
+}
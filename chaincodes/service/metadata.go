@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Service metadata
+// ==================================================================================
+//
+// A service optionally describes how to reach and use it: its endpoint,
+// protocol and auth scheme, tags, license, documentation, pricing model and
+// the hash of its API specification. registerService takes the metadata as
+// a JSON document, and editService's "Metadata" field merges a document into
+// it: omitted fields keep their value, "" or [] clears one. Every field is
+// checked and normalized, see validate.
+//
+//	{
+//	  "endpointUrl": "https://maps.example.com/api",
+//	  "protocol": "REST",
+//	  "authScheme": "apiKey",
+//	  "tags": ["maps", "geo"],
+//	  "license": "Apache-2.0",
+//	  "docsUrl": "https://maps.example.com/docs",
+//	  "pricingModel": "freemium",
+//	  "specHash": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+//	}
+
+// Metadata limits
+const (
+	MaxURLLength = 2048
+	MaxTags      = 10
+)
+
+// Protocols, auth schemes and pricing models, by their lower-cased name
+var (
+	metadataProtocols = map[string]string{
+		"rest": "REST", "soap": "SOAP", "grpc": "gRPC", "graphql": "GraphQL",
+	}
+	metadataAuthSchemes = map[string]string{
+		"none": "none", "apikey": "apiKey", "basic": "basic", "bearer": "bearer", "oauth2": "oauth2",
+	}
+	metadataPricingModels = map[string]string{
+		"free": "free", "freemium": "freemium", "per-call": "per-call", "subscription": "subscription",
+	}
+)
+
+var (
+	tagPattern      = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+	licensePattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+-]{0,63}$`)
+	specHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// Structure definition for the metadata of a service
+type serviceMetadata struct {
+	// EndpointURL is an http(s) URL, or a path like "/api/maps" on the
+	// ecosystem's gateway.
+	EndpointURL  string   `json:"endpointUrl,omitempty"`
+	Protocol     string   `json:"protocol,omitempty"`     // REST, SOAP, gRPC or GraphQL
+	AuthScheme   string   `json:"authScheme,omitempty"`   // none, apiKey, basic, bearer or oauth2
+	Tags         []string `json:"tags,omitempty"`         // lower-case words and dashes
+	License      string   `json:"license,omitempty"`      // SPDX identifier, e.g. MIT
+	DocsURL      string   `json:"docsUrl,omitempty"`      // http(s) URL
+	PricingModel string   `json:"pricingModel,omitempty"` // free, freemium, per-call or subscription
+	SpecHash     string   `json:"specHash,omitempty"`     // "sha256:" and 64 hex digits
+}
+
+// validate checks the metadata and normalizes its names.
+func (m *serviceMetadata) validate() error {
+	if m.EndpointURL != "" && !isEndpoint(m.EndpointURL) {
+		return errors.New("Invalid endpoint URL: " + m.EndpointURL)
+	}
+	if m.DocsURL != "" && !isWebURL(m.DocsURL) {
+		return errors.New("Invalid documentation URL: " + m.DocsURL)
+	}
+
+	var ok bool
+	if m.Protocol != "" {
+		if m.Protocol, ok = metadataProtocols[strings.ToLower(m.Protocol)]; !ok {
+			return errors.New("The protocol must be REST, SOAP, gRPC or GraphQL.")
+		}
+	}
+	if m.AuthScheme != "" {
+		if m.AuthScheme, ok = metadataAuthSchemes[strings.ToLower(m.AuthScheme)]; !ok {
+			return errors.New("The auth scheme must be none, apiKey, basic, bearer or oauth2.")
+		}
+	}
+	if m.PricingModel != "" {
+		if m.PricingModel, ok = metadataPricingModels[strings.ToLower(m.PricingModel)]; !ok {
+			return errors.New("The pricing model must be free, freemium, per-call or subscription.")
+		}
+	}
+
+	if len(m.Tags) > MaxTags {
+		return errors.New("A service can not have more than " + strconv.Itoa(MaxTags) + " tags.")
+	}
+	seen := make(map[string]bool)
+	for i, tag := range m.Tags {
+		tag = strings.ToLower(tag)
+		if !tagPattern.MatchString(tag) || seen[tag] {
+			return errors.New("Invalid tag: " + m.Tags[i])
+		}
+		seen[tag] = true
+		m.Tags[i] = tag
+	}
+	if len(m.Tags) == 0 {
+		m.Tags = nil
+	}
+
+	if m.License != "" && !licensePattern.MatchString(m.License) {
+		return errors.New("Invalid license identifier: " + m.License)
+	}
+	if m.SpecHash != "" {
+		hash := strings.TrimPrefix(strings.ToLower(m.SpecHash), "sha256:")
+		if !specHashPattern.MatchString(hash) {
+			return errors.New("The spec hash must be a SHA-256 digest in hex: " + m.SpecHash)
+		}
+		m.SpecHash = "sha256:" + hash
+	}
+	return nil
+}
+
+// isEmpty reports whether no field of the metadata is set.
+func (m *serviceMetadata) isEmpty() bool {
+	return m.EndpointURL == "" && m.Protocol == "" && m.AuthScheme == "" && len(m.Tags) == 0 &&
+		m.License == "" && m.DocsURL == "" && m.PricingModel == "" && m.SpecHash == ""
+}
+
+// isWebURL reports whether s is an absolute http(s) URL.
+func isWebURL(s string) bool {
+	if len(s) > MaxURLLength {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isEndpoint reports whether s is an absolute http(s) URL or an absolute path.
+func isEndpoint(s string) bool {
+	if strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") {
+		u, err := url.Parse(s)
+		return err == nil && len(s) <= MaxURLLength && u.Scheme == "" && u.Host == ""
+	}
+	return isWebURL(s)
+}
+
+// parseMetadata reads a metadata document over base, nil for a new one,
+// and validates the result. It returns nil when no field is left.
+func parseMetadata(document string, base *serviceMetadata) (*serviceMetadata, error) {
+	metadata := &serviceMetadata{}
+	if base != nil {
+		*metadata = *base
+		metadata.Tags = append([]string(nil), base.Tags...)
+	}
+	err := json.Unmarshal([]byte(document), metadata)
+	if err != nil {
+		return nil, errors.New("Error unmarshal metadata: " + err.Error())
+	}
+	err = metadata.validate()
+	if err != nil {
+		return nil, err
+	}
+	if metadata.isEmpty() {
+		return nil, nil
+	}
+	return metadata, nil
+}
+
+// metadataJSON returns metadata as a JSON document, "{}" for none.
+func metadataJSON(metadata *serviceMetadata) string {
+	if metadata == nil {
+		return "{}"
+	}
+	metadataAsBytes, _ := json.Marshal(metadata)
+	return string(metadataAsBytes)
+}
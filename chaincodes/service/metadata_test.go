@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+)
+
+const specHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func TestRegisterServiceMetadata(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	mustInvoke(t, stub, addr1, RegisterService, "Bing Maps", "Mapping", "Maps API.", "user1",
+		`{"endpointUrl":"/api/bing-maps","protocol":"rest","authScheme":"APIKEY","tags":["Maps","geo"],
+		"license":"Apache-2.0","docsUrl":"https://bing.example.com/docs","pricingModel":"Freemium",
+		"specHash":"SHA256:`+strings.ToUpper(specHash)+`"}`)
+	want := &serviceMetadata{EndpointURL: "/api/bing-maps", Protocol: "REST", AuthScheme: "apiKey",
+		Tags: []string{"maps", "geo"}, License: "Apache-2.0", DocsURL: "https://bing.example.com/docs",
+		PricingModel: "freemium", SpecHash: "sha256:" + specHash}
+	if got := getService(t, stub, "Bing Maps").Metadata; !reflect.DeepEqual(got, want) {
+		t.Fatalf("metadata = %+v, want %+v", got, want)
+	}
+
+	// metadata is optional
+	mustInvoke(t, stub, addr1, RegisterService, "OSM", "Mapping", "Open maps.", "user1", `{}`)
+	if got := getService(t, stub, "OSM").Metadata; got != nil {
+		t.Fatalf("empty metadata = %+v", got)
+	}
+	if got := getService(t, stub, "Google Maps").Metadata; got != nil {
+		t.Fatalf("no metadata = %+v", got)
+	}
+
+	for _, metadata := range []string{
+		`not json`,
+		`{"endpointUrl":"maps.example.com/api"}`,
+		`{"endpointUrl":"ftp://maps.example.com/api"}`,
+		`{"endpointUrl":"//maps.example.com/api"}`,
+		`{"endpointUrl":"/` + strings.Repeat("a", MaxURLLength) + `"}`,
+		`{"docsUrl":"/docs"}`,
+		`{"docsUrl":"https://"}`,
+		`{"protocol":"JSON-RPC"}`,
+		`{"authScheme":"cookie"}`,
+		`{"pricingModel":"cheap"}`,
+		`{"tags":["maps","Maps"]}`,
+		`{"tags":["two words"]}`,
+		`{"tags":["-maps"]}`,
+		`{"tags":["a","b","c","d","e","f","g","h","i","j","k"]}`,
+		`{"license":"GPL 3"}`,
+		`{"specHash":"sha1:` + specHash + `"}`,
+		`{"specHash":"` + specHash[1:] + `"}`,
+	} {
+		mustFail(t, stub, addr1, RegisterService, "Broken", "Mapping", "Broken metadata.", "user1", metadata)
+	}
+	mustFail(t, stub, addr1, QueryService, "Broken")
+}
+
+func TestEditServiceMetadata(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	// a document is merged into the metadata
+	mustInvoke(t, stub, addr1, EditService, "Google Maps", "Metadata", `{"protocol":"graphql","tags":["maps"]}`)
+	if _, data := lastEvent(t, stub); *data.(*events.ServiceEdited) != (events.ServiceEdited{Service: "Google Maps",
+		Field: "Metadata", OldValue: `{}`, NewValue: `{"protocol":"GraphQL","tags":["maps"]}`}) {
+		t.Fatalf("event data = %+v", data)
+	}
+	mustInvoke(t, stub, addr1, EditService, "Google Maps", "Metadata", `{"license":"MIT","tags":["maps","routes"]}`)
+	want := &serviceMetadata{Protocol: "GraphQL", Tags: []string{"maps", "routes"}, License: "MIT"}
+	if got := getService(t, stub, "Google Maps").Metadata; !reflect.DeepEqual(got, want) {
+		t.Fatalf("metadata = %+v, want %+v", got, want)
+	}
+
+	// invalid documents change nothing
+	mustFail(t, stub, addr1, EditService, "Google Maps", "Metadata", `{"protocol":"ws"}`)
+	mustFail(t, stub, addr2, EditService, "Google Maps", "Metadata", `{"license":"GPL-3.0"}`)
+	if got := getService(t, stub, "Google Maps").Metadata; !reflect.DeepEqual(got, want) {
+		t.Fatalf("metadata = %+v, want %+v", got, want)
+	}
+
+	// empty values clear fields
+	mustInvoke(t, stub, addr1, EditService, "Google Maps", "Metadata", `{"tags":[],"license":""}`)
+	if got := getService(t, stub, "Google Maps").Metadata; !reflect.DeepEqual(got, &serviceMetadata{Protocol: "GraphQL"}) {
+		t.Fatalf("metadata = %+v", got)
+	}
+	mustInvoke(t, stub, addr1, EditService, "Google Maps", "Metadata", `{"protocol":""}`)
+	if got := getService(t, stub, "Google Maps").Metadata; got != nil {
+		t.Fatalf("cleared metadata = %+v", got)
+	}
+}
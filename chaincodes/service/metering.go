@@ -0,0 +1,592 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Pay-per-use metering
+// ==================================================================================
+//
+// Developers price their services per call. Gateways authorized by an admin
+// report how often each consumer called each service with recordUsage; the
+// calls are priced when recorded, so a later price change does not reprice
+// them. Usage is accumulated per period (a month, "2006-01"). Calls covered
+// by a subscription running in the period are counted but not charged, see
+// subscription.go.
+//
+// A consumer settles a period with settleUsage once the period is over and
+// the gateways had SettlementGrace to report it. It pays the members of the
+// services by share (see maintainers.go) through Transfer and stores the
+// period's statement. Both functions are
+// idempotent: a batch id is recorded once per gateway and a settled period
+// returns its statement without paying again. Usage reported for a period the
+// consumer has already settled is not recorded; recordUsage returns those
+// entries apart and records the rest of the batch.
+
+// Layout of usage periods
+const UsagePeriodLayout = "2006-01"
+
+// SettlementGrace is the time after the end of a period before it can be
+// settled, for the gateways' last batches
+const SettlementGrace = 72 * time.Hour
+
+// Structure definition for the price of a service
+type servicePrice struct {
+	TokenType string `json:"tokenType"`
+	PerCall   string `json:"perCall"`
+}
+
+// Structure definition for one entry of a usage batch
+type usageEntry struct {
+	Consumer string `json:"consumer"` // address of the consumer
+	Service  string `json:"service"`
+	Calls    int    `json:"calls"`
+}
+
+// Structure definition for the usage of a service by a consumer in a period
+type usageRecord struct {
+	Period    string `json:"period"`
+	Consumer  string `json:"consumer"`
+	Service   string `json:"service"`
+	Calls     int    `json:"calls"`
+	Covered   int    `json:"covered"`             // calls covered by a subscription, not charged
+	TokenType string `json:"tokenType,omitempty"` // empty for free calls
+	Amount    string `json:"amount"`
+}
+
+// Structure definition for a line of a statement
+type statementLine struct {
+	Service   string `json:"service"`
+	Developer string `json:"developer"`
+	Calls     int    `json:"calls"`
+	Covered   int    `json:"covered"`
+	TokenType string `json:"tokenType,omitempty"`
+	Amount    string `json:"amount"`
+}
+
+// Structure definition for the result of recordUsage
+type usageBatchResult struct {
+	Recorded int          `json:"recorded"` // entries recorded
+	Settled  []usageEntry `json:"settled"`  // entries of consumers who settled the period, not recorded
+}
+
+// Structure definition for the statement of a settled period
+type usageStatement struct {
+	Period   string            `json:"period"`
+	Consumer string            `json:"consumer"`
+	Lines    []statementLine   `json:"lines"`
+	Totals   map[string]string `json:"totals"` // token type -> amount paid
+	TxID     string            `json:"txId"`
+	Time     string            `json:"time"`
+}
+
+// isGateway tells whether address is an authorized gateway.
+func isGateway(stub shim.ChaincodeStubInterface, address string) (bool, error) {
+	gateway_key, err := stub.CreateCompositeKey(GatewayIndex, []string{address})
+	if err != nil {
+		return false, err
+	}
+	gatewayAsBytes, err := stub.GetState(gateway_key)
+	if err != nil {
+		return false, errors.New("Fail to get gateway: " + err.Error())
+	}
+	return gatewayAsBytes != nil, nil
+}
+
+// parsePeriod checks a usage period and returns its start.
+func parsePeriod(period string) (time.Time, error) {
+	start, err := time.Parse(UsagePeriodLayout, period)
+	if err != nil {
+		return time.Time{}, errors.New("Expecting a usage period like 2018-01: " + period)
+	}
+	return start, nil
+}
+
+// getStatement reads the statement of a consumer's period, nil if unsettled.
+func getStatement(stub shim.ChaincodeStubInterface, period string, consumer string) ([]byte, error) {
+	statement_key, err := stub.CreateCompositeKey(UsageStatementIndex, []string{period, consumer})
+	if err != nil {
+		return nil, err
+	}
+	statementAsBytes, err := stub.GetState(statement_key)
+	if err != nil {
+		return nil, errors.New("Fail to get statement: " + err.Error())
+	}
+	return statementAsBytes, nil
+}
+
+// priceCalls prices calls of serviceJSON, returning the token type and amount.
+func priceCalls(serviceJSON *service, calls int) (string, *big.Int) {
+	if serviceJSON.Price == nil {
+		return "", big.NewInt(0)
+	}
+	per_call, _ := big.NewInt(0).SetString(serviceJSON.Price.PerCall, 10)
+	return serviceJSON.Price.TokenType, per_call.Mul(per_call, big.NewInt(int64(calls)))
+}
+
+// ===============================================================
+// authorizeGateway: allow an address to record usage
+// ===============================================================
+func (t *serviceChaincode) authorizeGateway(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.setGateway(stub, args[0], true)
+}
+
+// ===============================================================
+// revokeGateway: stop an address from recording usage
+// ===============================================================
+func (t *serviceChaincode) revokeGateway(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.setGateway(stub, args[0], false)
+}
+
+func (t *serviceChaincode) setGateway(stub shim.ChaincodeStubInterface, address string, authorized bool) pb.Response {
+	address = normalizeAddress(address)
+
+	// only admins manage the gateways
+	_, err := authorize(stub, nil, R_Admin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if address == "" {
+		return shim.Error("The gateway address can not be empty.")
+	}
+
+	gateway_key, err := stub.CreateCompositeKey(GatewayIndex, []string{address})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	name := AuthorizeGateway
+	if authorized {
+		err = stub.PutState(gateway_key, []byte{0x00})
+	} else {
+		name = RevokeGateway
+		err = stub.DelState(gateway_key)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, name, []string{gateway_key}, "", "", events.Gateway{Address: address})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ===============================================================
+// setServicePrice: set the price per call of a service,
+// a price of 0 makes it free
+// ===============================================================
+func (t *serviceChaincode) setServicePrice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var token_type string
+	var err error
+
+	service_name = args[0]
+	token_type = args[1]
+
+	per_call, err := parseAmount("the price", args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if per_call.Sign() > 0 && token_type == "" {
+		return shim.Error("The token type of the price can not be empty.")
+	}
+
+	// STEP 0: only the developer prices the service
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = authorize(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: store the price with the service
+	priced := events.ServicePriced{Service: service_name}
+	if per_call.Sign() == 0 {
+		serviceJSON.Price = nil
+	} else {
+		serviceJSON.Price = &servicePrice{token_type, per_call.String()}
+		priced.TokenType, priced.PerCall = token_type, per_call.String()
+	}
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(ServicePrefix+service_name, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, SetServicePrice, []string{ServicePrefix + service_name}, serviceJSON.Status, serviceJSON.Status, priced)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(serviceJSONasBytes)
+}
+
+// ===============================================================
+// recordUsage: record a gateway's batch of service calls
+// ===============================================================
+func (t *serviceChaincode) recordUsage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var period string
+	var batch_id string
+	var err error
+
+	period = args[0]
+	batch_id = args[1]
+
+	period_start, err := parsePeriod(period)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if batch_id == "" {
+		return shim.Error("The batch id can not be empty.")
+	}
+	var entries []usageEntry
+	err = json.Unmarshal([]byte(args[2]), &entries)
+	if err != nil {
+		return shim.Error("Error unmarshal usage entries: " + err.Error())
+	}
+
+	// STEP 0: only authorized gateways record usage
+	gateway, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	authorized, err := isGateway(stub, gateway)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if !authorized {
+		return shim.Error("Authority err! Not invoked by an authorized gateway.")
+	}
+
+	// STEP 1: a batch is recorded once
+	batch_key, err := stub.CreateCompositeKey(UsageBatchIndex, []string{gateway, batch_id})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	batchAsBytes, err := stub.GetState(batch_key)
+	if err != nil {
+		return shim.Error("Fail to get usage batch: " + err.Error())
+	} else if batchAsBytes != nil {
+		return shim.Success([]byte("Usage batch already recorded."))
+	}
+
+	// STEP 2: accumulate the entries, every record is written once
+	result := usageBatchResult{Settled: []usageEntry{}}
+	records := make(map[string]*usageRecord)
+	services := make(map[string]*service)
+	settled := make(map[string]bool)
+	cover, err := newSubscriptionCover(stub, period_start)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, entry := range entries {
+		entry.Consumer = normalizeAddress(entry.Consumer)
+		if entry.Consumer == "" || entry.Calls <= 0 {
+			return shim.Error("Expecting a consumer and a positive number of calls for service: " + entry.Service)
+		}
+		serviceJSON, ok := services[entry.Service]
+		if !ok {
+			serviceJSON, err = readService(stub, entry.Service)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			services[entry.Service] = serviceJSON
+		}
+		if _, ok := settled[entry.Consumer]; !ok {
+			statementAsBytes, err := getStatement(stub, period, entry.Consumer)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			settled[entry.Consumer] = statementAsBytes != nil
+		}
+		if settled[entry.Consumer] {
+			result.Settled = append(result.Settled, entry)
+			continue
+		}
+		result.Recorded++
+
+		// subscriptions cover calls within their quota
+		covered, err := cover.cover(stub, entry.Service, entry.Consumer, entry.Calls)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		token_type, amount := priceCalls(serviceJSON, entry.Calls-covered)
+		usage_key, err := stub.CreateCompositeKey(UsageIndex, []string{period, entry.Consumer, entry.Service, token_type})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		record, ok := records[usage_key]
+		if !ok {
+			record = &usageRecord{period, entry.Consumer, entry.Service, 0, 0, token_type, "0"}
+			usageAsBytes, err := stub.GetState(usage_key)
+			if err != nil {
+				return shim.Error("Fail to get usage: " + err.Error())
+			} else if usageAsBytes != nil {
+				err = json.Unmarshal(usageAsBytes, record)
+				if err != nil {
+					return shim.Error("Error unmarshal usage bytes.")
+				}
+			}
+			records[usage_key] = record
+		}
+		total, _ := big.NewInt(0).SetString(record.Amount, 10)
+		record.Calls += entry.Calls
+		record.Covered += covered
+		record.Amount = total.Add(total, amount).String()
+	}
+
+	// STEP 3: store the usage and the batch
+	keys := []string{batch_key}
+	for usage_key, record := range records {
+		usageAsBytes, err := json.Marshal(record)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(usage_key, usageAsBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		keys = append(keys, usage_key)
+	}
+	sort.Strings(keys[1:])
+	err = cover.store(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(batch_key, []byte(stub.GetTxID()))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, RecordUsage, keys, "", "",
+		events.UsageRecorded{Period: period, Batch: batch_id, Recorded: result.Recorded, Settled: len(result.Settled)})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// usageRecords lists a consumer's usage in a period, the consumer "" lists
+// everybody's.
+func usageRecords(stub shim.ChaincodeStubInterface, period string, consumer string) ([]usageRecord, error) {
+	attributes := []string{period}
+	if consumer != "" {
+		attributes = append(attributes, consumer)
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(UsageIndex, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records := []usageRecord{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var record usageRecord
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, errors.New("Error unmarshal usage bytes.")
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ===============================================================
+// settleUsage: pay the sender's usage of a period
+// ===============================================================
+func (t *serviceChaincode) settleUsage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var period string
+	var err error
+
+	period = args[0]
+	start, err := parsePeriod(period)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	consumer, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+
+	// STEP 0: a settled period returns its statement again
+	statementAsBytes, err := getStatement(stub, period, consumer)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if statementAsBytes != nil {
+		return shim.Success(statementAsBytes)
+	}
+
+	// the period is over and its usage reported
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	settle_from := start.AddDate(0, 1, 0).Add(SettlementGrace)
+	if txTime.Before(settle_from) {
+		return shim.Error("Usage of period " + period + " can be settled from " + settle_from.Format(time.UnixDate) + ".")
+	}
+
+	// STEP 1: price the period's usage by member
+	records, err := usageRecords(stub, period, consumer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	statement := usageStatement{Period: period, Consumer: consumer,
+		Lines: []statementLine{}, Totals: make(map[string]string), TxID: stub.GetTxID()}
+	owed := make(map[string]map[string]*big.Int) // member -> token type -> amount
+	totals := make(map[string]*big.Int)
+	for _, record := range records {
+		serviceJSON, err := readService(stub, record.Service)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		developer := serviceJSON.Developer
+		statement.Lines = append(statement.Lines, statementLine{record.Service, developer,
+			record.Calls, record.Covered, record.TokenType, record.Amount})
+
+		amount, _ := big.NewInt(0).SetString(record.Amount, 10)
+		if amount.Sign() == 0 {
+			continue
+		}
+		split, err := splitShares(stub, serviceJSON, amount)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, part := range split {
+			if owed[part.Developer] == nil {
+				owed[part.Developer] = make(map[string]*big.Int)
+			}
+			if owed[part.Developer][record.TokenType] == nil {
+				owed[part.Developer][record.TokenType] = big.NewInt(0)
+			}
+			share, _ := big.NewInt(0).SetString(part.Amount, 10)
+			owed[part.Developer][record.TokenType].Add(owed[part.Developer][record.TokenType], share)
+		}
+		if totals[record.TokenType] == nil {
+			totals[record.TokenType] = big.NewInt(0)
+		}
+		totals[record.TokenType].Add(totals[record.TokenType], amount)
+	}
+	for token_type, total := range totals {
+		statement.Totals[token_type] = total.String()
+	}
+
+	// STEP 2: pay every member once per token type
+	developers := make([]string, 0, len(owed))
+	for developer := range owed {
+		developers = append(developers, developer)
+	}
+	sort.Strings(developers)
+	payments := []events.UsagePayment{}
+	for _, developer := range developers {
+		address, err := developerAddress(stub, developer)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		token_types := make([]string, 0, len(owed[developer]))
+		for token_type := range owed[developer] {
+			token_types = append(token_types, token_type)
+		}
+		sort.Strings(token_types)
+		for _, token_type := range token_types {
+			err = stub.Transfer(address, token_type, owed[developer][token_type])
+			if err != nil {
+				return shim.Error("Error when making transfer.")
+			}
+			payments = append(payments, events.UsagePayment{Developer: developer, TokenType: token_type,
+				Amount: owed[developer][token_type].String()})
+		}
+	}
+
+	// STEP 3: store the statement
+	statement.Time = txTime.Format(time.UnixDate)
+	statementAsBytes, err = json.Marshal(statement)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	statement_key, err := stub.CreateCompositeKey(UsageStatementIndex, []string{period, consumer})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(statement_key, statementAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, SettleUsage, []string{statement_key}, "", "",
+		events.UsageSettled{Period: period, Consumer: consumer, Totals: statement.Totals, Payments: payments})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(statementAsBytes)
+}
+
+// ===============================================================
+// queryUsage: query the usage of a period, by consumer
+// ===============================================================
+func (t *serviceChaincode) queryUsage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var period string
+	var consumer string
+
+	period = args[0]
+	if len(args) > 1 {
+		consumer = normalizeAddress(args[1])
+	}
+	_, err := parsePeriod(period)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	records, err := usageRecords(stub, period, consumer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	recordsAsBytes, err := json.Marshal(records)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(recordsAsBytes)
+}
+
+// ===============================================================
+// queryStatement: query the statement of a consumer's settled period
+// ===============================================================
+func (t *serviceChaincode) queryStatement(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var period string
+	var consumer string
+
+	period = args[0]
+	consumer = normalizeAddress(args[1])
+
+	statementAsBytes, err := getStatement(stub, period, consumer)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if statementAsBytes == nil {
+		return shim.Error("Usage of period " + period + " is not settled for consumer: " + consumer)
+	}
+	return shim.Success(statementAsBytes)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+const gateway = "5d4c3b2a190817263544536271808f9e0d1c2b3a"
+
+// newMeteredStub is newEcosystemStub with an authorized gateway,
+// Twitter priced 2 INK and YouTube 3 CCT per call.
+func newMeteredStub(t *testing.T) *shimtest.Stub {
+	stub := newEcosystemStub(t)
+	stub.SetBalance(addr3, "CCT", 1000)
+	mustInvoke(t, stub, addr1, AuthorizeGateway, gateway)
+	mustInvoke(t, stub, addr2, SetServicePrice, "Twitter", tokenType, "2")
+	mustInvoke(t, stub, addr2, SetServicePrice, "YouTube", "CCT", "3")
+	return stub
+}
+
+func usage(consumer string, service string, calls int) string {
+	return fmt.Sprintf(`{"consumer":%q,"service":%q,"calls":%d}`, consumer, service, calls)
+}
+
+func batch(entries ...string) string {
+	return "[" + strings.Join(entries, ",") + "]"
+}
+
+func getUsage(t *testing.T, stub *shimtest.Stub, args ...string) string {
+	t.Helper()
+	var records []usageRecord
+	payload := mustInvoke(t, stub, addr1, QueryUsage, args...)
+	if err := json.Unmarshal(payload, &records); err != nil {
+		t.Fatalf("unmarshal usage %s: %v", payload, err)
+	}
+	rows := make([]string, len(records))
+	for i, r := range records {
+		rows[i] = fmt.Sprintf("%s:%d:%s%s", r.Service, r.Calls, r.Amount, r.TokenType)
+	}
+	return strings.Join(rows, ",")
+}
+
+func TestServicePrice(t *testing.T) {
+	stub := newMeteredStub(t)
+
+	if p := getService(t, stub, "Twitter").Price; p == nil || *p != (servicePrice{tokenType, "2"}) {
+		t.Fatalf("price = %+v", p)
+	}
+	mustInvoke(t, stub, addr2, SetServicePrice, "Twitter", "", "0")
+	if p := getService(t, stub, "Twitter").Price; p != nil {
+		t.Fatalf("price of a free service = %+v", p)
+	}
+
+	mustFail(t, stub, addr1, SetServicePrice, "Twitter", tokenType, "2")
+	mustFail(t, stub, addr2, SetServicePrice, "Twitter", "", "2")
+	mustFail(t, stub, addr2, SetServicePrice, "Twitter", tokenType, "-2")
+	mustFail(t, stub, addr2, SetServicePrice, "Flickr", tokenType, "2")
+}
+
+func TestRecordUsage(t *testing.T) {
+	stub := newMeteredStub(t)
+
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-01", "b1",
+		batch(usage(addr3, "Twitter", 10), usage(addr3, "YouTube", 5), usage(addr3, "Twitter", 5), usage(addr1, "Google Maps", 7)))
+	if got := getUsage(t, stub, "2018-01", addr3); got != "Twitter:15:30INK,YouTube:5:15CCT" {
+		t.Fatalf("usage = %s", got)
+	}
+
+	// a retried batch is recorded once
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-01", "b1", batch(usage(addr3, "Twitter", 10)))
+	if got := getUsage(t, stub, "2018-01", addr3); got != "Twitter:15:30INK,YouTube:5:15CCT" {
+		t.Fatalf("usage after retry = %s", got)
+	}
+
+	// calls keep the price they were recorded at
+	mustInvoke(t, stub, addr2, SetServicePrice, "Twitter", tokenType, "4")
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-01", "b2", batch(usage(addr3, "Twitter", 1)))
+	if got := getUsage(t, stub, "2018-01", addr3); got != "Twitter:16:34INK,YouTube:5:15CCT" {
+		t.Fatalf("usage after price change = %s", got)
+	}
+	if got := getUsage(t, stub, "2018-01"); !strings.HasPrefix(got, "Google Maps:7:0") {
+		t.Fatalf("usage of everybody = %s", got)
+	}
+	if got := getUsage(t, stub, "2018-02"); got != "" {
+		t.Fatalf("usage of another period = %s", got)
+	}
+
+	mustFail(t, stub, addr3, RecordUsage, "2018-01", "b3", batch(usage(addr3, "Twitter", 1)))
+	mustFail(t, stub, gateway, RecordUsage, "January", "b3", batch(usage(addr3, "Twitter", 1)))
+	mustFail(t, stub, gateway, RecordUsage, "2018-01", "", batch(usage(addr3, "Twitter", 1)))
+	mustFail(t, stub, gateway, RecordUsage, "2018-01", "b3", batch(usage(addr3, "Twitter", 0)))
+	mustFail(t, stub, gateway, RecordUsage, "2018-01", "b3", batch(usage("", "Twitter", 1)))
+	mustFail(t, stub, gateway, RecordUsage, "2018-01", "b3", batch(usage(addr3, "Flickr", 1)))
+	mustFail(t, stub, gateway, RecordUsage, "2018-01", "b3", "not json")
+
+	// revoked gateways can not record
+	mustInvoke(t, stub, addr1, RevokeGateway, gateway)
+	mustFail(t, stub, gateway, RecordUsage, "2018-01", "b3", batch(usage(addr3, "Twitter", 1)))
+	mustFail(t, stub, addr2, AuthorizeGateway, addr2)
+}
+
+func TestSettleUsage(t *testing.T) {
+	stub := newMeteredStub(t)
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-01", "b1",
+		batch(usage(addr3, "Twitter", 10), usage(addr3, "YouTube", 5), usage(addr3, "Google Maps", 7)))
+
+	// a period is settled after its end and the grace for late batches
+	stub.SetTime(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC).Add(SettlementGrace))
+	var statement usageStatement
+	payload := mustInvoke(t, stub, addr3, SettleUsage, "2018-01")
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		t.Fatalf("unmarshal statement %s: %v", payload, err)
+	}
+	if statement.Consumer != addr3 || len(statement.Lines) != 3 ||
+		statement.Totals[tokenType] != "20" || statement.Totals["CCT"] != "15" {
+		t.Fatalf("statement = %+v", statement)
+	}
+	if b2, b3 := balance(stub, addr2), balance(stub, addr3); b2 != "1020" || b3 != "980" {
+		t.Fatalf("balances = %s, %s", b2, b3)
+	}
+	if b := stub.Balance(addr2, "CCT"); b == nil || b.String() != "15" {
+		t.Fatalf("CCT balance = %v", b)
+	}
+
+	// settling again returns the statement without paying twice
+	again := mustInvoke(t, stub, addr3, SettleUsage, "2018-01")
+	if string(again) != string(payload) || balance(stub, addr3) != "980" {
+		t.Fatalf("second settlement = %s, balance %s", again, balance(stub, addr3))
+	}
+	if got := mustInvoke(t, stub, addr1, QueryStatement, "2018-01", addr3); string(got) != string(payload) {
+		t.Fatalf("queried statement = %s", got)
+	}
+
+	// a settled period is closed, usage goes into the next one
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-01", "b2", batch(usage(addr3, "Twitter", 1)))
+	if got := getUsage(t, stub, "2018-01", addr3); got != "Google Maps:7:0,Twitter:10:20INK,YouTube:5:15CCT" {
+		t.Fatalf("usage of a settled period = %s", got)
+	}
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-02", "b3", batch(usage(addr3, "Twitter", 1)))
+	stub.SetTime(time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC))
+
+	// a consumer who can not pay settles nothing
+	stub.SetBalance(addr3, tokenType, 1)
+	mustFail(t, stub, addr3, SettleUsage, "2018-02")
+	mustFail(t, stub, addr1, QueryStatement, "2018-02", addr3)
+
+	// settling without usage gives an empty statement
+	payload = mustInvoke(t, stub, addr1, SettleUsage, "2018-02")
+	if err := json.Unmarshal(payload, &statement); err != nil || len(statement.Lines) != 0 {
+		t.Fatalf("empty statement = %s", payload)
+	}
+}
+
+func TestSettleEarly(t *testing.T) {
+	stub := newMeteredStub(t)
+	stub.SetTime(time.Date(2018, 1, 20, 0, 0, 0, 0, time.UTC))
+
+	// the current and future periods, and the grace after a period, can not be settled
+	mustFail(t, stub, addr3, SettleUsage, "2018-01")
+	mustFail(t, stub, addr3, SettleUsage, "2018-03")
+	stub.SetTime(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC).Add(SettlementGrace - time.Second))
+	mustFail(t, stub, addr3, SettleUsage, "2018-01")
+	mustFail(t, stub, addr1, QueryStatement, "2018-01", addr3)
+
+	// a late batch is recorded in full
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-01", "b1", batch(usage(addr3, "Twitter", 10), usage(addr1, "Twitter", 1)))
+	stub.Advance(time.Second)
+	mustInvoke(t, stub, addr1, SettleUsage, "2018-01")
+
+	// once settled, the consumer's entries are returned apart and the others recorded
+	var result usageBatchResult
+	payload := mustInvoke(t, stub, gateway, RecordUsage, "2018-01", "b2", batch(usage(addr3, "Twitter", 5), usage(addr1, "Twitter", 4)))
+	if err := json.Unmarshal(payload, &result); err != nil || result.Recorded != 1 ||
+		len(result.Settled) != 1 || result.Settled[0] != (usageEntry{addr1, "Twitter", 4}) {
+		t.Fatalf("result = %s", payload)
+	}
+	if got := getUsage(t, stub, "2018-01", addr3); got != "Twitter:15:30INK" {
+		t.Fatalf("usage of addr3 = %s", got)
+	}
+	if got := getUsage(t, stub, "2018-01", addr1); got != "Twitter:1:2INK" {
+		t.Fatalf("usage of addr1 = %s", got)
+	}
+	mustInvoke(t, stub, addr3, SettleUsage, "2018-01")
+	if b := balance(stub, addr3); b != "970" {
+		t.Fatalf("balance = %s", b)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Ratings and reviews
+// ==================================================================================
+//
+// Registered users rate services with 1 to 5 stars and an optional short
+// review, one rating per user and service; rating again edits it. The
+// service's "Rating" summary is updated with every rating instead of being
+// recomputed. Developers and maintainers can not rate their own services,
+// and the ratings of an invalid or suspended service are frozen until it is
+// available again. Suspended users can not rate.
+
+// Rating limits
+const (
+	MinStars        = 1
+	MaxStars        = 5
+	MaxReviewLength = 280 // characters
+)
+
+// Structure definition for the rating summary of a service
+type ratingSummary struct {
+	Count        int           `json:"count"`
+	Sum          int           `json:"sum"`
+	Mean         float64       `json:"mean"`
+	Distribution [MaxStars]int `json:"distribution"` // number of ratings with 1..5 stars
+}
+
+// Structure definition for a user's rating of a service
+type rating struct {
+	Service     string `json:"service"`
+	User        string `json:"user"`
+	Stars       int    `json:"stars"`
+	Review      string `json:"review,omitempty"`
+	CreatedTime string `json:"createdTime"`
+	UpdatedTime string `json:"updatedTime,omitempty"`
+}
+
+// Structure definition for a page of reviews
+type reviewPage struct {
+	Reviews []rating `json:"reviews"`
+	// Bookmark is the first entry of the next page, "" on the last page.
+	Bookmark string `json:"bookmark"`
+}
+
+// add counts a rating of stars, or removes it with sign -1.
+func (r *ratingSummary) add(stars int, sign int) {
+	r.Count += sign
+	r.Sum += sign * stars
+	r.Distribution[stars-1] += sign
+	if r.Count > 0 {
+		r.Mean = float64(r.Sum) / float64(r.Count)
+	} else {
+		r.Mean = 0
+	}
+}
+
+// ===============================================================
+// rateService: rate a service, or edit the sender's rating
+// ===============================================================
+func (t *serviceChaincode) rateService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var review string
+	var err error
+
+	service_name = args[0]
+	stars, err := strconv.Atoi(args[1])
+	if err != nil || stars < MinStars || stars > MaxStars {
+		return shim.Error("Expecting 1 to 5 stars.")
+	}
+	if len(args) > 2 {
+		review = args[2]
+	}
+	if utf8.RuneCountInString(review) > MaxReviewLength {
+		return shim.Error("The review can not be longer than 280 characters.")
+	}
+
+	// STEP 0: the rater must be a registered user
+	senderAdd, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	user_name, err := userNameByAddress(stub, senderAdd)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if user_name == "" {
+		return shim.Error("Only registered users can rate services.")
+	}
+	err = checkActiveUser(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: check the service can be rated by this user
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	member, err := isMember(stub, serviceJSON, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if member {
+		return shim.Error("Developers can not rate their own services.")
+	}
+	if serviceJSON.Status == S_Invalid || serviceJSON.Status == S_Suspended {
+		return shim.Error("Ratings of " + serviceJSON.Status + " services are frozen: " + service_name)
+	}
+
+	// STEP 2: add the rating, or replace the user's previous one
+	rating_key, err := stub.CreateCompositeKey(RatingIndex, []string{service_name, user_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ratingAsBytes, err := stub.GetState(rating_key)
+	if err != nil {
+		return shim.Error("Fail to get rating: " + err.Error())
+	}
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if serviceJSON.Rating == nil {
+		serviceJSON.Rating = &ratingSummary{}
+	}
+
+	ratingJSON := &rating{Service: service_name, User: user_name}
+	previous_stars := 0
+	if ratingAsBytes == nil {
+		ratingJSON.CreatedTime = txTime.Format(time.UnixDate)
+	} else {
+		err = json.Unmarshal(ratingAsBytes, ratingJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal rating bytes.")
+		}
+		serviceJSON.Rating.add(ratingJSON.Stars, -1)
+		previous_stars = ratingJSON.Stars
+		ratingJSON.UpdatedTime = txTime.Format(time.UnixDate)
+	}
+	ratingJSON.Stars = stars
+	ratingJSON.Review = review
+	serviceJSON.Rating.add(stars, 1)
+
+	// STEP 3: store the rating and the service's summary
+	ratingAsBytes, err = json.Marshal(ratingJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(rating_key, ratingAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(ServicePrefix+service_name, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, RateService, []string{ServicePrefix + service_name, UserPrefix + user_name}, serviceJSON.Status, serviceJSON.Status,
+		events.ServiceRated{Service: service_name, User: user_name, Stars: stars, PreviousStars: previous_stars})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(ratingAsBytes)
+}
+
+// ===============================================================
+// queryReviews: query the ratings of a service by user name
+// The ratings are returned one page at a time, pass the returned
+// bookmark to get the next page.
+// ===============================================================
+func (t *serviceChaincode) queryReviews(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var bookmark string
+	var err error
+
+	service_name = args[0]
+	page_size := DefaultPageSize
+	if len(args) > 1 {
+		page_size, err = parsePageSize(args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+
+	if _, err = readService(stub, service_name); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: walk the service's ratings from the bookmark on
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(RatingIndex, []string{service_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page := reviewPage{Reviews: []rating{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var ratingJSON rating
+		err = json.Unmarshal(queryResponse.Value, &ratingJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal rating bytes.")
+		}
+		if ratingJSON.User < bookmark {
+			continue
+		}
+
+		// STEP 1: stop at the first rating beyond the page, it starts the next one
+		if len(page.Reviews) == page_size {
+			page.Bookmark = ratingJSON.User
+			break
+		}
+		page.Reviews = append(page.Reviews, ratingJSON)
+	}
+
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
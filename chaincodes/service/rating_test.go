@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+func getReviews(t *testing.T, stub *shimtest.Stub, args ...string) reviewPage {
+	t.Helper()
+	var page reviewPage
+	payload := mustInvoke(t, stub, addr1, QueryReviews, args...)
+	if err := json.Unmarshal(payload, &page); err != nil {
+		t.Fatalf("unmarshal reviews %s: %v", payload, err)
+	}
+	return page
+}
+
+func TestRateService(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	mustInvoke(t, stub, addr1, RateService, "Twitter", "5", "Great API.")
+	mustInvoke(t, stub, addr3, RateService, "Twitter", "2")
+	r := getService(t, stub, "Twitter").Rating
+	if r == nil || r.Count != 2 || r.Sum != 7 || r.Mean != 3.5 || r.Distribution != [MaxStars]int{0, 1, 0, 0, 1} {
+		t.Fatalf("rating = %+v", r)
+	}
+
+	// rating again edits the user's rating
+	mustInvoke(t, stub, addr3, RateService, "Twitter", "4", "Better now.")
+	r = getService(t, stub, "Twitter").Rating
+	if r.Count != 2 || r.Sum != 9 || r.Mean != 4.5 || r.Distribution != [MaxStars]int{0, 0, 0, 1, 1} {
+		t.Fatalf("rating after edit = %+v", r)
+	}
+	page := getReviews(t, stub, "Twitter")
+	if len(page.Reviews) != 2 || page.Bookmark != "" {
+		t.Fatalf("reviews = %+v", page)
+	}
+	if rv := page.Reviews[1]; rv.User != "user3" || rv.Stars != 4 || rv.Review != "Better now." || rv.UpdatedTime == "" {
+		t.Fatalf("edited review = %+v", rv)
+	}
+
+	// no self-rating, also by maintainers, no unregistered raters
+	mustFail(t, stub, addr2, RateService, "Twitter", "5")
+	mustFail(t, stub, gateway, RateService, "Twitter", "5")
+	mustInvoke(t, stub, addr2, AddMaintainer, "YouTube", "user3")
+	mustFail(t, stub, addr3, RateService, "YouTube", "5")
+	mustInvoke(t, stub, addr3, RemoveMaintainer, "YouTube", "user3")
+	mustInvoke(t, stub, addr3, RateService, "YouTube", "5")
+
+	mustFail(t, stub, addr1, RateService, "Twitter", "0")
+	mustFail(t, stub, addr1, RateService, "Twitter", "6")
+	mustFail(t, stub, addr1, RateService, "Twitter", "five")
+	mustFail(t, stub, addr1, RateService, "Twitter", "5", strings.Repeat("a", MaxReviewLength+1))
+	mustFail(t, stub, addr1, RateService, "Flickr", "5")
+}
+
+func TestRatingsOfInvalidServicesAreFrozen(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr1, RateService, "YouTube", "3")
+
+	mustInvoke(t, stub, addr2, InvalidateService, "YouTube", "Terms of use changed.")
+	mustFail(t, stub, addr1, RateService, "YouTube", "1")
+	mustFail(t, stub, addr3, RateService, "YouTube", "1")
+	if r := getService(t, stub, "YouTube").Rating; r.Count != 1 || r.Sum != 3 {
+		t.Fatalf("frozen rating = %+v", r)
+	}
+
+	mustInvoke(t, stub, addr2, PublishService, "YouTube")
+	mustInvoke(t, stub, addr1, RateService, "YouTube", "1")
+}
+
+func TestQueryReviewsPages(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr1, RateService, "YouTube", "3")
+	mustInvoke(t, stub, addr3, RateService, "YouTube", "5")
+	mustInvoke(t, stub, addr4, RegisterUser, "user4", "A reviewer.")
+	mustInvoke(t, stub, addr4, RateService, "YouTube", "4")
+
+	page := getReviews(t, stub, "YouTube", "2")
+	if len(page.Reviews) != 2 || page.Reviews[0].User != "user1" || page.Bookmark != "user4" {
+		t.Fatalf("first page = %+v", page)
+	}
+	page = getReviews(t, stub, "YouTube", "2", page.Bookmark)
+	if len(page.Reviews) != 1 || page.Reviews[0].User != "user4" || page.Bookmark != "" {
+		t.Fatalf("second page = %+v", page)
+	}
+	if page := getReviews(t, stub, "Google Maps"); len(page.Reviews) != 0 {
+		t.Fatalf("reviews of an unrated service = %+v", page)
+	}
+
+	mustFail(t, stub, addr1, QueryReviews, "Flickr")
+	mustFail(t, stub, addr1, QueryReviews, "YouTube", "0")
+}
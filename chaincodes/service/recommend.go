@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Companion-service recommendation
+// ==================================================================================
+//
+// Given some services, the candidates are the services found in their
+// co-occurrence documents. Each candidate is scored against every given
+// service and the scores are summed:
+//
+//	jaccard: co / (mashups(s) + mashups(c) - co), the share of the mashups
+//	         using either service that use both
+//	count:   co / sum of s's co-occurrence counts, the share of s's
+//	         companions that are c
+//
+// where co is the number of mashups composing the given service s together
+// with the candidate c.
+
+// Similarity measures
+const (
+	SimilarityJaccard = "jaccard"
+	SimilarityCount   = "count"
+)
+
+// Default number of services returned by recommendServices
+const DefaultRecommendations = 10
+
+// Structure definition for one recommended service
+type recommendation struct {
+	Service string  `json:"service"`
+	Type    string  `json:"type"`
+	Score   float64 `json:"score"`
+}
+
+// similarity scores candidate c against the given service s.
+func similarity(measure string, s *service, c *service, co int) float64 {
+	switch measure {
+	case SimilarityCount:
+		total := 0
+		for _, count := range s.Composition {
+			total += count
+		}
+		return float64(co) / float64(total)
+	default:
+		// counts recorded before ComposedCount existed can be lower than co
+		used_s := s.ComposedCount
+		for _, count := range s.Composition {
+			if count > used_s {
+				used_s = count
+			}
+		}
+		used_c := c.ComposedCount
+		if co > used_c {
+			used_c = co
+		}
+		return float64(co) / float64(used_s+used_c-co)
+	}
+}
+
+// ==================================================================
+// recommendServices: recommend the services most often composed
+// together with the given ones
+// ==================================================================
+func (t *serviceChaincode) recommendServices(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var type_filter string
+	var measure string
+	var err error
+
+	top := DefaultRecommendations
+	if args[0] != "" {
+		top, err = strconv.Atoi(args[0])
+		if err != nil || top <= 0 {
+			return shim.Error("Expecting positive integer value for the number of services.")
+		}
+		if top > MaxPageSize {
+			top = MaxPageSize
+		}
+	}
+	type_filter = args[1]
+	measure = args[2]
+	switch measure {
+	case "":
+		measure = SimilarityJaccard
+	case SimilarityJaccard, SimilarityCount:
+	default:
+		return shim.Error("Unknown similarity measure: " + measure)
+	}
+
+	// STEP 0: load the given services
+	given := make(map[string]*service)
+	given_names := []string{}
+	for _, name := range args[3:] {
+		if _, ok := given[name]; ok {
+			continue
+		}
+		serviceJSON, err := getPlainService(stub, name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		given[name] = serviceJSON
+		given_names = append(given_names, name)
+	}
+	// floating-point sums depend on their order, every endorser adds the
+	// similarities in the order of the given names
+	sort.Strings(given_names)
+
+	// STEP 1: score the candidates found in their co-occurrence documents
+	candidates := make(map[string]*service)
+	scores := make(map[string]float64)
+	for _, given_name := range given_names {
+		s := given[given_name]
+		for name, co := range s.Composition {
+			if _, ok := given[name]; ok || co <= 0 {
+				continue
+			}
+			c, ok := candidates[name]
+			if !ok {
+				serviceAsBytes, err := stub.GetState(ServicePrefix + name)
+				if err != nil {
+					return shim.Error("Fail to get service: " + err.Error())
+				} else if serviceAsBytes == nil {
+					continue
+				}
+				c = &service{}
+				err = json.Unmarshal(serviceAsBytes, c)
+				if err != nil {
+					return shim.Error("Error unmarshal service bytes.")
+				}
+				candidates[name] = c
+			}
+			scores[name] += similarity(measure, s, c, co)
+		}
+	}
+
+	// STEP 2: drop the services that can not be used, rank the others
+	result := []recommendation{}
+	for name, score := range scores {
+		c := candidates[name]
+		if c.Status == S_Invalid || c.Status == S_Deprecated || c.Status == S_Suspended {
+			continue
+		}
+		if type_filter != "" && c.Type != type_filter {
+			continue
+		}
+		result = append(result, recommendation{name, c.Type, score})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Service < result[j].Service
+	})
+	if len(result) > top {
+		result = result[:top]
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
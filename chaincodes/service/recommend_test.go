@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+func getRecommendations(t *testing.T, stub *shimtest.Stub, args ...string) []recommendation {
+	t.Helper()
+	var result []recommendation
+	payload := mustInvoke(t, stub, addr1, RecommendServices, args...)
+	if err := json.Unmarshal(payload, &result); err != nil {
+		t.Fatalf("unmarshal recommendations %s: %v", payload, err)
+	}
+	return result
+}
+
+func recommendedNames(result []recommendation) string {
+	names := make([]string, len(result))
+	for i, r := range result {
+		names[i] = r.Service
+	}
+	return strings.Join(names, ",")
+}
+
+func TestRecommendServices(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, RegisterService, "Flickr", "Photos", "Photos API.", "user2")
+	mustInvoke(t, stub, addr3, CreateMashup, "M1", "Mapping", "Mashup 1.", "Google Maps", "Twitter")
+	mustInvoke(t, stub, addr3, CreateMashup, "M2", "Mapping", "Mashup 2.", "Google Maps", "Twitter", "YouTube")
+	mustInvoke(t, stub, addr3, CreateMashup, "M3", "Mapping", "Mashup 3.", "Google Maps", "Flickr")
+	mustInvoke(t, stub, addr3, CreateMashup, "M4", "Social", "Mashup 4.", "Twitter", "YouTube")
+
+	if s := getService(t, stub, "Google Maps"); s.ComposedCount != 3 {
+		t.Fatalf("Google Maps composed count = %d, want 3", s.ComposedCount)
+	}
+
+	// Jaccard: Twitter 2/4, Flickr 1/3, YouTube 1/4
+	result := getRecommendations(t, stub, "", "", "", "Google Maps")
+	if got := recommendedNames(result); got != "Twitter,Flickr,YouTube" {
+		t.Fatalf("jaccard recommendations = %s", got)
+	}
+	if result[0].Score != 0.5 || result[0].Type != "Social" {
+		t.Fatalf("unexpected top recommendation: %+v", result[0])
+	}
+	// normalized count: Twitter 2/4, Flickr 1/4, YouTube 1/4
+	result = getRecommendations(t, stub, "", "", SimilarityCount, "Google Maps")
+	if got := recommendedNames(result); got != "Twitter,Flickr,YouTube" || result[2].Score != 0.25 {
+		t.Fatalf("count recommendations = %+v", result)
+	}
+
+	if got := recommendedNames(getRecommendations(t, stub, "1", "", "", "Google Maps")); got != "Twitter" {
+		t.Fatalf("top 1 = %s", got)
+	}
+	if got := recommendedNames(getRecommendations(t, stub, "", "Video", "", "Google Maps")); got != "YouTube" {
+		t.Fatalf("Video recommendations = %s", got)
+	}
+
+	// scores add up over the given services, which are never recommended
+	if got := recommendedNames(getRecommendations(t, stub, "", "", "", "Google Maps", "Twitter")); got != "YouTube,Flickr" {
+		t.Fatalf("recommendations for Google Maps and Twitter = %s", got)
+	}
+	// in the same order whatever the order of the given services
+	forward := mustInvoke(t, stub, addr1, RecommendServices, "", "", "", "Google Maps", "Twitter", "YouTube")
+	backward := mustInvoke(t, stub, addr1, RecommendServices, "", "", "", "YouTube", "Twitter", "Google Maps", "Twitter")
+	if string(forward) != string(backward) {
+		t.Fatalf("recommendations depend on the order: %s, %s", forward, backward)
+	}
+
+	// invalid services are not recommended
+	mustInvoke(t, stub, addr2, InvalidateService, "Twitter", "Shut down.")
+	if got := recommendedNames(getRecommendations(t, stub, "", "", "", "Google Maps")); got != "Flickr,YouTube" {
+		t.Fatalf("recommendations without Twitter = %s", got)
+	}
+
+	mustFail(t, stub, addr1, RecommendServices, "", "", "cosine", "Google Maps")
+	mustFail(t, stub, addr1, RecommendServices, "none", "", "", "Google Maps")
+	mustFail(t, stub, addr1, RecommendServices, "", "", "", "Bing Maps")
+	mustFail(t, stub, addr1, RecommendServices, "", "", "", "M1")
+	mustFail(t, stub, addr1, RecommendServices, "", "", "")
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Reward history
+// ==================================================================================
+//
+// Every reward is stored under its transaction id and indexed by service,
+// developer and rewarder, each ordered by transaction time, so developers
+// can follow their tip income and users the rewards they gave, from
+// every address they used.
+
+// Longest memo of a reward, in bytes
+const MaxMemoLength = 140
+
+// Structure definition for a reward
+type reward struct {
+	Service      string `json:"service"`
+	Developer    string `json:"developer"`
+	Rewarder     string `json:"rewarder"`               // address of the rewarder
+	RewarderName string `json:"rewarderName,omitempty"` // if the rewarder is a registered user
+	TokenType    string `json:"tokenType"`
+	Amount       string `json:"amount"`
+	Memo         string `json:"memo,omitempty"`
+	TxID         string `json:"txId"`
+	Time         string `json:"time"`
+
+	// Split lists the members' parts of a reward to a service kept by
+	// several, see maintainers.go.
+	Split []sharePayment `json:"split,omitempty"`
+}
+
+// Structure definition for the response of the reward queries
+type rewardHistory struct {
+	Rewards []reward          `json:"rewards"`
+	Totals  map[string]string `json:"totals"` // token type -> amount
+}
+
+// recordReward stores a reward and its indexes.
+func recordReward(stub shim.ChaincodeStubInterface, rewardJSON *reward, txTime time.Time) error {
+	rewardAsBytes, err := json.Marshal(rewardJSON)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(RewardPrefix+rewardJSON.TxID, rewardAsBytes)
+	if err != nil {
+		return err
+	}
+
+	sortable_time := txTime.Format(sortableTimeLayout)
+	for index, owner := range map[string]string{
+		ServiceRewardIndex:   rewardJSON.Service,
+		DeveloperRewardIndex: rewardJSON.Developer,
+		RewarderRewardIndex:  rewardJSON.Rewarder,
+	} {
+		index_key, err := stub.CreateCompositeKey(index, []string{owner, sortable_time, rewardJSON.TxID})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(index_key, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewardsBy lists the rewards of index entry owners, oldest first, with
+// their totals. The rewards keep rejects are left out, if keep is set.
+func rewardsBy(stub shim.ChaincodeStubInterface, index string, keep func(*reward) bool, owners ...string) (*rewardHistory, error) {
+	// the entries of several owners are merged by time
+	var entries [][]string // sortable time, tx id
+	for _, owner := range owners {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey(index, []string{owner})
+		if err != nil {
+			return nil, err
+		}
+		for resultsIterator.HasNext() {
+			indexResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			_, keyParts, err := stub.SplitCompositeKey(indexResponse.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			entries = append(entries, keyParts[1:])
+		}
+		resultsIterator.Close()
+	}
+	if len(owners) > 1 {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i][0] < entries[j][0]
+		})
+	}
+
+	history := &rewardHistory{Rewards: []reward{}, Totals: make(map[string]string)}
+	for _, entry := range entries {
+		rewardAsBytes, err := stub.GetState(RewardPrefix + entry[1])
+		if err != nil {
+			return nil, errors.New("Fail to get reward: " + err.Error())
+		} else if rewardAsBytes == nil {
+			continue
+		}
+		var rewardJSON reward
+		err = json.Unmarshal(rewardAsBytes, &rewardJSON)
+		if err != nil {
+			return nil, errors.New("Error unmarshal reward bytes.")
+		}
+		if keep != nil && !keep(&rewardJSON) {
+			continue
+		}
+		history.Rewards = append(history.Rewards, rewardJSON)
+		if total, ok := history.Totals[rewardJSON.TokenType]; ok {
+			history.Totals[rewardJSON.TokenType] = addAmount(total, rewardJSON.Amount)
+		} else {
+			history.Totals[rewardJSON.TokenType] = rewardJSON.Amount
+		}
+	}
+	return history, nil
+}
+
+func rewardHistoryResponse(history *rewardHistory, err error) pb.Response {
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	historyAsBytes, err := json.Marshal(history)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(historyAsBytes)
+}
+
+// ===============================================================
+// queryServiceRewards: query the rewards a service received
+// ===============================================================
+func (t *serviceChaincode) queryServiceRewards(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if _, err := readService(stub, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	return rewardHistoryResponse(rewardsBy(stub, ServiceRewardIndex, nil, args[0]))
+}
+
+// ===============================================================
+// queryDeveloperRewards: query the rewards a developer received
+// ===============================================================
+func (t *serviceChaincode) queryDeveloperRewards(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return rewardHistoryResponse(rewardsBy(stub, DeveloperRewardIndex, nil, args[0]))
+}
+
+// ===============================================================
+// queryRewardsGiven: query the rewards given by a user, from its
+// current and retired addresses, or by an address that is no user's name
+// ===============================================================
+func (t *serviceChaincode) queryRewardsGiven(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	rewarder := args[0]
+	userAsBytes, err := stub.GetState(UserPrefix + rewarder)
+	if err != nil {
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return rewardHistoryResponse(rewardsBy(stub, RewarderRewardIndex, nil, normalizeAddress(rewarder)))
+	}
+	var userJSON user
+	err = json.Unmarshal(userAsBytes, &userJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal user bytes.")
+	}
+	addresses, err := retiredAddresses(stub, userJSON.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// an address used by several users gives the rewards of each to its own
+	given := func(rewardJSON *reward) bool {
+		return rewardJSON.RewarderName == "" || rewardJSON.RewarderName == userJSON.Name
+	}
+	return rewardHistoryResponse(rewardsBy(stub, RewarderRewardIndex, given, append(addresses, userJSON.Address)...))
+}
+
+// parseRewardAmount reads the positive amount of a reward.
+func parseRewardAmount(arg string) (*big.Int, error) {
+	reward_amount, good := big.NewInt(0).SetString(arg, 10)
+	if !good || reward_amount.Sign() <= 0 {
+		return nil, errors.New("Expecting positive integer value for amount")
+	}
+	return reward_amount, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+func getRewards(t *testing.T, stub *shimtest.Stub, function string, owner string) rewardHistory {
+	t.Helper()
+	var history rewardHistory
+	payload := mustInvoke(t, stub, addr1, function, owner)
+	if err := json.Unmarshal(payload, &history); err != nil {
+		t.Fatalf("unmarshal rewards %s: %v", payload, err)
+	}
+	return history
+}
+
+func rewardAmounts(history rewardHistory) string {
+	rows := make([]string, len(history.Rewards))
+	for i, r := range history.Rewards {
+		rows[i] = r.Service + ":" + r.Amount + r.TokenType
+	}
+	return strings.Join(rows, ",")
+}
+
+func TestRewardHistory(t *testing.T) {
+	stub := newEcosystemStub(t)
+	stub.SetBalance(addr1, "CCT", 100)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	mustInvoke(t, stub, addr2, PublishService, "YouTube")
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+
+	mustInvoke(t, stub, addr3, RewardService, "Twitter", tokenType, "5", "Thanks for the stream API.")
+	stub.Advance(time.Hour)
+	mustInvoke(t, stub, addr1, RewardService, "YouTube", "CCT", "7")
+	stub.Advance(time.Hour)
+	mustInvoke(t, stub, addr3, RewardService, "Twitter", tokenType, "3")
+	stub.Advance(time.Hour)
+	// addr4 has no tokens, a failed transfer records nothing
+	mustFail(t, stub, addr4, RewardService, "Google Maps", tokenType, "1")
+
+	history := getRewards(t, stub, QueryServiceRewards, "Twitter")
+	if got := rewardAmounts(history); got != "Twitter:5INK,Twitter:3INK" || history.Totals[tokenType] != "8" {
+		t.Fatalf("Twitter rewards = %s, totals %v", got, history.Totals)
+	}
+	r := history.Rewards[0]
+	if r.Developer != "user2" || r.Rewarder != addr3 || r.RewarderName != "user3" ||
+		r.Memo != "Thanks for the stream API." || r.TxID == "" || r.Time == "" {
+		t.Fatalf("reward = %+v", r)
+	}
+
+	history = getRewards(t, stub, QueryDeveloperRewards, "user2")
+	if got := rewardAmounts(history); got != "Twitter:5INK,YouTube:7CCT,Twitter:3INK" {
+		t.Fatalf("user2 rewards = %s", got)
+	}
+	if history.Totals[tokenType] != "8" || history.Totals["CCT"] != "7" {
+		t.Fatalf("user2 totals = %v", history.Totals)
+	}
+
+	// given rewards are found by user name or address
+	if got := rewardAmounts(getRewards(t, stub, QueryRewardsGiven, "user3")); got != "Twitter:5INK,Twitter:3INK" {
+		t.Fatalf("rewards given by user3 = %s", got)
+	}
+	if got := rewardAmounts(getRewards(t, stub, QueryRewardsGiven, addr1)); got != "YouTube:7CCT" {
+		t.Fatalf("rewards given by addr1 = %s", got)
+	}
+	if got := rewardAmounts(getRewards(t, stub, QueryDeveloperRewards, "user1")); got != "" {
+		t.Fatalf("user1 rewards = %s", got)
+	}
+
+	mustFail(t, stub, addr1, QueryServiceRewards, "Flickr")
+	mustFail(t, stub, addr3, RewardService, "Twitter", tokenType, "1", strings.Repeat("m", MaxMemoLength+1))
+}
+
+func TestSelfReward(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	mustInvoke(t, stub, addr2, AddMaintainer, "Twitter", "user3")
+
+	// neither the developer nor a maintainer rewards the service
+	mustFail(t, stub, addr2, RewardService, "Twitter", tokenType, "5")
+	mustFail(t, stub, addr3, RewardService, "Twitter", tokenType, "5")
+	if u2, u3 := getContribution(t, stub, "user2"), getContribution(t, stub, "user3"); u2.Activities[ActivityReward].Count != 0 || u3.Contribution != 0 {
+		t.Fatalf("contributions = %+v, %+v", u2, u3)
+	}
+	if got := rewardAmounts(getRewards(t, stub, QueryServiceRewards, "Twitter")); got != "" {
+		t.Fatalf("Twitter rewards = %s", got)
+	}
+
+	// a former maintainer and other users do
+	mustInvoke(t, stub, addr3, RemoveMaintainer, "Twitter", "user3")
+	mustInvoke(t, stub, addr3, RewardService, "Twitter", tokenType, "5")
+	mustInvoke(t, stub, addr1, RewardService, "Twitter", tokenType, "5")
+	if u2 := getContribution(t, stub, "user2"); u2.Activities[ActivityReward].Count != 2 {
+		t.Fatalf("user2 contribution = %+v", u2)
+	}
+}
+
+func TestRewardsGivenAfterRotation(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	stub.SetBalance(addr4, tokenType, 1000)
+
+	// a user's rewards follow it to its new address
+	mustInvoke(t, stub, addr3, RewardService, "Twitter", tokenType, "5")
+	stub.Advance(time.Hour)
+	mustInvoke(t, stub, addr3, RotateAddress, "user3", addr4)
+	mustInvoke(t, stub, addr4, ConfirmAddress, "user3")
+	mustInvoke(t, stub, addr4, RewardService, "Google Maps", tokenType, "4")
+	if got := rewardAmounts(getRewards(t, stub, QueryRewardsGiven, "user3")); got != "Twitter:5INK,Google Maps:4INK" {
+		t.Fatalf("rewards given by user3 = %s", got)
+	}
+
+	// the retired address gives the rewards of its next user to that user
+	stub.Advance(time.Hour)
+	mustInvoke(t, stub, addr3, RegisterUser, "user5", "intro")
+	mustInvoke(t, stub, addr3, RewardService, "Twitter", tokenType, "2")
+	history := getRewards(t, stub, QueryRewardsGiven, "user3")
+	if got := rewardAmounts(history); got != "Twitter:5INK,Google Maps:4INK" || history.Totals[tokenType] != "9" {
+		t.Fatalf("rewards given by user3 = %s, totals %v", got, history.Totals)
+	}
+	if got := rewardAmounts(getRewards(t, stub, QueryRewardsGiven, "user5")); got != "Twitter:2INK" {
+		t.Fatalf("rewards given by user5 = %s", got)
+	}
+	if got := rewardAmounts(getRewards(t, stub, QueryRewardsGiven, addr3)); got != "Twitter:5INK,Twitter:2INK" {
+		t.Fatalf("rewards given by addr3 = %s", got)
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Roles and moderation
+// ==================================================================================
+//
+// Admins manage the roles and the chaincode's configuration. Moderators take
+// down spam or malicious content: they invalidate or suspend any service and
+// suspend users, always with a reason. Admins hold the moderator role too.
+//
+// Init makes the addresses passed to it the first admins, or the instantiator
+// when none are passed. On upgrade the roles already on the ledger are kept.
+//
+// A suspended service leaves the lifecycle until a moderator reinstates it to
+// the status it had; its developer can not publish it meanwhile. A suspended
+// user can not register, compose, rate or change services until reinstated.
+
+// Roles
+const (
+	R_Admin     = "admin"
+	R_Moderator = "moderator"
+)
+
+// Structure definition for the suspension of a user
+type suspension struct {
+	Reason    string `json:"reason"`
+	Moderator string `json:"moderator"` // address of the moderator
+	Time      string `json:"time"`
+}
+
+func isValidRole(role string) bool {
+	return role == R_Admin || role == R_Moderator
+}
+
+// hasRole tells whether address holds role.
+func hasRole(stub shim.ChaincodeStubInterface, role string, address string) (bool, error) {
+	role_key, err := stub.CreateCompositeKey(RoleIndex, []string{role, address})
+	if err != nil {
+		return false, err
+	}
+	roleAsBytes, err := stub.GetState(role_key)
+	if err != nil {
+		return false, errors.New("Fail to get the role: " + err.Error())
+	}
+	return roleAsBytes != nil, nil
+}
+
+// setRole grants role to address, or revokes it.
+func setRole(stub shim.ChaincodeStubInterface, role string, address string, granted bool) error {
+	role_key, err := stub.CreateCompositeKey(RoleIndex, []string{role, address})
+	if err != nil {
+		return err
+	}
+	if granted {
+		return stub.PutState(role_key, []byte{0x00})
+	}
+	return stub.DelState(role_key)
+}
+
+// roleHolders lists the addresses holding role.
+func roleHolders(stub shim.ChaincodeStubInterface, role string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(RoleIndex, []string{role})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	addresses := []string{}
+	for resultsIterator.HasNext() {
+		indexResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, keyParts[1])
+	}
+	return addresses, nil
+}
+
+// initAdmins grants the admin role to the addresses passed to Init, or to
+// the admin of an earlier chaincode version, or to the instantiator, unless
+// there are admins already.
+func initAdmins(stub shim.ChaincodeStubInterface) error {
+	admins, err := roleHolders(stub, R_Admin)
+	if err != nil {
+		return err
+	}
+	if len(admins) > 0 {
+		return nil
+	}
+
+	_, args := stub.GetFunctionAndParameters()
+	for _, arg := range args {
+		if address := strings.TrimSpace(arg); address != "" {
+			admins = append(admins, address)
+		}
+	}
+	if len(admins) == 0 {
+		legacyAsBytes, err := stub.GetState(AdminKey)
+		if err != nil {
+			return errors.New("Fail to get the admin: " + err.Error())
+		}
+		if legacyAsBytes != nil {
+			admins = append(admins, string(legacyAsBytes))
+		} else {
+			instantiator, err := senderAddress(stub)
+			if err != nil {
+				return errors.New("Fail to get the sender's address.")
+			}
+			admins = append(admins, instantiator)
+		}
+	}
+	for _, address := range admins {
+		err = setRole(stub, R_Admin, normalizeAddress(address), true)
+		if err != nil {
+			return err
+		}
+	}
+	return stub.DelState(AdminKey)
+}
+
+// authorize makes sure the invoker may go on: as the developer of
+// serviceJSON when it is not nil, or as a holder of one of roles. Admins hold
+// every role. A removed or suspended developer is refused. It returns the
+// invoker's address.
+func authorize(stub shim.ChaincodeStubInterface, serviceJSON *service, roles ...string) (string, error) {
+	senderAdd, err := senderAddress(stub)
+	if err != nil {
+		return "", errors.New("Fail to get the sender's address.")
+	}
+
+	// STEP 0: the roles
+	if len(roles) > 0 {
+		for _, role := range append(roles, R_Admin) {
+			ok, err := hasRole(stub, role, senderAdd)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				return senderAdd, nil
+			}
+		}
+	}
+
+	// STEP 1: the service's developer
+	allowed := roles
+	if serviceJSON != nil {
+		DevJSON, err := lookupDeveloper(stub, serviceJSON.Developer)
+		if err != nil {
+			return "", err
+		}
+		if senderAdd == DevJSON.Address {
+			if DevJSON.Removed {
+				return "", errors.New("This user was removed: " + DevJSON.Name)
+			}
+			if DevJSON.Suspension != nil {
+				return "", errors.New("This user is suspended: " + DevJSON.Name)
+			}
+			return senderAdd, nil
+		}
+		allowed = append([]string{"the service's developer"}, roles...)
+	}
+	return "", errors.New("Authority err! Not invoked by " + strings.Join(allowed, " or ") + ".")
+}
+
+// checkActiveUser fails for a removed or suspended user.
+func checkActiveUser(stub shim.ChaincodeStubInterface, user_name string) error {
+	userJSON, err := readUser(stub, user_name)
+	if err != nil {
+		return err
+	}
+	if userJSON.Removed {
+		return errors.New("This user was removed: " + user_name)
+	}
+	if userJSON.Suspension != nil {
+		return errors.New("This user is suspended: " + user_name)
+	}
+	return nil
+}
+
+// ===============================================================
+// grantRole: grant a role to an address, admin only
+// ===============================================================
+func (t *serviceChaincode) grantRole(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.changeRole(stub, args[0], args[1], true)
+}
+
+// ===============================================================
+// revokeRole: revoke a role from an address, admin only
+// The last admin can not be revoked.
+// ===============================================================
+func (t *serviceChaincode) revokeRole(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.changeRole(stub, args[0], args[1], false)
+}
+
+func (t *serviceChaincode) changeRole(stub shim.ChaincodeStubInterface, role string, address string, granted bool) pb.Response {
+	address = normalizeAddress(address)
+
+	// STEP 0: only admins manage the roles
+	_, err := authorize(stub, nil, R_Admin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isValidRole(role) {
+		return shim.Error("Unknown role: " + role)
+	}
+	if address == "" {
+		return shim.Error("The address can not be empty.")
+	}
+
+	// STEP 1: check the role changes
+	held, err := hasRole(stub, role, address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if held == granted {
+		if granted {
+			return shim.Error("The address already holds the role: " + role)
+		}
+		return shim.Error("The address does not hold the role: " + role)
+	}
+	if !granted && role == R_Admin {
+		admins, err := roleHolders(stub, R_Admin)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(admins) == 1 {
+			return shim.Error("The last admin can not be revoked.")
+		}
+	}
+
+	// STEP 2: store it
+	err = setRole(stub, role, address, granted)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	role_key, err := stub.CreateCompositeKey(RoleIndex, []string{role, address})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	name := GrantRole
+	if !granted {
+		name = RevokeRole
+	}
+	err = emitEvent(stub, name, []string{role_key}, "", "", events.Role{Role: role, Address: address})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ===============================================================
+// queryRoles: query the addresses holding a role
+// ===============================================================
+func (t *serviceChaincode) queryRoles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if !isValidRole(args[0]) {
+		return shim.Error("Unknown role: " + args[0])
+	}
+	addresses, err := roleHolders(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	addressesAsBytes, err := json.Marshal(addresses)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(addressesAsBytes)
+}
+
+// ===============================================================
+// suspendService: take down a service, moderators only
+// ===============================================================
+func (t *serviceChaincode) suspendService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var reason string
+	var err error
+
+	service_name = args[0]
+	reason = args[1]
+
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: only moderators suspend services
+	_, err = authorize(stub, nil, R_Moderator)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: suspend the service, remembering its status
+	before := serviceJSON.Status
+	err = transitionService(stub, serviceJSON, S_Suspended, reason, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	serviceJSON.SuspendedFrom = before
+
+	return t.storeModeratedService(stub, serviceJSON, SuspendService, before, reason)
+}
+
+// ===============================================================
+// reinstateService: give a suspended service back the status it
+// had, moderators only
+// ===============================================================
+func (t *serviceChaincode) reinstateService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var reason string
+	var err error
+
+	service_name = args[0]
+	if len(args) > 1 {
+		reason = args[1]
+	}
+
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: only moderators reinstate services
+	_, err = authorize(stub, nil, R_Moderator)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if serviceJSON.Status != S_Suspended {
+		return shim.Error("This service is not suspended: " + service_name)
+	}
+
+	// STEP 1: the service leaves the suspension the way it entered it
+	before := serviceJSON.Status
+	serviceJSON.Status = serviceJSON.SuspendedFrom
+	serviceJSON.SuspendedFrom = ""
+	err = logTransition(stub, service_name, before, serviceJSON.Status, reason, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return t.storeModeratedService(stub, serviceJSON, ReinstateService, before, reason)
+}
+
+// storeModeratedService stores a suspended or reinstated service, updates
+// the health of the mashups built on it and emits the event.
+func (t *serviceChaincode) storeModeratedService(stub shim.ChaincodeStubInterface, serviceJSON *service, name string, before string, reason string) pb.Response {
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(ServicePrefix+serviceJSON.Name, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mashups, err := cascadeHealth(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, name, impactKeys(serviceJSON.Name, mashups), before, serviceJSON.Status,
+		events.ServiceModerated{Service: serviceJSON.Name, Developer: serviceJSON.Developer, Reason: reason, Mashups: mashups})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(serviceJSONasBytes)
+}
+
+// ===============================================================
+// suspendUser: suspend a user, moderators only
+// ===============================================================
+func (t *serviceChaincode) suspendUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if args[1] == "" {
+		return shim.Error("A reason is required to suspend a user.")
+	}
+	return t.moderateUser(stub, args[0], args[1], true)
+}
+
+// ===============================================================
+// reinstateUser: lift the suspension of a user, moderators only
+// ===============================================================
+func (t *serviceChaincode) reinstateUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var reason string
+	if len(args) > 1 {
+		reason = args[1]
+	}
+	return t.moderateUser(stub, args[0], reason, false)
+}
+
+func (t *serviceChaincode) moderateUser(stub shim.ChaincodeStubInterface, user_name string, reason string, suspended bool) pb.Response {
+	// STEP 0: only moderators suspend users
+	moderator, err := authorize(stub, nil, R_Moderator)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	user_key := UserPrefix + user_name
+	userAsBytes, err := stub.GetState(user_key)
+	if err != nil {
+		return shim.Error("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error("This user does not exist: " + user_name)
+	}
+	var userJSON user
+	err = json.Unmarshal(userAsBytes, &userJSON)
+	if err != nil {
+		return shim.Error("Error unmarshal user bytes.")
+	}
+	if userJSON.Removed {
+		return shim.Error("This user was removed: " + user_name)
+	}
+
+	// STEP 1: record or lift the suspension
+	name, before, after := SuspendUser, U_Registered, U_Suspended
+	if suspended {
+		if userJSON.Suspension != nil {
+			return shim.Error("This user is suspended already: " + user_name)
+		}
+		txTime, err := txTimestamp(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		userJSON.Suspension = &suspension{reason, moderator, txTime.Format(time.UnixDate)}
+	} else {
+		if userJSON.Suspension == nil {
+			return shim.Error("This user is not suspended: " + user_name)
+		}
+		userJSON.Suspension = nil
+		name, before, after = ReinstateUser, U_Suspended, U_Registered
+	}
+
+	userJSONasBytes, err := json.Marshal(userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(user_key, userJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, name, []string{user_key}, before, after,
+		events.UserModerated{Name: user_name, Address: userJSON.Address, Reason: reason})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(userJSONasBytes)
+}
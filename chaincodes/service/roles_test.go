@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+)
+
+func getRoles(t *testing.T, stub *shimtest.Stub, role string) string {
+	t.Helper()
+	var addresses []string
+	payload := mustInvoke(t, stub, addr1, QueryRoles, role)
+	if err := json.Unmarshal(payload, &addresses); err != nil {
+		t.Fatalf("unmarshal roles %s: %v", payload, err)
+	}
+	return strings.Join(addresses, ",")
+}
+
+func TestInitAdmins(t *testing.T) {
+	stub := shimtest.NewStub("service", new(serviceChaincode))
+	if res := stub.InitAs(addr1, "init", addr2, strings.ToUpper(addr3)); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	if got := getRoles(t, stub, R_Admin); got != addr3+","+addr2 {
+		t.Fatalf("admins = %s", got)
+	}
+
+	// an upgrade keeps the admins
+	stub.InitAs(addr4, "init", addr4)
+	if got := getRoles(t, stub, R_Admin); got != addr3+","+addr2 {
+		t.Fatalf("admins after upgrade = %s", got)
+	}
+
+	// the admin of an earlier version becomes the first admin
+	stub = shimtest.NewStub("service", new(serviceChaincode))
+	stub.State[AdminKey] = []byte(strings.ToUpper(addr4))
+	stub.InitAs(addr1)
+	if got := getRoles(t, stub, R_Admin); got != addr4 {
+		t.Fatalf("migrated admins = %s", got)
+	}
+	if _, ok := stub.State[AdminKey]; ok {
+		t.Fatal("the old admin record was kept")
+	}
+
+	// so does the instantiator, when no admin is given
+	stub = shimtest.NewStub("service", new(serviceChaincode))
+	stub.InitAs(strings.ToUpper(addr2))
+	if got := getRoles(t, stub, R_Admin); got != addr2 {
+		t.Fatalf("instantiating admin = %s", got)
+	}
+	mustInvoke(t, stub, addr2, GrantRole, R_Moderator, addr3)
+}
+
+func TestGrantAndRevokeRoles(t *testing.T) {
+	stub := newServiceStub(t)
+
+	mustInvoke(t, stub, addr1, GrantRole, R_Moderator, addr3)
+	if envelope, data := lastEvent(t, stub); !strings.HasPrefix(envelope, "grantRole "+addr1) ||
+		*data.(*events.Role) != (events.Role{Role: R_Moderator, Address: addr3}) {
+		t.Fatalf("event = %s %+v", envelope, data)
+	}
+	if got := getRoles(t, stub, R_Moderator); got != addr3 {
+		t.Fatalf("moderators = %s", got)
+	}
+	mustFail(t, stub, addr1, GrantRole, R_Moderator, addr3)
+	mustFail(t, stub, addr1, GrantRole, "owner", addr3)
+	mustFail(t, stub, addr1, GrantRole, R_Moderator, "")
+	// only admins manage the roles, moderators included
+	mustFail(t, stub, addr3, GrantRole, R_Moderator, addr4)
+	mustFail(t, stub, addr2, RevokeRole, R_Moderator, addr3)
+
+	// the last admin stays
+	mustFail(t, stub, addr1, RevokeRole, R_Admin, addr1)
+	mustInvoke(t, stub, addr1, GrantRole, R_Admin, addr2)
+	mustInvoke(t, stub, addr2, RevokeRole, R_Admin, addr1)
+	mustFail(t, stub, addr1, SetContributionWeights, `{"publish":1}`)
+	mustInvoke(t, stub, addr2, SetContributionWeights, `{"publish":1}`)
+
+	mustInvoke(t, stub, addr2, RevokeRole, R_Moderator, addr3)
+	mustFail(t, stub, addr2, RevokeRole, R_Moderator, addr3)
+	if got := getRoles(t, stub, R_Moderator); got != "" {
+		t.Fatalf("moderators = %s", got)
+	}
+}
+
+func TestModeratorsTakeDownServices(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr1, GrantRole, R_Moderator, addr4)
+	mustInvoke(t, stub, addr2, PublishService, "YouTube")
+	putMashupBy(t, stub, "user3", "MapVideos", "Google Maps", "YouTube")
+
+	// moderators invalidate any service, other users only their own
+	stub.Advance(time.Minute)
+	mustInvoke(t, stub, addr4, InvalidateService, "Twitter", "Spam.")
+	mustFail(t, stub, addr3, InvalidateService, "Google Maps", "Spam.")
+	if h := getHistory(t, stub, "Twitter"); h[len(h)-1].Actor != addr4 || h[len(h)-1].Reason != "Spam." {
+		t.Fatalf("history = %+v", h)
+	}
+
+	// a suspension takes a service out of the lifecycle
+	mustFail(t, stub, addr4, SuspendService, "YouTube", "")
+	mustFail(t, stub, addr2, SuspendService, "YouTube", "Malware.")
+	mustInvoke(t, stub, addr4, SuspendService, "YouTube", "Malware.")
+	if envelope, _ := lastEvent(t, stub); envelope != "suspendService "+addr4+" SER_YouTube,SER_MapVideos available>suspended" {
+		t.Fatalf("event = %s", envelope)
+	}
+	if s := getService(t, stub, "YouTube"); s.Status != S_Suspended || s.SuspendedFrom != S_Available {
+		t.Fatalf("suspended service = %+v", s)
+	}
+	if got := healthOf(t, stub, "MapVideos"); got != "degraded[YouTube]" {
+		t.Fatalf("mashup health = %s", got)
+	}
+	mustFail(t, stub, addr2, PublishService, "YouTube")
+	mustFail(t, stub, addr2, InvalidateService, "YouTube", "Fixed.")
+	mustFail(t, stub, addr1, RateService, "YouTube", "5")
+	mustFail(t, stub, addr1, RewardService, "YouTube", tokenType, "1")
+	mustFail(t, stub, addr4, SuspendService, "YouTube", "Twice.")
+
+	// reinstatement gives the service its status back
+	stub.Advance(time.Minute)
+	mustFail(t, stub, addr2, ReinstateService, "YouTube")
+	mustFail(t, stub, addr4, ReinstateService, "Google Maps")
+	mustInvoke(t, stub, addr4, ReinstateService, "YouTube", "Malware removed.")
+	if s := getService(t, stub, "YouTube"); s.Status != S_Available || s.SuspendedFrom != "" {
+		t.Fatalf("reinstated service = %+v", s)
+	}
+	if got := healthOf(t, stub, "MapVideos"); got != "[]" {
+		t.Fatalf("mashup health = %s", got)
+	}
+	h := getHistory(t, stub, "YouTube")
+	if last := h[len(h)-1]; last.From != S_Suspended || last.To != S_Available || last.Reason != "Malware removed." {
+		t.Fatalf("history = %+v", h)
+	}
+}
+
+func TestSuspendDeprecatedService(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr1, GrantRole, R_Moderator, addr4)
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+	mustInvoke(t, stub, addr1, DeprecateService, "Google Maps", "Twitter")
+
+	// the successor survives the suspension
+	mustInvoke(t, stub, addr4, SuspendService, "Google Maps", "Malware.")
+	if s := getService(t, stub, "Google Maps"); s.Status != S_Suspended || s.Successor != "Twitter" {
+		t.Fatalf("suspended service = %+v", s)
+	}
+	mustInvoke(t, stub, addr4, ReinstateService, "Google Maps")
+	if s := getService(t, stub, "Google Maps"); s.Status != S_Deprecated || s.Successor != "Twitter" {
+		t.Fatalf("reinstated service = %+v", s)
+	}
+
+	// an invalidated service has none
+	mustInvoke(t, stub, addr1, InvalidateService, "Google Maps", "Shut down.")
+	if s := getService(t, stub, "Google Maps"); s.Status != S_Invalid || s.Successor != "" {
+		t.Fatalf("invalid service = %+v", s)
+	}
+}
+
+func TestModeratorsSuspendUsers(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr1, GrantRole, R_Moderator, addr4)
+
+	mustFail(t, stub, addr3, SuspendUser, "user2", "Spam.")
+	mustFail(t, stub, addr4, SuspendUser, "user2", "")
+	mustFail(t, stub, addr4, SuspendUser, "user9", "Spam.")
+	mustInvoke(t, stub, addr4, SuspendUser, "user2", "Spam.")
+	if envelope, _ := lastEvent(t, stub); envelope != "suspendUser "+addr4+" USER_user2 registered>suspended" {
+		t.Fatalf("event = %s", envelope)
+	}
+	if s := getUser(t, stub, "user2").Suspension; s == nil || s.Reason != "Spam." || s.Moderator != addr4 {
+		t.Fatalf("suspension = %+v", s)
+	}
+	mustFail(t, stub, addr4, SuspendUser, "user2", "Twice.")
+
+	// a suspended user can not act on services
+	mustFail(t, stub, addr2, PublishService, "Twitter")
+	mustFail(t, stub, addr2, EditService, "Twitter", "Description", "Buy now!")
+	mustFail(t, stub, addr2, RegisterService, "Spam", "Social", "Buy now!", "user2")
+	mustFail(t, stub, addr2, CreateMashup, "SpamMashup", "Social", "Buy now!", "Twitter")
+	mustFail(t, stub, addr2, RateService, "Google Maps", "1")
+
+	mustFail(t, stub, addr3, ReinstateUser, "user2")
+	mustInvoke(t, stub, addr4, ReinstateUser, "user2")
+	mustFail(t, stub, addr4, ReinstateUser, "user2")
+	if s := getUser(t, stub, "user2").Suspension; s != nil {
+		t.Fatalf("suspension after reinstatement = %+v", s)
+	}
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+}
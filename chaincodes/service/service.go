@@ -1,21 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 
+	"github.com/gzf09/DSES/chaincodes/events"
 	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
 	pb "github.com/inklabsfoundation/inkchain/protos/peer"
 	"encoding/json"
 	"time"
-	"math/big"
-	"bytes"
-)
-
-// Incentive-related const
-const (
-	IncentiveBalanceType 	= "INK"
-	IncentiveMashupInvoke	= "10"
 )
 
 // Definitions of a service's status
@@ -23,12 +19,82 @@ const (
 	S_Created = "created"
 	S_Available = "available"
 	S_Invalid = "invalid"
+	S_Deprecated = "deprecated"	// replaced by a successor service
+	S_Suspended = "suspended"	// taken down by a moderator, see roles.go
 )
 
 // Prefixes for user and service separately
 const (
 	UserPrefix	= "USER_"
 	ServicePrefix	= "SER_"
+	ConfigPrefix	= "CONFIG_"
+	PayoutPrefix	= "PAYOUT_"		// payout records of mashups, see incentive.go
+	RewardPrefix	= "REWARD_"		// rewards by transaction id, see reward.go
+)
+
+// Keys of the chaincode's configuration records
+const (
+	AdminKey				= ConfigPrefix + "admin"			// admin of earlier versions, moved to the roles by Init
+	ContributionWeightsKey	= ConfigPrefix + "contribution"		// weights of the contribution activities
+	IncentivePolicyKey		= ConfigPrefix + "incentive"		// fees paid by mashups, see incentive.go
+)
+
+// Composite-key indexes
+const (
+	// developer~service: lists the services and mashups of a developer
+	DeveloperServiceIndex = "developer~service"
+	// service~transition: the status transition log of a service
+	ServiceTransitionIndex = "service~transition"
+	// address~user: finds the users registered with an address
+	AddressUserIndex = "address~user"
+	// retired~user: the former addresses of users, see users.go
+	RetiredAddressIndex = "retired~user"
+	// role~address: the admins and moderators, see roles.go
+	RoleIndex = "role~address"
+	// leaderboards and the service types of developers, see leaderboard.go
+	ContributionBoardIndex = "contribution~user"
+	TypeContributionBoardIndex = "type~contribution~user"
+	DeveloperTypeIndex = "developer~type~service"
+	// service~mashup: lists the mashups composing a service, see dependency.go
+	UsedByIndex = "service~mashup"
+	// pay-per-use metering, see metering.go
+	GatewayIndex = "gateway"
+	UsageIndex = "usage~period~consumer~service~token"
+	UsageBatchIndex = "usagebatch~gateway~batch"
+	UsageStatementIndex = "statement~period~consumer"
+	// subscriptions, see subscription.go
+	PlanIndex = "plan~service~plan"
+	SubscriptionIndex = "subscription~service~consumer"
+	// offer~user~service: pending transfer offers by offered user, see transfer.go
+	TransferOfferIndex = "offer~user~service"
+	// ratings of services, see rating.go
+	RatingIndex = "rating~service~user"
+	// reward history, see reward.go
+	ServiceRewardIndex = "reward~service~time~tx"
+	DeveloperRewardIndex = "reward~developer~time~tx"
+	RewarderRewardIndex = "reward~rewarder~time~tx"
+)
+
+// Layout of timestamps used in ordered composite keys
+const sortableTimeLayout = "20060102T150405.000000000Z"
+
+// Policies of removeUser for the services of the removed user
+const (
+	RemoveBlock		= "block"		// refuse while the user develops services
+	RemoveOrphan	= "orphan"		// keep the services, marked orphaned
+)
+
+// Filters for queryServiceByUser
+const (
+	KindAll		= ""
+	KindService	= "service"
+	KindMashup	= "mashup"
+)
+
+// Pagination-related const
+const (
+	DefaultPageSize	= 20
+	MaxPageSize		= 200
 )
 
 // Invoke functions definition
@@ -37,19 +103,91 @@ const (
 	RegisterUser 	= "registerUser"
 	RemoveUser 		= "removeUser"
 	QueryUser		= "queryUser"
+	QueryUserByAddress	= "queryUserByAddress"
+	EditUser			= "editUser"
+	SetGuardians		= "setGuardians"		// addresses allowed to recover a user
+	RotateAddress		= "rotateAddress"		// move a user to a new address
+	RecoverAddress		= "recoverAddress"		// admins or guardians, delayed
+	ConfirmAddress		= "confirmAddress"		// by the new address
+	CancelRotation		= "cancelRotation"
 
 	// Service-related invoke
 	RegisterService 	= "registerService"
+	RegisterServicesBatch	= "registerServicesBatch"	// many services in one transaction
 	InvalidateService 	= "invalidateService"	// mark whether the service is validated
 	PublishService		= "publishService"		// publish a created service
+	DeprecateService	= "deprecateService"	// replace an available service by a successor
 	CreateMashup 		= "createMashup"		// utilize services to create a new mashup
 	QueryService		= "queryService"
 	EditService			= "editService"
 	QueryServiceByUser	= "queryServiceByUser"
 	QueryServiceByRange	= "queryServiceByRange"
+	QueryServiceHistory	= "queryServiceHistory"	// status transition log of a service
+	QueryCoOccurrence	= "queryCoOccurrence"	// services most often composed with a service
+	RecommendServices	= "recommendServices"	// companion services for a set of services
+	QueryDependencies	= "queryDependencies"	// services a mashup is built on, transitively
+	QueryDependents		= "queryDependents"		// mashups built on a service, transitively
 
 	// User-related reward invoke
-	RewardService = "rewardService"
+	RewardService			= "rewardService"
+	QueryServiceRewards		= "queryServiceRewards"		// rewards received by a service
+	QueryDeveloperRewards	= "queryDeveloperRewards"	// rewards received by a developer
+	QueryRewardsGiven		= "queryRewardsGiven"		// rewards given by a user
+
+	// Contribution-related invoke
+	QueryContribution			= "queryContribution"
+	SetContributionWeights		= "setContributionWeights"		// admin only
+	QueryContributionWeights	= "queryContributionWeights"
+	QueryLeaderboard			= "queryLeaderboard"
+
+	// Incentive-related invoke
+	SetIncentivePolicy			= "setIncentivePolicy"			// admin only
+	QueryIncentivePolicy		= "queryIncentivePolicy"
+	QueryPayout					= "queryPayout"					// how a mashup's fee was paid out
+
+	// Metering-related invoke
+	AuthorizeGateway			= "authorizeGateway"			// admin only
+	RevokeGateway				= "revokeGateway"				// admin only
+	SetServicePrice				= "setServicePrice"				// developer only
+	RecordUsage					= "recordUsage"					// authorized gateways only
+	SettleUsage					= "settleUsage"
+	QueryUsage					= "queryUsage"
+	QueryStatement				= "queryStatement"
+
+	// Subscription-related invoke
+	SetSubscriptionPlan			= "setSubscriptionPlan"			// developer only
+	RemoveSubscriptionPlan		= "removeSubscriptionPlan"		// developer only
+	QueryPlans					= "queryPlans"
+	Subscribe					= "subscribe"
+	RenewSubscription			= "renewSubscription"
+	CancelSubscription			= "cancelSubscription"
+	QuerySubscription			= "querySubscription"
+	CheckEntitlement			= "checkEntitlement"			// may a consumer call a service now
+
+	// Rating-related invoke
+	RateService					= "rateService"
+	QueryReviews				= "queryReviews"
+
+	// Role and moderation invoke
+	GrantRole					= "grantRole"					// admin only
+	RevokeRole					= "revokeRole"					// admin only
+	QueryRoles					= "queryRoles"
+	SuspendService				= "suspendService"				// moderators only
+	ReinstateService			= "reinstateService"			// moderators only
+	SuspendUser					= "suspendUser"					// moderators only
+	ReinstateUser				= "reinstateUser"				// moderators only
+
+	// Transfer-related invoke
+	OfferServiceTransfer		= "offerServiceTransfer"		// developer only
+	AcceptServiceTransfer		= "acceptServiceTransfer"		// offered user only
+	CancelServiceTransfer		= "cancelServiceTransfer"		// developer or offered user
+
+	// Maintainer-related invoke
+	AddMaintainer				= "addMaintainer"				// owner only
+	RemoveMaintainer			= "removeMaintainer"			// owner or the maintainer
+	ProposeShares				= "proposeShares"				// owner or maintainers
+	ApproveShares				= "approveShares"				// owner only
+	RejectShares				= "rejectShares"				// owner only
 
 )
 
@@ -67,11 +205,26 @@ type user struct {
 
 	Contribution	int		`json:"contribution"`
 	// "Contribution" evaluates the user's contribution to the service ecosystem.
-	// TODO: add handler about "Contribution"
+	// It is the sum of the points in "Activities", see contribution.go.
 	// Benefit of "Contribution":
 	// 1. construct a evaluation for every user's contribution on the service ecosystem
 	// 2. inspire users to participate in creating new services and mashups
 
+	Activities		map[string]activityScore	`json:"activities,omitempty"`
+	// "Activities" records the count and points of every contribution activity.
+
+	Suspension		*suspension	`json:"suspension,omitempty"`
+	// "Suspension" records why and by whom a suspended user was suspended, see roles.go.
+
+	Removed			bool	`json:"removed,omitempty"`
+	RemovedTime		string	`json:"removedTime,omitempty"`
+
+	Guardians		[]string			`json:"guardians,omitempty"`
+	Rotation		*addressRotation	`json:"rotation,omitempty"`
+	// "Guardians" may recover the user to a new address, "Rotation" is the
+	// pending move to a new address, see users.go.
+	// A removed user is kept as a tombstone, so its services, mashups and history
+	// still resolve its name and address. The name is not given to another user.
 }
 
 // Structure definition for service
@@ -82,13 +235,35 @@ type service struct {
 	Developer		string	`json:"developer"`		// record the user that developed this service
 	Description		string 	`json:"description"`
 
+	// Metadata describes how to reach and use the service, see metadata.go.
+	Metadata		*serviceMetadata	`json:"metadata,omitempty"`
+
 	CreatedTime		string	`json:"createdTime"`
 	UpdatedTime		string	`json:"updatedTime"`
 
 	// Status records the status of a service:
-	// created/available/invalid
+	// created/available/invalid/deprecated/suspended
 	Status			string 	`json:"status"`
 
+	// SuspendedFrom is the status a suspended service is reinstated to.
+	SuspendedFrom	string	`json:"suspendedFrom,omitempty"`
+
+	// Orphaned services lost their developer, see removeUser.
+	Orphaned		bool	`json:"orphaned,omitempty"`
+
+	// Transfer is the pending offer of the service to another developer, see transfer.go.
+	Transfer		*transferOffer	`json:"transfer,omitempty"`
+
+	// Maintainers lists the members keeping the service with their revenue
+	// shares, the developer first as owner; ProposedShares wait for the
+	// owner's approval, see maintainers.go.
+	Maintainers		[]maintainer	`json:"maintainers,omitempty"`
+	ProposedShares	*shareProposal	`json:"proposedShares,omitempty"`
+
+	// Successor names the service replacing a deprecated one, kept while
+	// the deprecated service is suspended.
+	Successor		string	`json:"successor,omitempty"`
+
 	// Whether the service is a mashup or not.
 	IsMashup		bool 	`json:"isMashup"`
 
@@ -96,6 +271,20 @@ type service struct {
 	// if the service is not a mashup, "Composited" records the co-occurrence documents of the service
 	Composition		map[string]int	`json:"composition"`
 
+	// ComposedCount counts the mashups that compose the service.
+	ComposedCount	int		`json:"composedCount"`
+
+	// Health is "degraded" while some components of a mashup are broken,
+	// BrokenComponents lists them, see health.go
+	Health				string		`json:"health,omitempty"`
+	BrokenComponents	[]string	`json:"brokenComponents,omitempty"`
+
+	// Price per call, see metering.go
+	Price				*servicePrice	`json:"price,omitempty"`
+
+	// Summary of the users' ratings, see rating.go
+	Rating				*ratingSummary	`json:"rating,omitempty"`
+
 	// Benefit of "Composited":
 	// 1. Automatically create service co-occurrence documents and store it into the ledger
 	// 2. Promote the security and integrality of service data
@@ -117,6 +306,42 @@ func main() {
 // ==================================================================================
 func (t *serviceChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("assetChaincode Init.")
+
+	// Init also runs on upgrade, keep the configuration already on the ledger
+	// args: (optional) addresses of the first admins, the instantiator by default
+	err := initAdmins(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	weightsAsBytes, err := stub.GetState(ContributionWeightsKey)
+	if err != nil {
+		return shim.Error("Fail to get contribution weights: " + err.Error())
+	} else if weightsAsBytes == nil {
+		weightsAsBytes, err = json.Marshal(defaultContributionWeights)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(ContributionWeightsKey, weightsAsBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	policyAsBytes, err := stub.GetState(IncentivePolicyKey)
+	if err != nil {
+		return shim.Error("Fail to get the incentive policy: " + err.Error())
+	} else if policyAsBytes == nil {
+		policyAsBytes, err = json.Marshal(defaultIncentivePolicy)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(IncentivePolicyKey, policyAsBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	return shim.Success([]byte("Init success."))
 }
 
@@ -137,10 +362,11 @@ func (t *serviceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		return t.registerUser(stub, args)
 
 	case RemoveUser:
-		if len(args) != 1 {
-			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		if len(args) < 1 || len(args) > 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2.")
 		}
 		// args[0]: user name
+		// args[1]: (optional) policy for the user's services, "block" by default or "orphan"
 		return t.removeUser(stub, args)
 
 	case QueryUser:
@@ -150,23 +376,79 @@ func (t *serviceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		// args[0]: user name
 		return t.queryUser(stub, args)
 
+	case QueryUserByAddress:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: address
+		return t.queryUserByAddress(stub, args)
+
+	case EditUser:
+		if len(args) != 3 {
+			return shim.Error("Incorrect number of arguments. Expecting 3.")
+		}
+		// args[0]: user name
+		// args[1]: field name, "Introduction"
+		// args[2]: new value
+		return t.editUser(stub, args)
+
+	case SetGuardians:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: user name
+		// args[1]: guardian addresses, e.g. ["a5ff00eb..."]
+		return t.setGuardians(stub, args)
+
+	case RotateAddress, RecoverAddress:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: user name
+		// args[1]: new address
+		if function == RotateAddress {
+			return t.rotateAddress(stub, args)
+		}
+		return t.recoverAddress(stub, args)
+
+	case ConfirmAddress, CancelRotation:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: user name
+		if function == ConfirmAddress {
+			return t.confirmAddress(stub, args)
+		}
+		return t.cancelRotation(stub, args)
+
 	// ********************************************************
 	// PART 2: service-related invokes
 	case RegisterService:
-		if len(args) != 4 {
-			return shim.Error("Incorrect number of arguments. Expecting 4.")
+		if len(args) < 4 || len(args) > 5 {
+			return shim.Error("Incorrect number of arguments. Expecting 4 or 5.")
 		}
 		// args[0]: service name
 		// args[1]: service type
 		// args[2]: service description
 		// args[3]: developer's name
+		// args[4]: (optional) metadata, a JSON document
 		return t.registerService(stub, args)
 
+	case RegisterServicesBatch:
+		if len(args) < 2 || len(args) > 3 {
+			return shim.Error("Incorrect number of arguments. Expecting 2 or 3.")
+		}
+		// args[0]: developer's name
+		// args[1]: services, a JSON array of {"name","type","description","metadata"}
+		// args[2]: (optional) "atomic" to register all the rows or none
+		return t.registerServicesBatch(stub, args)
+
 	case InvalidateService:
-		if len(args) != 1 {
-			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
 		}
 		// args[0]: service name
+		// args[1]: reason of the invalidation
 		return t.invalidateService(stub, args)
 
 	case PublishService:
@@ -176,6 +458,50 @@ func (t *serviceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		// args[0]: service name
 		return t.publishService(stub, args)
 
+	case DeprecateService:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: successor service name
+		return t.deprecateService(stub, args)
+
+	case QueryServiceHistory:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.queryServiceHistory(stub, args)
+
+	case QueryCoOccurrence:
+		if len(args) < 1 || len(args) > 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2.")
+		}
+		// args[0]: service name
+		// args[1]: (optional) number of services, all by default
+		return t.queryCoOccurrence(stub, args)
+
+	case RecommendServices:
+		if len(args) < 4 {
+			return shim.Error("Incorrect number of arguments. Expecting 4 at least.")
+		}
+		// args[0]: number of recommendations, "" for the default
+		// args[1]: service type filter, "" for any type
+		// args[2]: similarity measure: "jaccard" or "count", "" for jaccard
+		// args[3...]: service list the recommendations go with
+		return t.recommendServices(stub, args)
+
+	case QueryDependencies, QueryDependents:
+		if len(args) < 1 || len(args) > 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2.")
+		}
+		// args[0]: service name
+		// args[1]: (optional) depth limit, 10 by default
+		if function == QueryDependencies {
+			return t.queryDependencies(stub, args)
+		}
+		return t.queryDependents(stub, args)
+
 	case QueryService:
 		if len(args) != 1 {
 			return shim.Error("Incorrect number of arguments. Expecting 1.")
@@ -202,24 +528,318 @@ func (t *serviceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		// args[3...]: invoked service list
 		return t.createMashup(stub, args)
 
+	case QueryServiceByUser:
+		if len(args) < 1 || len(args) > 5 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 to 5.")
+		}
+		// args[0]: developer's name
+		// args[1]: (optional) status filter, "" for all
+		// args[2]: (optional) kind filter: "service", "mashup" or "" for all
+		// args[3]: (optional) page size
+		// args[4]: (optional) bookmark returned by the previous page
+		return t.queryServiceByUser(stub, args)
+
 	case QueryServiceByRange:
-		if len(args) !=2 {
-			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		if len(args) < 2 || len(args) > 4 {
+			return shim.Error("Incorrect number of arguments. Expecting 2 to 4.")
 		}
-		// args[0]: begin index
-		// args[1]: end index
+		// args[0]: begin service name, "" for the first service
+		// args[1]: end service name (excluded), "" for the last service
+		// args[2]: (optional) page size
+		// args[3]: (optional) bookmark returned by the previous page
 		return t.queryServiceByRange(stub, args)
 
 	// ********************************************************
 	// PART 3: user-related reward invokes
 	case RewardService:
-		if len(args) < 3 {
-			return shim.Error("Incorrect number of arguments. Expecting 3 at least.")
+		if len(args) < 3 || len(args) > 4 {
+			return shim.Error("Incorrect number of arguments. Expecting 3 or 4.")
 		}
 		// args[0]: service name
 		// args[1]: reward_type
 		// args[2]: reward_amount
+		// args[3]: (optional) memo
 		return t.rewardService(stub, args)
+
+	case QueryServiceRewards, QueryDeveloperRewards, QueryRewardsGiven:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name, developer name, or rewarding user (name or address)
+		switch function {
+		case QueryServiceRewards:
+			return t.queryServiceRewards(stub, args)
+		case QueryDeveloperRewards:
+			return t.queryDeveloperRewards(stub, args)
+		}
+		return t.queryRewardsGiven(stub, args)
+
+	// ********************************************************
+	// PART 4: contribution invokes
+	case QueryContribution:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: user name
+		return t.queryContribution(stub, args)
+
+	case SetContributionWeights:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: weights as JSON, e.g. {"publish":10,"composed":5}
+		return t.setContributionWeights(stub, args)
+
+	case QueryContributionWeights:
+		return t.queryContributionWeights(stub, args)
+
+	case QueryLeaderboard:
+		if len(args) > 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 0 to 2.")
+		}
+		// args[0]: (optional) number of users, 10 by default
+		// args[1]: (optional) only rank the developers of this service type
+		return t.queryLeaderboard(stub, args)
+
+	case SetIncentivePolicy:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: policy as JSON, e.g. {"componentFee":"20","feeBasis":"service"}
+		return t.setIncentivePolicy(stub, args)
+
+	case QueryIncentivePolicy:
+		return t.queryIncentivePolicy(stub, args)
+
+	case QueryPayout:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: mashup name
+		return t.queryPayout(stub, args)
+
+	case AuthorizeGateway, RevokeGateway:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: gateway address
+		if function == AuthorizeGateway {
+			return t.authorizeGateway(stub, args)
+		}
+		return t.revokeGateway(stub, args)
+
+	case SetServicePrice:
+		if len(args) != 3 {
+			return shim.Error("Incorrect number of arguments. Expecting 3.")
+		}
+		// args[0]: service name
+		// args[1]: token type
+		// args[2]: price per call, 0 for free
+		return t.setServicePrice(stub, args)
+
+	case RecordUsage:
+		if len(args) != 3 {
+			return shim.Error("Incorrect number of arguments. Expecting 3.")
+		}
+		// args[0]: period, e.g. 2018-01
+		// args[1]: batch id, a retried batch is recorded once
+		// args[2]: entries as JSON, e.g. [{"consumer":"<address>","service":"Twitter","calls":100}]
+		return t.recordUsage(stub, args)
+
+	case SettleUsage:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: period to pay the sender's usage of
+		return t.settleUsage(stub, args)
+
+	case QueryUsage:
+		if len(args) < 1 || len(args) > 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2.")
+		}
+		// args[0]: period
+		// args[1]: (optional) consumer address
+		return t.queryUsage(stub, args)
+
+	case QueryStatement:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: period
+		// args[1]: consumer address
+		return t.queryStatement(stub, args)
+
+	case SetSubscriptionPlan:
+		if len(args) != 6 {
+			return shim.Error("Incorrect number of arguments. Expecting 6.")
+		}
+		// args[0]: service name
+		// args[1]: plan name
+		// args[2]: token type
+		// args[3]: price per term
+		// args[4]: term duration, e.g. 720h
+		// args[5]: calls per term, 0 for unlimited
+		return t.setSubscriptionPlan(stub, args)
+
+	case RemoveSubscriptionPlan:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: plan name
+		return t.removeSubscriptionPlan(stub, args)
+
+	case QueryPlans:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.queryPlans(stub, args)
+
+	case Subscribe:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: plan name
+		return t.subscribe(stub, args)
+
+	case RenewSubscription:
+		if len(args) < 1 || len(args) > 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2.")
+		}
+		// args[0]: service name
+		// args[1]: (optional) plan name, the current plan by default
+		return t.renewSubscription(stub, args)
+
+	case CancelSubscription:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		return t.cancelSubscription(stub, args)
+
+	case QuerySubscription, CheckEntitlement:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: consumer address
+		if function == QuerySubscription {
+			return t.querySubscription(stub, args)
+		}
+		return t.checkEntitlement(stub, args)
+
+	case RateService:
+		if len(args) < 2 || len(args) > 3 {
+			return shim.Error("Incorrect number of arguments. Expecting 2 or 3.")
+		}
+		// args[0]: service name
+		// args[1]: stars, 1 to 5
+		// args[2]: (optional) short review
+		return t.rateService(stub, args)
+
+	case QueryReviews:
+		if len(args) < 1 || len(args) > 3 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 to 3.")
+		}
+		// args[0]: service name
+		// args[1]: (optional) page size, 20 by default
+		// args[2]: (optional) bookmark returned by the previous page
+		return t.queryReviews(stub, args)
+
+	// ********************************************************
+	// PART 5: role and moderation invokes
+	case GrantRole, RevokeRole:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: role, "admin" or "moderator"
+		// args[1]: address
+		if function == GrantRole {
+			return t.grantRole(stub, args)
+		}
+		return t.revokeRole(stub, args)
+
+	case QueryRoles:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: role
+		return t.queryRoles(stub, args)
+
+	case SuspendService, SuspendUser:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service or user name
+		// args[1]: reason
+		if function == SuspendService {
+			return t.suspendService(stub, args)
+		}
+		return t.suspendUser(stub, args)
+
+	case ReinstateService, ReinstateUser:
+		if len(args) < 1 || len(args) > 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2.")
+		}
+		// args[0]: service or user name
+		// args[1]: (optional) reason
+		if function == ReinstateService {
+			return t.reinstateService(stub, args)
+		}
+		return t.reinstateUser(stub, args)
+
+	// ********************************************************
+	// PART 6: service transfer invokes
+	case OfferServiceTransfer:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: name of the new developer
+		return t.offerServiceTransfer(stub, args)
+
+	case AcceptServiceTransfer, CancelServiceTransfer:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		if function == AcceptServiceTransfer {
+			return t.acceptServiceTransfer(stub, args)
+		}
+		return t.cancelServiceTransfer(stub, args)
+
+	// ********************************************************
+	// PART 7: maintainer invokes
+	case AddMaintainer, RemoveMaintainer:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: user name of the maintainer
+		if function == AddMaintainer {
+			return t.addMaintainer(stub, args)
+		}
+		return t.removeMaintainer(stub, args)
+
+	case ProposeShares:
+		if len(args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 2.")
+		}
+		// args[0]: service name
+		// args[1]: shares, e.g. {"user1":7000,"user2":3000}
+		return t.proposeShares(stub, args)
+
+	case ApproveShares, RejectShares:
+		if len(args) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting 1.")
+		}
+		// args[0]: service name
+		if function == ApproveShares {
+			return t.approveShares(stub, args)
+		}
+		return t.rejectShares(stub, args)
 	}
 
 	return shim.Error("Invalid invoke function name.")
@@ -241,7 +861,7 @@ func (t *serviceChaincode) registerUser(stub shim.ChaincodeStubInterface, args [
 	new_intro = args[1]
 
 	// Get the user's address automatically through INKchian's GetSender() interface
-	new_add, err = stub.GetSender()
+	new_add, err = senderAddress(stub)
 	if err != nil {
 		return shim.Error("Fail to get the sender's address.")
 	}
@@ -255,8 +875,16 @@ func (t *serviceChaincode) registerUser(stub shim.ChaincodeStubInterface, args [
 		return shim.Error("This user already exists: " + new_name)
 	}
 
+	// an address registers one user at a time
+	old_name, err := userNameByAddress(stub, new_add)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if old_name != "" {
+		return shim.Error("This address already registered a user: " + old_name)
+	}
+
 	// register user
-	user := &user{new_name, new_intro, new_add, 0}
+	user := &user{Name: new_name, Introduction: new_intro, Address: new_add}
 	userJSONasBytes, err := json.Marshal(user)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -266,28 +894,164 @@ func (t *serviceChaincode) registerUser(stub shim.ChaincodeStubInterface, args [
 		return shim.Error(err.Error())
 	}
 
-	return shim.Success([]byte("User register success."))
+	// index the user under its address
+	address_key, err := stub.CreateCompositeKey(AddressUserIndex, []string{new_add, new_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(address_key, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// rank the user on the leaderboard
+	err = addLeaderboardEntry(stub, new_name, 0)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, RegisterUser, []string{user_key}, "", U_Registered, events.User{Name: new_name, Address: new_add})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("User register success."))
 }
 
-// ===================================
+// ===================================================================
 // removeUser: Remove an existed user
-// ===================================
+// Only the user's own address or an admin removes a user. The user is
+// kept as a tombstone. By default a user still developing services
+// can not be removed; with the "orphan" policy the services stay,
+// marked orphaned, and only moderators can act on them. Pending
+// transfer offers of the services and to the user are dropped.
+// ===================================================================
 func (t *serviceChaincode) removeUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var user_name string
+	var policy string
 	var err error
 
 	user_name = args[0]
+	policy = RemoveBlock
+	if len(args) > 1 {
+		policy = args[1]
+	}
+	if policy != RemoveBlock && policy != RemoveOrphan {
+		return shim.Error("Unknown removal policy: " + policy)
+	}
 
-	// check if user exists
+	// STEP 0: check if user exists
 	user_key := UserPrefix + user_name
-	userAsBytes, err := stub.GetState(user_key)
+	userJSON, err := readUser(stub, user_name)
 	if err != nil {
-		return shim.Error("Fail to get user: " + err.Error())
-	} else if userAsBytes == nil {
-		return shim.Error("This user does not exist: " + user_name)
+		return shim.Error(err.Error())
+	}
+	if userJSON.Removed {
+		return shim.Error("This user was removed already: " + user_name)
 	}
 
-	err = stub.DelState(user_key)
+	// STEP 1: check whether it is the user's or an admin's invocation
+	senderAdd, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	if senderAdd != userJSON.Address {
+		_, err = authorize(stub, nil, R_Admin)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// STEP 2: apply the policy to the user's services
+	services, err := developerServices(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(services) > 0 && policy == RemoveBlock {
+		return shim.Error("This user still develops services, remove it with the orphan policy: " + user_name)
+	}
+	keys := []string{user_key}
+	for _, service_name := range services {
+		serviceJSON, err := readService(stub, service_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		serviceJSON.Orphaned = true
+		err = setTransfer(stub, serviceJSON, nil)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		serviceJSONasBytes, err := json.Marshal(serviceJSON)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(ServicePrefix+service_name, serviceJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		keys = append(keys, ServicePrefix+service_name)
+	}
+
+	// drop the transfer offers made to the user
+	offered, err := offeredServices(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, service_name := range offered {
+		serviceJSON, err := readService(stub, service_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = setTransfer(stub, serviceJSON, nil)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		serviceJSONasBytes, err := json.Marshal(serviceJSON)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(ServicePrefix+service_name, serviceJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		keys = append(keys, ServicePrefix+service_name)
+	}
+
+	// STEP 3: leave a tombstone instead of the user
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	userJSON.Removed = true
+	userJSON.RemovedTime = txTime.Format(time.UnixDate)
+	userJSON.Introduction = ""
+	userJSON.Rotation = nil
+	userJSONasBytes, err := json.Marshal(userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(user_key, userJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// drop the user from the address index
+	address_key, err := stub.CreateCompositeKey(AddressUserIndex, []string{userJSON.Address, user_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.DelState(address_key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// take the user off the leaderboards
+	err = removeLeaderboardEntries(stub, user_name, userJSON.Contribution)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, RemoveUser, keys, U_Registered, U_Removed, events.User{Name: user_name, Address: userJSON.Address, Orphaned: services})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -317,6 +1081,29 @@ func (t *serviceChaincode) queryUser(stub shim.ChaincodeStubInterface, args []st
 	return shim.Success(userAsBytes)
 }
 
+// ================================================
+// queryUserByAddress: Query the user of an address
+// ================================================
+func (t *serviceChaincode) queryUserByAddress(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var address string
+	var err error
+
+	address = normalizeAddress(args[0])
+
+	userJSON, err := userByAddress(stub, address)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if userJSON == nil {
+		return shim.Error("No user is registered with this address: " + address)
+	}
+
+	userAsBytes, err := json.Marshal(userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(userAsBytes)
+}
+
 // Invoke func about service
 // ==================================================================================
 
@@ -327,7 +1114,6 @@ func (t *serviceChaincode) registerService(stub shim.ChaincodeStubInterface, arg
 	var service_name string
 	var service_type string
 	var service_des  string
-	var service_dev  string
 	var user_name string
 	var err error
 
@@ -336,23 +1122,18 @@ func (t *serviceChaincode) registerService(stub shim.ChaincodeStubInterface, arg
 	service_des = args[2]
 	user_name = args[3]
 
-	// get service developer, check if it corresponds with the input user
-	service_dev, err = stub.GetSender()
-	if err != nil {
-		return shim.Error("Fail to get the sender's address.")
-	}
-	user_key := UserPrefix + user_name
-	userAsBytes, err := stub.GetState(user_key)
-	if err != nil {
-		return shim.Error("Fail to get user: " + err.Error())
+	var metadata *serviceMetadata
+	if len(args) > 4 && args[4] != "" {
+		metadata, err = parseMetadata(args[4], nil)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
-	var userJSON user
-	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
+
+	// get service developer, check if it corresponds with the input user
+	userJSON, err := checkRegistrant(stub, user_name)
 	if err != nil {
-		return shim.Error("Error unmarshal user bytes.")
-	}
-	if userJSON.Address != service_dev {
-		return shim.Error("Not the correct user.")
+		return shim.Error(err.Error())
 	}
 
 	// check if service exists
@@ -364,19 +1145,14 @@ func (t *serviceChaincode) registerService(stub shim.ChaincodeStubInterface, arg
 		return shim.Error("This service already exists: " + service_name)
 	}
 
-	// get current time
-	tNow := time.Now()
-	tString := tNow.UTC().Format(time.UnixDate)
-
 	// register service
-	newS := &service{service_name, service_type, user_name,
-		service_des, tString, "", S_Created,
-		false, make(map[string]int)}
-	serviceJSONasBytes, err := json.Marshal(newS)
+	err = putNewService(stub, userJSON, service_name, service_type, service_des, metadata)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = stub.PutState(service_key, serviceJSONasBytes)
+
+	err = emitEvent(stub, RegisterService, []string{service_key}, "", S_Created,
+		events.ServiceRegistered{Service: service_name, Type: service_type, Developer: user_name})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -384,14 +1160,76 @@ func (t *serviceChaincode) registerService(stub shim.ChaincodeStubInterface, arg
 	return shim.Success([]byte("Service register success."))
 }
 
+// checkRegistrant reads the user registering services and checks that it
+// sent the transaction and is active.
+func checkRegistrant(stub shim.ChaincodeStubInterface, user_name string) (*user, error) {
+	service_dev, err := senderAddress(stub)
+	if err != nil {
+		return nil, errors.New("Fail to get the sender's address.")
+	}
+	userJSON, err := readUser(stub, user_name)
+	if err != nil {
+		return nil, err
+	}
+	if userJSON.Address != service_dev {
+		return nil, errors.New("Not the correct user.")
+	}
+	if userJSON.Removed {
+		return nil, errors.New("This user was removed: " + user_name)
+	}
+	if userJSON.Suspension != nil {
+		return nil, errors.New("This user is suspended: " + user_name)
+	}
+	return userJSON, nil
+}
+
+// putNewService stores a new service of userJSON, indexes it under its
+// developer and opens its transition log. The caller checks that the name
+// is free.
+func putNewService(stub shim.ChaincodeStubInterface, userJSON *user, service_name string, service_type string, service_des string, metadata *serviceMetadata) error {
+	// get the transaction time, the same on every peer
+	tNow, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	tString := tNow.Format(time.UnixDate)
+
+	newS := &service{Name: service_name, Type: service_type, Developer: userJSON.Name,
+		Description: service_des, Metadata: metadata, CreatedTime: tString, Status: S_Created,
+		IsMashup: false, Composition: make(map[string]int)}
+	serviceJSONasBytes, err := json.Marshal(newS)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(ServicePrefix+service_name, serviceJSONasBytes)
+	if err != nil {
+		return err
+	}
+
+	// index the service under its developer
+	err = addDeveloperIndex(stub, userJSON.Name, service_name)
+	if err != nil {
+		return err
+	}
+	err = indexDeveloperType(stub, userJSON.Name, service_type, service_name, userJSON.Contribution)
+	if err != nil {
+		return err
+	}
+
+	// open the service's transition log
+	return logTransition(stub, service_name, "", S_Created, "", "")
+}
+
 // =================================================
 // invalidateService: Invalidate an existed service
 // =================================================
 func (t *serviceChaincode) invalidateService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var service_name string
+	var reason string
 	var err error
 
 	service_name = args[0]
+	reason = args[1]
 
 	// STEP 0: check if service exists
 	service_key := ServicePrefix + service_name
@@ -402,41 +1240,27 @@ func (t *serviceChaincode) invalidateService(stub shim.ChaincodeStubInterface, a
 		return shim.Error("This service does not exists: " + service_name)
 	}
 
-	// STEP 1: check whether it is the service's developer's invocation
-	var senderAdd string
-	senderAdd, err = stub.GetSender()
-	if err != nil {
-		return shim.Error("Fail to get the sender's address.")
-	}
-
 	var serviceJSON service
 	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
 	if err != nil {
 		return shim.Error("Error unmarshal service bytes.")
 	}
 
-	// 0125
-	// get developer's address
-	dev_key := UserPrefix + serviceJSON.Developer
-	devAsBytes, err := stub.GetState(dev_key)
+	// STEP 1: check whether it is the service's developer's or a moderator's invocation
+	_, err = authorize(stub, &serviceJSON, R_Moderator)
 	if err != nil {
-		return shim.Error("Error get the developer.")
-	}
-	var DevJSON user
-	err = json.Unmarshal([]byte(devAsBytes), &DevJSON)
-
-	fmt.Println("DevAddress:  " + DevJSON.Address)
-	if senderAdd != DevJSON.Address {
-		return shim.Error("Aurthority err! Not invoke by the service's developer.")
+		return shim.Error(err.Error())
 	}
 
 	// STEP 2: invalidate the service and store it.
-	// new service, make it invalidated
-	new_service := &service{serviceJSON.Name, serviceJSON.Type, serviceJSON.Developer,
-							serviceJSON.Description, serviceJSON.CreatedTime, serviceJSON.UpdatedTime,
-							S_Invalid, serviceJSON.IsMashup, serviceJSON.Composition}
+	// the lifecycle decides whether the service can be invalidated
+	before := serviceJSON.Status
+	err = transitionService(stub, &serviceJSON, S_Invalid, reason, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	// store the new service
-	assetJSONasBytes, err := json.Marshal(new_service)
+	assetJSONasBytes, err := json.Marshal(serviceJSON)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -446,6 +1270,19 @@ func (t *serviceChaincode) invalidateService(stub shim.ChaincodeStubInterface, a
 		return shim.Error(err.Error())
 	}
 
+	// STEP 3: degrade the mashups built on the service
+	mashups, err := cascadeHealth(stub, &serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 4: announce the invalidation and the degraded mashups
+	err = emitEvent(stub, InvalidateService, impactKeys(service_name, mashups), before, S_Invalid,
+		events.ServiceInvalidated{Service: service_name, Developer: serviceJSON.Developer, Reason: reason, Mashups: mashups})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success([]byte("Invalidate Service success."))
 }
 
@@ -467,44 +1304,29 @@ func (t *serviceChaincode) publishService(stub shim.ChaincodeStubInterface, args
 		return shim.Error("This service does not exists: " + service_name)
 	}
 
-	// STEP 1: check whether it is the service's developer's invocation
-	var senderAdd string
-	senderAdd, err = stub.GetSender()
-	if err != nil {
-		return shim.Error("Fail to get the sender's address.")
-	}
-
 	var serviceJSON service
 	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
 	if err != nil {
 		return shim.Error("Error unmarshal service bytes.")
 	}
 
-	fmt.Println("SenderAdd:  " + senderAdd)
-	fmt.Println("Developer:  " + serviceJSON.Developer)
-
-	// 0125
-	// get developer's address
-	dev_key := UserPrefix + serviceJSON.Developer
-	devAsBytes, err := stub.GetState(dev_key)
+	// STEP 1: check whether it is invoked by the service's developer or a maintainer
+	_, err = authorizeMaintainer(stub, &serviceJSON)
 	if err != nil {
-		return shim.Error("Error get the developer.")
-	}
-	var DevJSON user
-	err = json.Unmarshal([]byte(devAsBytes), &DevJSON)
-
-	fmt.Println("DevAddress:  " + DevJSON.Address)
-	if senderAdd != DevJSON.Address {
-		return shim.Error("Aurthority err! Not invoke by the service's developer.")
+		return shim.Error(err.Error())
 	}
 
 	// STEP 2: publish the service and store it.
-	// new service, make it invalidated
-	new_service := &service{serviceJSON.Name, serviceJSON.Type, serviceJSON.Developer,
-		serviceJSON.Description, serviceJSON.CreatedTime, serviceJSON.UpdatedTime,
-		S_Available, serviceJSON.IsMashup, serviceJSON.Composition}
+	// the lifecycle decides whether the service can be published
+	before := serviceJSON.Status
+	first_publish := before == S_Created
+	republish := before == S_Invalid
+	err = transitionService(stub, &serviceJSON, S_Available, "", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	// store the new service
-	serviceJSONasBytes, err := json.Marshal(new_service)
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -514,6 +1336,36 @@ func (t *serviceChaincode) publishService(stub shim.ChaincodeStubInterface, args
 		return shim.Error(err.Error())
 	}
 
+	// STEP 3: repair the mashups built on a republished service
+	mashups := []events.ImpactedMashup{}
+	if republish {
+		mashups, err = cascadeHealth(stub, &serviceJSON)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// STEP 4: credit the developer for a newly published service
+	if first_publish {
+		DevJSON, err := lookupDeveloper(stub, serviceJSON.Developer)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		credits := make(contributionCredits)
+		credits.add(DevJSON.Name, ActivityPublish)
+		_, err = creditContributions(stub, credits)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// STEP 5: announce the publication and the repaired mashups
+	err = emitEvent(stub, PublishService, impactKeys(service_name, mashups), before, S_Available,
+		events.ServicePublished{Service: service_name, Developer: serviceJSON.Developer, Mashups: mashups})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success([]byte("Publish Service success."))
 }
 
@@ -539,6 +1391,104 @@ func (t *serviceChaincode) queryService(stub shim.ChaincodeStubInterface, args [
 	return shim.Success(serviceAsBytes)
 }
 
+// ==============================================================
+// queryServiceByUser: Query the services developed by a user
+//
+// The services are read through the developer~service index and
+// returned in name order, one page at a time. Pass the returned
+// bookmark to get the next page; an empty bookmark means the
+// last page has been reached.
+// ==============================================================
+func (t *serviceChaincode) queryServiceByUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var user_name string
+	var status_filter string
+	var kind_filter string
+	var bookmark string
+	var err error
+
+	user_name = args[0]
+	if len(args) > 1 {
+		status_filter = args[1]
+	}
+	if len(args) > 2 {
+		kind_filter = args[2]
+	}
+	page_size := DefaultPageSize
+	if len(args) > 3 {
+		page_size, err = parsePageSize(args[3])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if len(args) > 4 {
+		bookmark = args[4]
+	}
+
+	if status_filter != "" && !isValidStatus(status_filter) {
+		return shim.Error("Unknown status filter: " + status_filter)
+	}
+	switch kind_filter {
+	case KindAll, KindService, KindMashup:
+	default:
+		return shim.Error("Unknown kind filter: " + kind_filter)
+	}
+
+	// STEP 0: walk the developer's index entries from the bookmark on
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(DeveloperServiceIndex, []string{user_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page := servicePage{Services: []service{}}
+	for resultsIterator.HasNext() {
+		indexResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		service_name := keyParts[1]
+		if service_name < bookmark {
+			continue
+		}
+
+		// STEP 1: load the service and apply the filters
+		serviceAsBytes, err := stub.GetState(ServicePrefix + service_name)
+		if err != nil {
+			return shim.Error("Fail to get service: " + err.Error())
+		} else if serviceAsBytes == nil {
+			continue
+		}
+		var serviceJSON service
+		err = json.Unmarshal(serviceAsBytes, &serviceJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal service bytes.")
+		}
+		if status_filter != "" && serviceJSON.Status != status_filter {
+			continue
+		}
+		if (kind_filter == KindMashup && !serviceJSON.IsMashup) || (kind_filter == KindService && serviceJSON.IsMashup) {
+			continue
+		}
+
+		// STEP 2: stop at the first match beyond the page, it starts the next one
+		if len(page.Services) == page_size {
+			page.Bookmark = service_name
+			break
+		}
+		page.Services = append(page.Services, serviceJSON)
+	}
+
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
+
 // ======================================
 // editService: Edit an existed service
 // ======================================
@@ -546,6 +1496,7 @@ func (t *serviceChaincode) editService(stub shim.ChaincodeStubInterface, args []
 	var service_name string
 	var field_name string
 	var field_value string
+	var old_value string
 	var err error
 
 	service_name = args[0]
@@ -561,51 +1512,62 @@ func (t *serviceChaincode) editService(stub shim.ChaincodeStubInterface, args []
 		return shim.Error("This service does not exist: " + service_name)
 	}
 
-	// STEP 1: check whether it is the service's developer's invocation
-	var senderAdd string
-	senderAdd, err = stub.GetSender()
-	if err != nil {
-		return shim.Error("Fail to get the sender's address.")
-	}
-
 	var serviceJSON service
 	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
 	if err != nil {
 		return shim.Error("Error unmarshal service bytes.")
 	}
 
-	// 0125
-	// get developer's address
-	dev_key := UserPrefix + serviceJSON.Developer
-	devAsBytes, err := stub.GetState(dev_key)
+	// STEP 1: check whether it is invoked by the service's developer or a maintainer
+	_, err = authorizeMaintainer(stub, &serviceJSON)
 	if err != nil {
-		return shim.Error("Error get the developer.")
+		return shim.Error(err.Error())
 	}
-	var DevJSON user
-	err = json.Unmarshal([]byte(devAsBytes), &DevJSON)
-
-	fmt.Println("DevAddress:  " + DevJSON.Address)
-	if senderAdd != DevJSON.Address {
-		return shim.Error("Aurthority err! Not invoke by the service's developer.")
+	DevJSON, err := lookupDeveloper(stub, serviceJSON.Developer)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
 	// STEP 2: update time information
-	tNow := time.Now()
-	tString := tNow.UTC().Format(time.UnixDate)
+	tNow, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tString := tNow.Format(time.UnixDate)
 
-	new_service := &service{serviceJSON.Name, serviceJSON.Type, serviceJSON.Developer,
-							serviceJSON.Description, serviceJSON.CreatedTime, tString,
-							 serviceJSON.Status, serviceJSON.IsMashup, serviceJSON.Composition}
+	new_service := serviceJSON
+	new_service.UpdatedTime = tString
 
 	// STEP 3: update field value
 	// developer can update service's type/description information
 	switch field_name {
 	case "Type":
+		old_value = serviceJSON.Type
 		new_service.Type = field_value
+		// move the service to its new type in the developer's types
+		if field_value != serviceJSON.Type {
+			err = unindexDeveloperType(stub, DevJSON.Name, serviceJSON.Type, service_name, DevJSON.Contribution)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			err = indexDeveloperType(stub, DevJSON.Name, field_value, service_name, DevJSON.Contribution)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
 		goto LABEL_STORE
 	case "Description":
+		old_value = serviceJSON.Description
 		new_service.Description = field_value
 		goto LABEL_STORE
+	case "Metadata":
+		// merge the document into the metadata, both are logged as JSON
+		new_service.Metadata, err = parseMetadata(field_value, serviceJSON.Metadata)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		old_value, field_value = metadataJSON(serviceJSON.Metadata), metadataJSON(new_service.Metadata)
+		goto LABEL_STORE
 	}
 	return shim.Error("Error field name.")
 
@@ -621,6 +1583,12 @@ LABEL_STORE:
 		return shim.Error(err.Error())
 	}
 
+	err = emitEvent(stub, EditService, []string{service_key}, serviceJSON.Status, serviceJSON.Status,
+		events.ServiceEdited{Service: service_name, Field: field_name, OldValue: old_value, NewValue: field_value})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	// return service info
 	return shim.Success(serviceAsBytes)
 }
@@ -640,11 +1608,22 @@ func (t *serviceChaincode) createMashup(stub shim.ChaincodeStubInterface, args [
 	mashup_type = args[1]
 	mashup_des = args[2]
 
-	// STEP 0: get mashup developer
-	mashup_dev, err = stub.GetSender()
+	// STEP 0: get mashup developer, a registered user who is not suspended
+	mashup_dev, err = senderAddress(stub)
 	if err != nil {
 		return shim.Error("Fail to get the sender's address.")
 	}
+	creatorJSON, err := userByAddress(stub, mashup_dev)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if creatorJSON == nil {
+		return shim.Error("Only registered users can create mashups.")
+	}
+	creator_name := creatorJSON.Name
+	err = checkActiveUser(stub, creator_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// STEP 1: check if service does not exist
 	mashup_key := ServicePrefix + mashup_name
@@ -656,13 +1635,16 @@ func (t *serviceChaincode) createMashup(stub shim.ChaincodeStubInterface, args [
 	}
 
 	// STEP 2: create a new mashup
-	// get current time
-	tNow := time.Now()
-	tString := tNow.UTC().Format(time.UnixDate)
+	// get the transaction time
+	tNow, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tString := tNow.Format(time.UnixDate)
 
 	// create composition
 	new_map := make(map[string]int)
-	new_developer_map := make(map[string]int)
+	components := make(map[string]*service)
 	for i:= 3; i<len(args);i++ {
 		// check the service exist
 		service_key := ServicePrefix + args[i]
@@ -672,57 +1654,107 @@ func (t *serviceChaincode) createMashup(stub shim.ChaincodeStubInterface, args [
 		} else if serviceAsBytes == nil {
 			return shim.Error("This service doesn't exist: " + args[i])
 		}
-		// add the service into map
-		new_map[args[i]] = 1
-		// temporarily store their addresses
+		// add the service into map, counting repeated uses
+		new_map[args[i]]++
+		// keep the component's record
 		var serviceJSON service
 		err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
 		if err != nil {
 			return shim.Error("Error unmarshal service bytes.")
 		}
-		new_developer_map[serviceJSON.Developer] = 1
+		components[args[i]] = &serviceJSON
 	}
 
 	// new mashup
-	newS := &service{mashup_name, mashup_type, mashup_dev,
-		mashup_des, tString, "", S_Created,
-		true, new_map}
+	newS := &service{Name: mashup_name, Type: mashup_type, Developer: creator_name,
+		Description: mashup_des, CreatedTime: tString, Status: S_Created,
+		IsMashup: true, Composition: new_map}
+
+	// the new mashup starts degraded when it composes broken services
+	for component_name, component := range components {
+		if isBroken(component) {
+			setComponentHealth(newS, component_name, true)
+		}
+	}
 
 	// STEP 3: pay to the invoked services' developers
 	// Important!
 	// Incentive Mechanism Here
+	// the incentive policy on the ledger sets the fee
+	err = payIncentives(stub, newS, components)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 4: store the new mashup
+	serviceJSONasBytes, err := json.Marshal(newS)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(mashup_key, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	incentive_amount := big.NewInt(0)
-	incentive_amount.SetString(IncentiveMashupInvoke, 10)
+	// index the mashup under its developer
+	err = addDeveloperIndex(stub, newS.Developer, mashup_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	for k,_ := range(new_developer_map) {
-		// get the k's address
-		user_key := UserPrefix + k
-		userAsBytes, err := stub.GetState(user_key)
+	// index the mashup under every service it composes
+	for component_name := range components {
+		err = addUsedByIndex(stub, component_name, mashup_name)
 		if err != nil {
-			return shim.Error("Fail to get user: " + err.Error())
-		} else if userAsBytes == nil {
-			return shim.Error("This user doesn't exist: " + k)
+			return shim.Error(err.Error())
 		}
-		var userJSON user
-		err = json.Unmarshal([]byte(userAsBytes), &userJSON)
+	}
+
+	// open the mashup's transition log
+	err = logTransition(stub, mashup_name, "", S_Created, "", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// update the co-occurrence documents of the composed services
+	err = recordCoOccurrence(stub, components)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 5: credit the other developers it composes, and the creator when
+	// it composes someone else's service; a mashup of one's own services
+	// earns nothing
+	credits := make(contributionCredits)
+	for _, component := range components {
+		componentDev, err := lookupDeveloper(stub, component.Developer)
 		if err != nil {
-			return shim.Error("Error unmarshal user bytes.")
+			return shim.Error(err.Error())
 		}
-		// make incentive transfer
-		// from the mashup developer to the invoked service's developer
-		err = stub.Transfer(userJSON.Address, IncentiveBalanceType, incentive_amount)
-		if err != nil {
-			return shim.Error("Error when making transfer.")
+		if componentDev.Name != creator_name && memberIndex(component, creator_name) < 0 {
+			credits.add(componentDev.Name, ActivityComposed)
 		}
 	}
+	if len(credits) > 0 {
+		credits.add(creator_name, ActivityMashup)
+	}
+	scores, err := creditContributions(stub, credits)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	creator_score, ok := scores[creator_name]
+	if !ok {
+		creator_score = creatorJSON.Contribution
+	}
 
-	// STEP 4: store the new mashup
-	serviceJSONasBytes, err := json.Marshal(newS)
+	// the creator now develops a service of the mashup's type
+	err = indexDeveloperType(stub, creator_name, mashup_type, mashup_name, creator_score)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = stub.PutState(mashup_key, serviceJSONasBytes)
+
+	err = emitEvent(stub, CreateMashup, []string{mashup_key}, "", S_Created,
+		events.MashupCreated{Service: mashup_name, Type: mashup_type, Developer: newS.Developer, Composition: new_map})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -734,69 +1766,153 @@ func (t *serviceChaincode) createMashup(stub shim.ChaincodeStubInterface, args [
 // rewardService: reward a service
 // reward a service's developer, transfer fixed amount of
 // specific reward_type token to the developer's account.
+// The developer and maintainers can not reward their own service.
 // =======================================================
 func (t *serviceChaincode) rewardService(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var service_name string
 	var reward_type string
+	var memo string
 	var err error
 
 	service_name = args[0]
 	reward_type = args[1]
+	if len(args) > 3 {
+		memo = args[3]
+	}
+	if len(memo) > MaxMemoLength {
+		return shim.Error("The memo can not be longer than 140 bytes.")
+	}
 
 	// Amount
-	reward_amount := big.NewInt(0)
-	_, good := reward_amount.SetString(args[2], 10)
-	if !good {
-		return shim.Error("Expecting integer value for amount")
+	reward_amount, err := parseRewardAmount(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	// STEP 0: get service's developer
-	service_key := ServicePrefix + service_name
-	serviceAsBytes, err := stub.GetState(service_key)
+	// STEP 0: get service's developer, only available services are rewarded
+	serviceJSON, err := readService(stub, service_name)
 	if err != nil {
-		return shim.Error("Fail to get the service's info.")
+		return shim.Error(err.Error())
+	}
+	if serviceJSON.Status != S_Available {
+		return shim.Error("This service is not available: " + service_name)
+	}
+	if serviceJSON.Orphaned {
+		return shim.Error("This service is orphaned: " + service_name)
 	}
 
-	var serviceJSON service
-	err = json.Unmarshal([]byte(serviceAsBytes), &serviceJSON)
+	// STEP 1: get the dev, its members can not reward the service
+	devJSON, err := lookupDeveloper(stub, serviceJSON.Developer)
 	if err != nil {
-		return shim.Error("Error unmarshal service bytes.")
+		return shim.Error(err.Error())
+	}
+	dev := devJSON.Name
+	rewarder, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	rewarder_name, err := userNameByAddress(stub, rewarder)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if rewarder_name != "" {
+		member, err := isMember(stub, serviceJSON, rewarder_name)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if member {
+			return shim.Error("Developers can not reward their own services.")
+		}
 	}
 
-	dev := serviceJSON.Developer
+	// STEP 2: reward the developer, or its members by share
+	split, err := splitShares(stub, serviceJSON, reward_amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, part := range split {
+		toAdd, err := developerAddress(stub, part.Developer)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		amount, _ := big.NewInt(0).SetString(part.Amount, 10)
+		err = stub.Transfer(toAdd, reward_type, amount)
+		if err != nil {
+			return shim.Error("Fail realize the reawrd.")
+		}
+	}
+	if len(split) < 2 {
+		split = nil
+	}
 
-	// STEP 1: get the address of the dev
-	user_key := UserPrefix + dev
-	userAsBytes, err := stub.GetState(user_key)
+	// STEP 3: record the reward
+	txTime, err := txTimestamp(stub)
 	if err != nil {
-		return shim.Error("Fail to get the developer's info.")
+		return shim.Error(err.Error())
 	}
-	var userJSON user
-	err = json.Unmarshal([]byte(userAsBytes), &userJSON)
+	rewardJSON := &reward{service_name, dev, rewarder, rewarder_name, reward_type,
+		reward_amount.String(), memo, stub.GetTxID(), txTime.Format(time.UnixDate), split}
+	err = recordReward(stub, rewardJSON, txTime)
 	if err != nil {
-		return shim.Error("Error unmarshal user bytes.")
+		return shim.Error(err.Error())
+	}
+
+	// STEP 4: credit the developer for the reward
+	credits := make(contributionCredits)
+	credits.add(dev, ActivityReward)
+	_, err = creditContributions(stub, credits)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	// STEP 3: reward the developer
-	toAdd := userJSON.Address
-	err = stub.Transfer(toAdd, reward_type, reward_amount)
+	err = emitEvent(stub, RewardService, []string{ServicePrefix + service_name, RewardPrefix + rewardJSON.TxID},
+		serviceJSON.Status, serviceJSON.Status,
+		events.ServiceRewarded{Service: service_name, Developer: dev, TokenType: reward_type, Amount: rewardJSON.Amount, Memo: memo})
 	if err != nil {
-		return shim.Error("Fail realize the reawrd.")
+		return shim.Error(err.Error())
 	}
 
 	return shim.Success([]byte("Reward the service success."))
 }
 
 // ========================================================================
-// queryServiceByRange: query services' names by range (startKey, endKey)
+// queryServiceByRange: query services by name range [startName, endName)
 //
-// startKey and endKey are case-sensitive
-// use "" for both startKey and endKey if you want to query all the assets
+// startName and endName are case-sensitive
+// use "" for both startName and endName if you want to query all the services
+// The services are returned one page at a time, pass the returned bookmark
+// to get the next page; an empty bookmark means the last page.
 // ========================================================================
 func (t *serviceChaincode) queryServiceByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var start_name string
+	var end_name string
+	var bookmark string
+	var err error
+
+	start_name = args[0]
+	end_name = args[1]
+	page_size := DefaultPageSize
+	if len(args) > 2 {
+		page_size, err = parsePageSize(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if len(args) > 3 {
+		bookmark = args[3]
+	}
 
-	startKey := ""
-	endKey := ""
+	// STEP 0: scope the range to the service keyspace
+	startKey := ServicePrefix + start_name
+	if bookmark != "" {
+		if bookmark < start_name || (end_name != "" && bookmark >= end_name) {
+			return shim.Error("Bookmark out of the queried range: " + bookmark)
+		}
+		startKey = ServicePrefix + bookmark
+	}
+	endKey := ServicePrefix + end_name
+	if end_name == "" {
+		endKey = prefixRangeEnd(ServicePrefix)
+	}
 
 	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
 	if err != nil {
@@ -804,38 +1920,199 @@ func (t *serviceChaincode) queryServiceByRange(stub shim.ChaincodeStubInterface,
 	}
 	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	bArrayIndex := 1
+	// STEP 1: collect one page, the next service becomes the bookmark
+	page := servicePage{Services: []service{}}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-			// Add a comma before array members, suppress it for the first array member
-			if bArrayMemberAlreadyWritten == true {
-				buffer.WriteString(",")
-			}
-			// index of the result
-			buffer.WriteString("{\"Number\":")
-			buffer.WriteString("\"")
-			bArrayIndexStr := strconv.Itoa(bArrayIndex)
-			buffer.WriteString(string(bArrayIndexStr))
-			bArrayIndex += 1
-			buffer.WriteString("\"")
-			// information about current asset
-			buffer.WriteString(", \"Record\":")
-			buffer.WriteString(string(queryResponse.Value))
-			buffer.WriteString("}")
-			bArrayMemberAlreadyWritten = true
+		var serviceJSON service
+		err = json.Unmarshal(queryResponse.Value, &serviceJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal service bytes.")
+		}
+		if len(page.Services) == page_size {
+			page.Bookmark = serviceJSON.Name
+			break
+		}
+		page.Services = append(page.Services, serviceJSON)
+	}
+
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
+
+// Index helpers
+// ==================================================================================
+
+// normalizeAddress returns address in the form addresses are stored and
+// compared in: trimmed and lowercased.
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// senderAddress returns the normalized address of the transaction's sender.
+func senderAddress(stub shim.ChaincodeStubInterface) (string, error) {
+	sender, err := stub.GetSender()
+	return normalizeAddress(sender), err
+}
+
+// userNameByAddress returns the first user registered with address, "" if none.
+func userNameByAddress(stub shim.ChaincodeStubInterface, address string) (string, error) {
+	return firstUserOf(stub, AddressUserIndex, address)
+}
+
+// firstUserOf returns the first user of address in an address index, "" if none.
+func firstUserOf(stub shim.ChaincodeStubInterface, index string, address string) (string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(index, []string{normalizeAddress(address)})
+	if err != nil {
+		return "", err
+	}
+	defer resultsIterator.Close()
+
+	if !resultsIterator.HasNext() {
+		return "", nil
+	}
+	indexResponse, err := resultsIterator.Next()
+	if err != nil {
+		return "", err
+	}
+	_, keyParts, err := stub.SplitCompositeKey(indexResponse.Key)
+	if err != nil {
+		return "", err
+	}
+	return keyParts[1], nil
+}
+
+// userByAddress reads the user registered with address, nil if none.
+func userByAddress(stub shim.ChaincodeStubInterface, address string) (*user, error) {
+	user_name, err := userNameByAddress(stub, address)
+	if err != nil || user_name == "" {
+		return nil, err
+	}
+	return readUser(stub, user_name)
+}
+
+// lookupDeveloper resolves the developer recorded on a service to its user.
+// Every developer resolution goes through it. Services record their
+// developer's name; mashups created by earlier versions recorded the
+// creator's address instead, it resolves to the user who rotated away from
+// it, or else to the user registered with it.
+func lookupDeveloper(stub shim.ChaincodeStubInterface, developer string) (*user, error) {
+	userAsBytes, err := stub.GetState(UserPrefix + developer)
+	if err != nil {
+		return nil, errors.New("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		user_name, err := firstUserOf(stub, RetiredAddressIndex, developer)
+		if err != nil {
+			return nil, err
+		} else if user_name != "" {
+			return readUser(stub, user_name)
+		}
+		userJSON, err := userByAddress(stub, developer)
+		if err != nil {
+			return nil, err
+		} else if userJSON == nil {
+			return nil, errors.New("This user doesn't exist: " + developer)
+		}
+		return userJSON, nil
+	}
+	var userJSON user
+	err = json.Unmarshal(userAsBytes, &userJSON)
+	if err != nil {
+		return nil, errors.New("Error unmarshal user bytes.")
+	}
+	return &userJSON, nil
+}
+
+// developerAddress returns the address of the developer recorded on a service.
+func developerAddress(stub shim.ChaincodeStubInterface, developer string) (string, error) {
+	userJSON, err := lookupDeveloper(stub, developer)
+	if err != nil {
+		return "", err
+	}
+	return userJSON.Address, nil
+}
+
+// readUser reads the record of user_name.
+func readUser(stub shim.ChaincodeStubInterface, user_name string) (*user, error) {
+	userAsBytes, err := stub.GetState(UserPrefix + user_name)
+	if err != nil {
+		return nil, errors.New("Fail to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return nil, errors.New("This user doesn't exist: " + user_name)
+	}
+	var userJSON user
+	err = json.Unmarshal(userAsBytes, &userJSON)
+	if err != nil {
+		return nil, errors.New("Error unmarshal user bytes.")
+	}
+	return &userJSON, nil
+}
 
+// addDeveloperIndex records that service_name is developed by developer.
+func addDeveloperIndex(stub shim.ChaincodeStubInterface, developer string, service_name string) error {
+	index_key, err := stub.CreateCompositeKey(DeveloperServiceIndex, []string{developer, service_name})
+	if err != nil {
+		return err
+	}
+	// only the key is needed, the value can not be empty
+	return stub.PutState(index_key, []byte{0x00})
+}
+
+// developerServices lists the services and mashups of developer by name.
+func developerServices(stub shim.ChaincodeStubInterface, developer string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(DeveloperServiceIndex, []string{developer})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
 
+	services := []string{}
+	for resultsIterator.HasNext() {
+		indexResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, keyParts[1])
 	}
-	buffer.WriteString("]")
+	return services, nil
+}
+
+// Pagination helpers
+// ==================================================================================
+
+// servicePage is one page of a service listing.
+type servicePage struct {
+	Services	[]service	`json:"services"`
+	// Bookmark is the first entry of the next page, "" on the last page.
+	Bookmark	string		`json:"bookmark"`
+}
 
-	return shim.Success(buffer.Bytes())
+// parsePageSize reads a page size argument, "" means the default one.
+func parsePageSize(arg string) (int, error) {
+	if arg == "" {
+		return DefaultPageSize, nil
+	}
+	page_size, err := strconv.Atoi(arg)
+	if err != nil || page_size <= 0 {
+		return 0, errors.New("Expecting positive integer value for page size.")
+	}
+	if page_size > MaxPageSize {
+		page_size = MaxPageSize
+	}
+	return page_size, nil
+}
 
+// prefixRangeEnd returns the smallest key greater than every key starting with prefix.
+func prefixRangeEnd(prefix string) string {
+	return prefix[:len(prefix)-1] + string(prefix[len(prefix)-1]+1)
 }
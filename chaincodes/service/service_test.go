@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+)
+
+const (
+	addr1 = "07caf88941eafcaaa3370657fccc261acb75dfba"
+	addr2 = "a5ff00eb44bf19d5dfbde501c90e286badb58df4"
+	addr3 = "3c97f146e8de9807ef723538521fcecd5f64c79a"
+	addr4 = "4230a12f5b0693dd88bb35c79d7e56a68614b199"
+
+	tokenType = "INK"
+)
+
+func newServiceStub(t *testing.T) *shimtest.Stub {
+	stub := shimtest.NewStub("service", new(serviceChaincode))
+	if res := stub.InitAs(addr1); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	stub.SetBalance(addr1, tokenType, 1000)
+	stub.SetBalance(addr2, tokenType, 1000)
+	stub.SetBalance(addr3, tokenType, 1000)
+	return stub
+}
+
+// newEcosystemStub registers user1..user3 and the services
+// "Google Maps" (user1), "Twitter" (user2) and "YouTube" (user2).
+func newEcosystemStub(t *testing.T) *shimtest.Stub {
+	stub := newServiceStub(t)
+	mustInvoke(t, stub, addr1, RegisterUser, "user1", "An active service developer.")
+	mustInvoke(t, stub, addr2, RegisterUser, "user2", "Another service developer.")
+	mustInvoke(t, stub, addr3, RegisterUser, "user3", "A mashup builder.")
+	mustInvoke(t, stub, addr1, RegisterService, "Google Maps", "Mapping", "Maps API.", "user1")
+	mustInvoke(t, stub, addr2, RegisterService, "Twitter", "Social", "Tweets API.", "user2")
+	mustInvoke(t, stub, addr2, RegisterService, "YouTube", "Video", "Videos API.", "user2")
+	return stub
+}
+
+func mustInvoke(t *testing.T, stub *shimtest.Stub, sender string, function string, args ...string) []byte {
+	t.Helper()
+	res := stub.InvokeAs(sender, function, args...)
+	if res.Status != shim.OK {
+		t.Fatalf("%s(%s) failed: %s", function, strings.Join(args, ", "), res.Message)
+	}
+	return res.Payload
+}
+
+func mustFail(t *testing.T, stub *shimtest.Stub, sender string, function string, args ...string) string {
+	t.Helper()
+	res := stub.InvokeAs(sender, function, args...)
+	if res.Status == shim.OK {
+		t.Fatalf("%s(%s) succeeded, expected an error", function, strings.Join(args, ", "))
+	}
+	return res.Message
+}
+
+func getUser(t *testing.T, stub *shimtest.Stub, name string) user {
+	t.Helper()
+	var u user
+	if err := json.Unmarshal(mustInvoke(t, stub, addr1, QueryUser, name), &u); err != nil {
+		t.Fatalf("unmarshal user %s: %v", name, err)
+	}
+	return u
+}
+
+func getService(t *testing.T, stub *shimtest.Stub, name string) service {
+	t.Helper()
+	var s service
+	if err := json.Unmarshal(mustInvoke(t, stub, addr1, QueryService, name), &s); err != nil {
+		t.Fatalf("unmarshal service %s: %v", name, err)
+	}
+	return s
+}
+
+func balance(stub *shimtest.Stub, address string) string {
+	b := stub.Balance(address, tokenType)
+	if b == nil {
+		return "<nil>"
+	}
+	return b.String()
+}
+
+func TestRegisterUser(t *testing.T) {
+	stub := newServiceStub(t)
+
+	mustInvoke(t, stub, addr1, RegisterUser, "user1", "An active service developer.")
+	u := getUser(t, stub, "user1")
+	if u.Name != "user1" || u.Address != addr1 || u.Introduction != "An active service developer." {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+
+	mustFail(t, stub, addr2, RegisterUser, "user1", "Taken name.")
+	mustFail(t, stub, addr2, RegisterUser, "user2")
+	mustFail(t, stub, "", RegisterUser, "user2", "No sender.")
+	// an address registers one user
+	mustFail(t, stub, addr1, RegisterUser, "user2", "Second name.")
+}
+
+func TestQueryUserByAddress(t *testing.T) {
+	stub := newServiceStub(t)
+	mustInvoke(t, stub, addr1, RegisterUser, "user1", "intro")
+
+	var u user
+	payload := mustInvoke(t, stub, addr2, QueryUserByAddress, strings.ToUpper(addr1))
+	if err := json.Unmarshal(payload, &u); err != nil || u.Name != "user1" || u.Address != addr1 {
+		t.Fatalf("user of addr1 = %s", payload)
+	}
+	mustFail(t, stub, addr1, QueryUserByAddress, addr2)
+	mustFail(t, stub, addr1, QueryUserByAddress)
+
+	// a removed user frees its address
+	mustInvoke(t, stub, addr1, RemoveUser, "user1")
+	mustFail(t, stub, addr1, QueryUserByAddress, addr1)
+	mustInvoke(t, stub, addr1, RegisterUser, "user2", "intro")
+	payload = mustInvoke(t, stub, addr1, QueryUserByAddress, addr1)
+	if err := json.Unmarshal(payload, &u); err != nil || u.Name != "user2" {
+		t.Fatalf("user of addr1 = %s", payload)
+	}
+}
+
+func TestMixedCaseSender(t *testing.T) {
+	stub := newServiceStub(t)
+	upper := strings.ToUpper(addr4)
+
+	// the address is stored lowercased and compared whichever way it comes
+	mustInvoke(t, stub, upper, RegisterUser, "user4", "intro")
+	if u := getUser(t, stub, "user4"); u.Address != addr4 {
+		t.Fatalf("address of user4 = %s", u.Address)
+	}
+	mustFail(t, stub, addr4, RegisterUser, "user5", "Second name.")
+	mustInvoke(t, stub, addr4, RegisterService, "Google Maps", "Mapping", "Maps API.", "user4")
+	mustInvoke(t, stub, upper, EditService, "Google Maps", "Description", "Maps and places API.")
+
+	// an admin granted in upper case is known to its lowercase sender
+	mustInvoke(t, stub, addr1, GrantRole, R_Admin, strings.ToUpper(addr2))
+	mustInvoke(t, stub, addr2, AuthorizeGateway, upper)
+	if key, _ := stub.CreateCompositeKey(GatewayIndex, []string{addr4}); stub.State[key] == nil {
+		t.Fatalf("gateway %s not authorized", addr4)
+	}
+}
+
+func TestMashupDeveloper(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+
+	// mashups record the name of their creator, who must be registered
+	mustInvoke(t, stub, addr3, CreateMashup, "SocialMap", "Mapping", "Tweets on a map.", "Google Maps", "Twitter")
+	if s := getService(t, stub, "SocialMap"); s.Developer != "user3" {
+		t.Fatalf("mashup developer = %s", s.Developer)
+	}
+	if got := serviceNames(queryPage(t, stub, QueryServiceByUser, "user3")); got != "SocialMap" {
+		t.Fatalf("services of user3 = %s", got)
+	}
+	mustFail(t, stub, addr4, CreateMashup, "MapTweets", "Mapping", "Tweets on a map.", "Google Maps", "Twitter")
+
+	// mashups of earlier versions recorded an address, it resolves to the user
+	putMashupBy(t, stub, addr3, "MapVideos", "Google Maps", "YouTube")
+	mustInvoke(t, stub, addr3, EditService, "MapVideos", "Description", "Videos on a map.")
+	mustFail(t, stub, addr2, EditService, "MapVideos", "Description", "Stolen.")
+	mustInvoke(t, stub, addr3, PublishService, "MapVideos")
+	mustFail(t, stub, addr3, RateService, "MapVideos", "5")
+	want := stub.Balance(addr3, tokenType).Int64() + 10
+	mustInvoke(t, stub, addr1, RewardService, "MapVideos", tokenType, "10")
+	if got := stub.Balance(addr3, tokenType).Int64(); got != want {
+		t.Fatalf("balance of user3 = %d, want %d", got, want)
+	}
+}
+
+func TestRemoveUser(t *testing.T) {
+	stub := newServiceStub(t)
+	mustInvoke(t, stub, addr1, RegisterUser, "user1", "intro")
+	mustInvoke(t, stub, addr2, RegisterUser, "user2", "intro")
+
+	// only the user or an admin removes a user
+	mustInvoke(t, stub, addr3, RegisterUser, "user3", "intro")
+	mustFail(t, stub, addr2, RemoveUser, "user3")
+	mustInvoke(t, stub, addr3, RemoveUser, "user3")
+	mustInvoke(t, stub, addr1, RemoveUser, "user2")
+
+	// the user stays as a tombstone and its name is not reused
+	if u := getUser(t, stub, "user3"); !u.Removed || u.RemovedTime == "" || u.Introduction != "" || u.Address != addr3 {
+		t.Fatalf("tombstone = %+v", u)
+	}
+	mustFail(t, stub, addr4, RegisterUser, "user3", "Taken name.")
+	mustFail(t, stub, addr3, RemoveUser, "user3")
+	mustFail(t, stub, addr1, RemoveUser, "user1", "transfer")
+	mustFail(t, stub, addr1, RemoveUser)
+}
+
+func TestRemoveUserServices(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	putMashupBy(t, stub, "user3", "SocialMap", "Google Maps", "Twitter")
+
+	// a developer is not removed with its services by default
+	mustFail(t, stub, addr2, RemoveUser, "user2")
+	mustInvoke(t, stub, addr2, RemoveUser, "user2", RemoveOrphan)
+	for _, name := range []string{"Twitter", "YouTube"} {
+		if s := getService(t, stub, name); !s.Orphaned || s.Developer != "user2" {
+			t.Fatalf("orphaned service = %+v", s)
+		}
+	}
+
+	// nobody develops the orphaned services any more, moderators still can act on them
+	mustFail(t, stub, addr2, EditService, "Twitter", "Description", "Mine again.")
+	mustFail(t, stub, addr2, RegisterService, "Flickr", "Photo", "Photos API.", "user2")
+	mustFail(t, stub, addr1, RewardService, "Twitter", tokenType, "10")
+	mustInvoke(t, stub, addr1, InvalidateService, "YouTube", "The developer left.")
+
+	// mashups can still compose them, the removed developer is not credited
+	contribution := getUser(t, stub, "user2").Contribution
+	mustInvoke(t, stub, addr3, CreateMashup, "SocialVideos", "Video", "Shared videos.", "Twitter", "Google Maps")
+	if u := getUser(t, stub, "user2"); u.Contribution != contribution {
+		t.Fatalf("removed user credited: %+v", u)
+	}
+	if got := getLeaderboard(t, stub); strings.Contains(got, "user2") {
+		t.Fatalf("leaderboard = %s", got)
+	}
+}
+
+func TestQueryUser(t *testing.T) {
+	stub := newServiceStub(t)
+
+	mustFail(t, stub, addr1, QueryUser, "nobody")
+	mustFail(t, stub, addr1, QueryUser)
+}
+
+func TestRegisterService(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	s := getService(t, stub, "Google Maps")
+	if s.Developer != "user1" || s.Type != "Mapping" || s.Status != S_Created || s.IsMashup {
+		t.Fatalf("unexpected service: %+v", s)
+	}
+
+	// the sender must be the named user
+	mustFail(t, stub, addr2, RegisterService, "Flickr", "Photos", "Photos API.", "user1")
+	// the user must exist
+	mustFail(t, stub, addr1, RegisterService, "Flickr", "Photos", "Photos API.", "nobody")
+	// the name must be free
+	mustFail(t, stub, addr1, RegisterService, "Google Maps", "Mapping", "Again.", "user1")
+	mustFail(t, stub, addr1, RegisterService, "Flickr", "Photos", "Photos API.")
+}
+
+func TestPublishService(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	mustFail(t, stub, addr2, PublishService, "Google Maps")
+	mustInvoke(t, stub, addr1, PublishService, "Google Maps")
+	if s := getService(t, stub, "Google Maps"); s.Status != S_Available {
+		t.Fatalf("status = %s, want %s", s.Status, S_Available)
+	}
+	mustFail(t, stub, addr1, PublishService, "Flickr")
+	mustFail(t, stub, addr1, PublishService)
+}
+
+func TestInvalidateService(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	mustFail(t, stub, addr2, InvalidateService, "Google Maps", "Spam.")
+	mustInvoke(t, stub, addr1, InvalidateService, "Google Maps", "Shut down.")
+	if s := getService(t, stub, "Google Maps"); s.Status != S_Invalid {
+		t.Fatalf("status = %s, want %s", s.Status, S_Invalid)
+	}
+	mustFail(t, stub, addr1, InvalidateService, "Flickr", "Unknown.")
+	mustFail(t, stub, addr1, InvalidateService, "Google Maps")
+}
+
+func TestQueryService(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	mustFail(t, stub, addr1, QueryService, "Flickr")
+	mustFail(t, stub, addr1, QueryService)
+}
+
+func TestEditService(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	mustInvoke(t, stub, addr1, EditService, "Google Maps", "Type", "Maps")
+	mustInvoke(t, stub, addr1, EditService, "Google Maps", "Description", "New description.")
+	s := getService(t, stub, "Google Maps")
+	if s.Type != "Maps" || s.Description != "New description." || s.UpdatedTime == "" {
+		t.Fatalf("unexpected service after edit: %+v", s)
+	}
+
+	mustFail(t, stub, addr1, EditService, "Google Maps", "Developer", "user2")
+	mustFail(t, stub, addr2, EditService, "Google Maps", "Type", "Social")
+	mustFail(t, stub, addr1, EditService, "Flickr", "Type", "Photos")
+	mustFail(t, stub, addr1, EditService, "Google Maps", "Type")
+}
+
+func TestServiceTimesAreTransactionTimes(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	// every peer stamps a record with the time of the transaction
+	created := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	stub.SetTime(created)
+	mustInvoke(t, stub, addr1, RegisterServicesBatch, "user1", `[{"name":"Bing Maps","type":"Mapping"}]`)
+	mustInvoke(t, stub, addr3, CreateMashup, "TweetMap", "Mapping", "Tweets on a map.", "Google Maps", "Twitter")
+	stub.Advance(time.Hour)
+	mustInvoke(t, stub, addr1, EditService, "Bing Maps", "Description", "Maps API.")
+
+	if s := getService(t, stub, "Bing Maps"); s.CreatedTime != created.Format(time.UnixDate) ||
+		s.UpdatedTime != created.Add(time.Hour).Format(time.UnixDate) {
+		t.Fatalf("service = %+v", s)
+	}
+	if s := getService(t, stub, "TweetMap"); s.CreatedTime != created.Format(time.UnixDate) {
+		t.Fatalf("mashup = %+v", s)
+	}
+}
+
+func TestCreateMashup(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	mustInvoke(t, stub, addr3, CreateMashup, "MapTweets", "Mapping", "Tweets on a map.",
+		"Google Maps", "Twitter", "YouTube")
+	m := getService(t, stub, "MapTweets")
+	if !m.IsMashup || m.Status != S_Created || len(m.Composition) != 3 {
+		t.Fatalf("unexpected mashup: %+v", m)
+	}
+	// one fee per distinct developer
+	if got := balance(stub, addr3); got != "980" {
+		t.Fatalf("mashup developer balance = %s, want 980", got)
+	}
+	if got := balance(stub, addr1); got != "1010" {
+		t.Fatalf("user1 balance = %s, want 1010", got)
+	}
+	if got := balance(stub, addr2); got != "1010" {
+		t.Fatalf("user2 balance = %s, want 1010", got)
+	}
+
+	mustFail(t, stub, addr3, CreateMashup, "MapTweets", "Mapping", "Again.", "Google Maps")
+	mustFail(t, stub, addr3, CreateMashup, "MapPhotos", "Mapping", "Missing component.", "Google Maps", "Flickr")
+	mustFail(t, stub, addr3, CreateMashup, "Empty", "Mapping", "No components.")
+
+	// a failed payment rolls the whole mashup back
+	stub.SetBalance(addr3, tokenType, 5)
+	mustFail(t, stub, addr3, CreateMashup, "MapVideos", "Mapping", "Videos on a map.", "Google Maps", "YouTube")
+	mustFail(t, stub, addr1, QueryService, "MapVideos")
+	if got := balance(stub, addr1); got != "1010" {
+		t.Fatalf("user1 balance after failed mashup = %s, want 1010", got)
+	}
+}
+
+func queryPage(t *testing.T, stub *shimtest.Stub, function string, args ...string) servicePage {
+	t.Helper()
+	var page servicePage
+	payload := mustInvoke(t, stub, addr1, function, args...)
+	if err := json.Unmarshal(payload, &page); err != nil {
+		t.Fatalf("unmarshal page %s: %v", payload, err)
+	}
+	return page
+}
+
+func serviceNames(page servicePage) string {
+	names := make([]string, len(page.Services))
+	for i, s := range page.Services {
+		names[i] = s.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestQueryServiceByUser(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, RegisterService, "Flickr", "Photos", "Photos API.", "user2")
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	mustInvoke(t, stub, addr2, CreateMashup, "SocialVideos", "Social", "Videos of tweets.", "Twitter", "YouTube")
+
+	page := queryPage(t, stub, QueryServiceByUser, "user2")
+	if got := serviceNames(page); got != "Flickr,SocialVideos,Twitter,YouTube" || page.Bookmark != "" {
+		t.Fatalf("services of user2 = %s (bookmark %q)", got, page.Bookmark)
+	}
+	if got := serviceNames(queryPage(t, stub, QueryServiceByUser, "user1")); got != "Google Maps" {
+		t.Fatalf("services of user1 = %s", got)
+	}
+	if got := serviceNames(queryPage(t, stub, QueryServiceByUser, "user3")); got != "" {
+		t.Fatalf("services of user3 = %s", got)
+	}
+
+	// filters
+	if got := serviceNames(queryPage(t, stub, QueryServiceByUser, "user2", S_Available)); got != "Twitter" {
+		t.Fatalf("available services of user2 = %s", got)
+	}
+	if got := serviceNames(queryPage(t, stub, QueryServiceByUser, "user2", "", KindMashup)); got != "SocialVideos" {
+		t.Fatalf("mashups of user2 = %s", got)
+	}
+	if got := serviceNames(queryPage(t, stub, QueryServiceByUser, "user2", S_Created, KindService)); got != "Flickr,YouTube" {
+		t.Fatalf("created plain services of user2 = %s", got)
+	}
+
+	// pagination
+	page = queryPage(t, stub, QueryServiceByUser, "user2", "", "", "3")
+	if got := serviceNames(page); got != "Flickr,SocialVideos,Twitter" || page.Bookmark != "YouTube" {
+		t.Fatalf("first page = %s (bookmark %q)", got, page.Bookmark)
+	}
+	page = queryPage(t, stub, QueryServiceByUser, "user2", "", "", "3", page.Bookmark)
+	if got := serviceNames(page); got != "YouTube" || page.Bookmark != "" {
+		t.Fatalf("second page = %s (bookmark %q)", got, page.Bookmark)
+	}
+
+	mustFail(t, stub, addr1, QueryServiceByUser, "user2", "deleted")
+	mustFail(t, stub, addr1, QueryServiceByUser, "user2", "", "plugin")
+	mustFail(t, stub, addr1, QueryServiceByUser, "user2", "", "", "0")
+	mustFail(t, stub, addr1, QueryServiceByUser)
+}
+
+func TestRewardService(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+
+	mustInvoke(t, stub, addr3, RewardService, "Twitter", tokenType, "25")
+	if got := balance(stub, addr2); got != "1025" {
+		t.Fatalf("developer balance = %s, want 1025", got)
+	}
+	if got := balance(stub, addr3); got != "975" {
+		t.Fatalf("rewarder balance = %s, want 975", got)
+	}
+
+	mustFail(t, stub, addr3, RewardService, "Twitter", tokenType, "many")
+	mustFail(t, stub, addr3, RewardService, "Twitter", tokenType, "5000")
+	mustFail(t, stub, addr3, RewardService, "Flickr", tokenType, "1")
+	mustFail(t, stub, addr3, RewardService, "Twitter", tokenType)
+	mustFail(t, stub, addr3, RewardService, "Twitter", tokenType, "0")
+	// only available services are rewarded
+	mustFail(t, stub, addr3, RewardService, "YouTube", tokenType, "1")
+}
+
+func TestQueryServiceByRange(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, RegisterService, "Flickr", "Photos", "Photos API.", "user2")
+
+	// only services are listed, never users or index entries
+	page := queryPage(t, stub, QueryServiceByRange, "", "")
+	if got := serviceNames(page); got != "Flickr,Google Maps,Twitter,YouTube" || page.Bookmark != "" {
+		t.Fatalf("all services = %s (bookmark %q)", got, page.Bookmark)
+	}
+	if got := serviceNames(queryPage(t, stub, QueryServiceByRange, "G", "U")); got != "Google Maps,Twitter" {
+		t.Fatalf("services in [G, U) = %s", got)
+	}
+	if got := serviceNames(queryPage(t, stub, QueryServiceByRange, "Twitter", "")); got != "Twitter,YouTube" {
+		t.Fatalf("services from Twitter = %s", got)
+	}
+
+	// walk the catalog page by page
+	var walked []string
+	bookmark := ""
+	for {
+		page = queryPage(t, stub, QueryServiceByRange, "", "", "3", bookmark)
+		walked = append(walked, serviceNames(page))
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+	if got := strings.Join(walked, "|"); got != "Flickr,Google Maps,Twitter|YouTube" {
+		t.Fatalf("pages = %s", got)
+	}
+
+	mustFail(t, stub, addr1, QueryServiceByRange, "G", "U", "2", "Apple")
+	mustFail(t, stub, addr1, QueryServiceByRange, "", "", "many")
+	mustFail(t, stub, addr1, QueryServiceByRange, "")
+}
+
+func TestInvalidFunction(t *testing.T) {
+	stub := newServiceStub(t)
+
+	mustFail(t, stub, addr1, "deleteEverything")
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Service subscriptions
+// ==================================================================================
+//
+// Developers offer subscription plans on their services: a price, a duration
+// and a call quota (0: unlimited). A consumer buys a plan with subscribe,
+// which transfers the price to the members of the service by share (see
+// maintainers.go); the subscription runs until
+// the transaction time plus the plan's duration. renewSubscription buys one
+// more term: an active subscription is extended and its quota grows, an
+// expired one starts over. cancelSubscription ends a subscription at once,
+// prepaid terms are not refunded.
+//
+// A consumer has at most one subscription per service. recordUsage counts
+// the calls of a period against the quota when the subscription's term was
+// running during that period, up to the transaction time, and only charges
+// the calls beyond it. A late batch for a past period is thus covered by the
+// term that ran then, as long as it is the subscription's current term.
+
+// Status of a subscription, expiry is computed from the transaction time
+const (
+	SubActive    = "active"
+	SubExpired   = "expired"
+	SubCancelled = "cancelled"
+)
+
+// Structure definition for a subscription plan
+type subscriptionPlan struct {
+	Service   string `json:"service"`
+	Plan      string `json:"plan"`
+	TokenType string `json:"tokenType"`
+	Price     string `json:"price"`
+	Duration  string `json:"duration"` // e.g. "720h"
+	Quota     int    `json:"quota"`    // calls per term, 0 for unlimited
+}
+
+// Structure definition for a consumer's subscription to a service
+type subscription struct {
+	Service    string `json:"service"`
+	Consumer   string `json:"consumer"` // address of the consumer
+	Plan       string `json:"plan"`
+	TokenType  string `json:"tokenType"`
+	Paid       string `json:"paid"` // total paid over all terms
+	Quota      int    `json:"quota"`
+	Used       int    `json:"used"`
+	StartTime  string `json:"startTime"`
+	Expiry     string `json:"expiry"`
+	Status     string `json:"status"`
+	CancelTime string `json:"cancelTime,omitempty"`
+}
+
+// Structure definition for the response of checkEntitlement
+type entitlement struct {
+	Entitled  bool   `json:"entitled"`
+	Expiry    string `json:"expiry,omitempty"`
+	Remaining int    `json:"remaining"` // calls left, -1 for unlimited
+}
+
+// status computes the subscription's status at now.
+func (s *subscription) status(now time.Time) string {
+	if s.Status == SubCancelled {
+		return SubCancelled
+	}
+	expiry, err := time.Parse(time.UnixDate, s.Expiry)
+	if err != nil || !now.Before(expiry) {
+		return SubExpired
+	}
+	return SubActive
+}
+
+// remaining computes the calls left at now, -1 for unlimited.
+func (s *subscription) remaining(now time.Time) int {
+	if s.status(now) != SubActive {
+		return 0
+	}
+	if s.Quota == 0 {
+		return -1
+	}
+	if s.Used >= s.Quota {
+		return 0
+	}
+	return s.Quota - s.Used
+}
+
+// activeDuring tells whether the subscription's term ran at some time in
+// [from, to): it started before to, and neither expired nor was cancelled
+// by from.
+func (s *subscription) activeDuring(from time.Time, to time.Time) bool {
+	start, err := time.Parse(time.UnixDate, s.StartTime)
+	if err != nil || !start.Before(to) {
+		return false
+	}
+	end, err := time.Parse(time.UnixDate, s.Expiry)
+	if err != nil {
+		return false
+	}
+	if s.Status == SubCancelled {
+		cancelled, err := time.Parse(time.UnixDate, s.CancelTime)
+		if err != nil {
+			return false
+		}
+		if cancelled.Before(end) {
+			end = cancelled
+		}
+	}
+	return from.Before(end)
+}
+
+// subscriptionKey builds the key of a consumer's subscription to a service.
+func subscriptionKey(stub shim.ChaincodeStubInterface, service_name string, consumer string) (string, error) {
+	return stub.CreateCompositeKey(SubscriptionIndex, []string{service_name, consumer})
+}
+
+// getSubscription reads a consumer's subscription to a service, nil if none.
+func getSubscription(stub shim.ChaincodeStubInterface, service_name string, consumer string) (*subscription, error) {
+	subscription_key, err := subscriptionKey(stub, service_name, consumer)
+	if err != nil {
+		return nil, err
+	}
+	subscriptionAsBytes, err := stub.GetState(subscription_key)
+	if err != nil {
+		return nil, errors.New("Fail to get subscription: " + err.Error())
+	} else if subscriptionAsBytes == nil {
+		return nil, nil
+	}
+	var subscriptionJSON subscription
+	err = json.Unmarshal(subscriptionAsBytes, &subscriptionJSON)
+	if err != nil {
+		return nil, errors.New("Error unmarshal subscription bytes.")
+	}
+	return &subscriptionJSON, nil
+}
+
+// putSubscription stores a subscription.
+func putSubscription(stub shim.ChaincodeStubInterface, subscriptionJSON *subscription) error {
+	subscription_key, err := subscriptionKey(stub, subscriptionJSON.Service, subscriptionJSON.Consumer)
+	if err != nil {
+		return err
+	}
+	subscriptionAsBytes, err := json.Marshal(subscriptionJSON)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(subscription_key, subscriptionAsBytes)
+}
+
+// getPlan reads an offered plan of a service.
+func getPlan(stub shim.ChaincodeStubInterface, service_name string, plan_name string) (*subscriptionPlan, error) {
+	plan_key, err := stub.CreateCompositeKey(PlanIndex, []string{service_name, plan_name})
+	if err != nil {
+		return nil, err
+	}
+	planAsBytes, err := stub.GetState(plan_key)
+	if err != nil {
+		return nil, errors.New("Fail to get plan: " + err.Error())
+	} else if planAsBytes == nil {
+		return nil, errors.New("This plan is not offered: " + service_name + "/" + plan_name)
+	}
+	var planJSON subscriptionPlan
+	err = json.Unmarshal(planAsBytes, &planJSON)
+	if err != nil {
+		return nil, errors.New("Error unmarshal plan bytes.")
+	}
+	return &planJSON, nil
+}
+
+// payPlan transfers the price of a plan from the sender to the members of
+// the service, by share.
+func payPlan(stub shim.ChaincodeStubInterface, serviceJSON *service, planJSON *subscriptionPlan) error {
+	price, _ := big.NewInt(0).SetString(planJSON.Price, 10)
+	if price.Sign() == 0 {
+		return nil
+	}
+	split, err := splitShares(stub, serviceJSON, price)
+	if err != nil {
+		return err
+	}
+	for _, part := range split {
+		address, err := developerAddress(stub, part.Developer)
+		if err != nil {
+			return err
+		}
+		amount, _ := big.NewInt(0).SetString(part.Amount, 10)
+		err = stub.Transfer(address, planJSON.TokenType, amount)
+		if err != nil {
+			return errors.New("Error when making transfer.")
+		}
+	}
+	return nil
+}
+
+// addAmount adds two decimal amounts.
+func addAmount(a string, b string) string {
+	x, _ := big.NewInt(0).SetString(a, 10)
+	y, _ := big.NewInt(0).SetString(b, 10)
+	return x.Add(x, y).String()
+}
+
+// planEvent describes a plan in events.
+func planEvent(planJSON *subscriptionPlan) events.Plan {
+	return events.Plan{Service: planJSON.Service, Plan: planJSON.Plan, TokenType: planJSON.TokenType,
+		Price: planJSON.Price, Duration: planJSON.Duration, Quota: planJSON.Quota}
+}
+
+// emitSubscription emits the event of a subscription changed by a
+// transaction that paid paid.
+func emitSubscription(stub shim.ChaincodeStubInterface, name string, serviceJSON *service, subscriptionJSON *subscription, paid string) error {
+	subscription_key, err := subscriptionKey(stub, subscriptionJSON.Service, subscriptionJSON.Consumer)
+	if err != nil {
+		return err
+	}
+	return emitEvent(stub, name, []string{ServicePrefix + serviceJSON.Name, subscription_key}, serviceJSON.Status, serviceJSON.Status,
+		events.Subscription{Service: subscriptionJSON.Service, Consumer: subscriptionJSON.Consumer, Plan: subscriptionJSON.Plan,
+			TokenType: subscriptionJSON.TokenType, Paid: paid, Expiry: subscriptionJSON.Expiry, Status: subscriptionJSON.Status})
+}
+
+// subscriptionCover counts the calls of a usage batch against the
+// subscriptions running in the batch's period, every subscription is read
+// and written once.
+type subscriptionCover struct {
+	from          time.Time // start of the period
+	to            time.Time // end of the period, just after the transaction time at the latest
+	subscriptions map[string]*subscription
+}
+
+func newSubscriptionCover(stub shim.ChaincodeStubInterface, period_start time.Time) (*subscriptionCover, error) {
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	// calls are not recorded ahead of time, a term starting later covers none
+	period_end := period_start.AddDate(0, 1, 0)
+	if now.Before(period_end) {
+		period_end = now.Add(time.Nanosecond)
+	}
+	return &subscriptionCover{period_start, period_end, make(map[string]*subscription)}, nil
+}
+
+// cover returns how many of a consumer's calls of a service its
+// subscription covers, and counts them as used.
+func (c *subscriptionCover) cover(stub shim.ChaincodeStubInterface, service_name string, consumer string, calls int) (int, error) {
+	subscription_key, err := subscriptionKey(stub, service_name, consumer)
+	if err != nil {
+		return 0, err
+	}
+	subscriptionJSON, ok := c.subscriptions[subscription_key]
+	if !ok {
+		subscriptionJSON, err = getSubscription(stub, service_name, consumer)
+		if err != nil {
+			return 0, err
+		}
+		c.subscriptions[subscription_key] = subscriptionJSON
+	}
+	if subscriptionJSON == nil || !subscriptionJSON.activeDuring(c.from, c.to) {
+		return 0, nil
+	}
+
+	covered := calls
+	if subscriptionJSON.Quota > 0 {
+		left := subscriptionJSON.Quota - subscriptionJSON.Used
+		if left < 0 {
+			left = 0
+		}
+		if covered > left {
+			covered = left
+		}
+	}
+	subscriptionJSON.Used += covered
+	return covered, nil
+}
+
+// store writes the subscriptions that covered calls.
+func (c *subscriptionCover) store(stub shim.ChaincodeStubInterface) error {
+	keys := make([]string, 0, len(c.subscriptions))
+	for key, subscriptionJSON := range c.subscriptions {
+		if subscriptionJSON != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := putSubscription(stub, c.subscriptions[key])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ===============================================================
+// setSubscriptionPlan: offer or update a subscription plan
+// ===============================================================
+func (t *serviceChaincode) setSubscriptionPlan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var err error
+
+	planJSON := &subscriptionPlan{Service: args[0], Plan: args[1], TokenType: args[2]}
+	if planJSON.Plan == "" || planJSON.TokenType == "" {
+		return shim.Error("The plan name and token type can not be empty.")
+	}
+	price, err := parseAmount("the price", args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	planJSON.Price = price.String()
+	duration, err := time.ParseDuration(args[4])
+	if err != nil || duration <= 0 {
+		return shim.Error("Expecting a positive duration like 720h: " + args[4])
+	}
+	planJSON.Duration = duration.String()
+	planJSON.Quota, err = strconv.Atoi(args[5])
+	if err != nil || planJSON.Quota < 0 {
+		return shim.Error("Expecting non-negative integer value for the quota.")
+	}
+
+	// STEP 0: only the developer offers plans
+	serviceJSON, err := readService(stub, planJSON.Service)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = authorize(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: store the plan
+	plan_key, err := stub.CreateCompositeKey(PlanIndex, []string{planJSON.Service, planJSON.Plan})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	planAsBytes, err := json.Marshal(planJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(plan_key, planAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, SetSubscriptionPlan, []string{ServicePrefix + planJSON.Service, plan_key},
+		serviceJSON.Status, serviceJSON.Status, planEvent(planJSON))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(planAsBytes)
+}
+
+// ===============================================================
+// removeSubscriptionPlan: stop offering a plan, running
+// subscriptions keep their terms
+// ===============================================================
+func (t *serviceChaincode) removeSubscriptionPlan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var plan_name string
+
+	service_name = args[0]
+	plan_name = args[1]
+
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = authorize(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	planJSON, err := getPlan(stub, service_name, plan_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	plan_key, err := stub.CreateCompositeKey(PlanIndex, []string{service_name, plan_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.DelState(plan_key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, RemoveSubscriptionPlan, []string{ServicePrefix + service_name, plan_key},
+		serviceJSON.Status, serviceJSON.Status, planEvent(planJSON))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Remove plan success."))
+}
+
+// ===============================================================
+// queryPlans: query the plans offered on a service
+// ===============================================================
+func (t *serviceChaincode) queryPlans(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(PlanIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	plans := []subscriptionPlan{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var planJSON subscriptionPlan
+		err = json.Unmarshal(queryResponse.Value, &planJSON)
+		if err != nil {
+			return shim.Error("Error unmarshal plan bytes.")
+		}
+		plans = append(plans, planJSON)
+	}
+
+	plansAsBytes, err := json.Marshal(plans)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(plansAsBytes)
+}
+
+// ===============================================================
+// subscribe: buy a plan of a service
+// ===============================================================
+func (t *serviceChaincode) subscribe(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var plan_name string
+
+	service_name = args[0]
+	plan_name = args[1]
+
+	consumer, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: only available services can be subscribed to
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if serviceJSON.Status != S_Available {
+		return shim.Error("This service is not available: " + service_name)
+	}
+	planJSON, err := getPlan(stub, service_name, plan_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: one running subscription per service
+	subscriptionJSON, err := getSubscription(stub, service_name, consumer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if subscriptionJSON != nil && subscriptionJSON.status(now) == SubActive {
+		return shim.Error("Already subscribed to this service, renew the subscription instead: " + service_name)
+	}
+
+	// STEP 2: pay and start the subscription
+	err = payPlan(stub, serviceJSON, planJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	duration, _ := time.ParseDuration(planJSON.Duration)
+	subscriptionJSON = &subscription{service_name, consumer, plan_name, planJSON.TokenType, planJSON.Price,
+		planJSON.Quota, 0, now.Format(time.UnixDate), now.Add(duration).Format(time.UnixDate), SubActive, ""}
+	err = putSubscription(stub, subscriptionJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitSubscription(stub, Subscribe, serviceJSON, subscriptionJSON, planJSON.Price)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	subscriptionAsBytes, err := json.Marshal(subscriptionJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(subscriptionAsBytes)
+}
+
+// ===============================================================
+// renewSubscription: buy one more term of a subscription
+// ===============================================================
+func (t *serviceChaincode) renewSubscription(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+
+	service_name = args[0]
+
+	consumer, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: get the subscription and the plan to renew with
+	subscriptionJSON, err := getSubscription(stub, service_name, consumer)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if subscriptionJSON == nil || subscriptionJSON.Status == SubCancelled {
+		return shim.Error("No subscription to renew: " + service_name)
+	}
+	plan_name := subscriptionJSON.Plan
+	if len(args) > 1 && args[1] != "" {
+		plan_name = args[1]
+	}
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if serviceJSON.Status != S_Available {
+		return shim.Error("This service is not available: " + service_name)
+	}
+	planJSON, err := getPlan(stub, service_name, plan_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: pay and add the term
+	err = payPlan(stub, serviceJSON, planJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	duration, _ := time.ParseDuration(planJSON.Duration)
+	if subscriptionJSON.status(now) == SubActive {
+		expiry, _ := time.Parse(time.UnixDate, subscriptionJSON.Expiry)
+		subscriptionJSON.Expiry = expiry.Add(duration).Format(time.UnixDate)
+		if subscriptionJSON.Quota == 0 || planJSON.Quota == 0 {
+			subscriptionJSON.Quota = 0
+		} else {
+			subscriptionJSON.Quota += planJSON.Quota
+		}
+	} else {
+		subscriptionJSON.StartTime = now.Format(time.UnixDate)
+		subscriptionJSON.Expiry = now.Add(duration).Format(time.UnixDate)
+		subscriptionJSON.Quota = planJSON.Quota
+		subscriptionJSON.Used = 0
+	}
+	subscriptionJSON.Plan = plan_name
+	subscriptionJSON.TokenType = planJSON.TokenType
+	subscriptionJSON.Paid = addAmount(subscriptionJSON.Paid, planJSON.Price)
+	subscriptionJSON.Status = SubActive
+	err = putSubscription(stub, subscriptionJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitSubscription(stub, RenewSubscription, serviceJSON, subscriptionJSON, planJSON.Price)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	subscriptionAsBytes, err := json.Marshal(subscriptionJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(subscriptionAsBytes)
+}
+
+// ===============================================================
+// cancelSubscription: end the sender's subscription to a service
+// ===============================================================
+func (t *serviceChaincode) cancelSubscription(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+
+	service_name = args[0]
+
+	consumer, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	subscriptionJSON, err := getSubscription(stub, service_name, consumer)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if subscriptionJSON == nil || subscriptionJSON.status(now) != SubActive {
+		return shim.Error("No active subscription to cancel: " + service_name)
+	}
+
+	subscriptionJSON.Status = SubCancelled
+	subscriptionJSON.CancelTime = now.Format(time.UnixDate)
+	err = putSubscription(stub, subscriptionJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = emitSubscription(stub, CancelSubscription, serviceJSON, subscriptionJSON, "0")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte("Cancel subscription success."))
+}
+
+// ===============================================================
+// querySubscription: query a consumer's subscription to a service
+// ===============================================================
+func (t *serviceChaincode) querySubscription(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	subscriptionJSON, err := getSubscription(stub, args[0], normalizeAddress(args[1]))
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if subscriptionJSON == nil {
+		return shim.Error("No subscription of " + args[1] + " to: " + args[0])
+	}
+
+	// report the status at the transaction time
+	subscriptionJSON.Status = subscriptionJSON.status(now)
+	subscriptionAsBytes, err := json.Marshal(subscriptionJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(subscriptionAsBytes)
+}
+
+// ===============================================================
+// checkEntitlement: tell whether a consumer may call a service now,
+// reading nothing but the subscription
+// ===============================================================
+func (t *serviceChaincode) checkEntitlement(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	subscriptionJSON, err := getSubscription(stub, args[0], normalizeAddress(args[1]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	result := entitlement{}
+	if subscriptionJSON != nil {
+		remaining := subscriptionJSON.remaining(now)
+		result = entitlement{remaining != 0, subscriptionJSON.Expiry, remaining}
+	}
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
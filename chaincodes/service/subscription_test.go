@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+)
+
+const month = 30 * 24 * time.Hour
+
+// newSubscriptionStub is newEcosystemStub with Twitter published and
+// offering a "basic" plan of 100 INK for 30 days and 1000 calls.
+func newSubscriptionStub(t *testing.T) *shimtest.Stub {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	mustInvoke(t, stub, addr2, SetSubscriptionPlan, "Twitter", "basic", tokenType, "100", "720h", "1000")
+	return stub
+}
+
+func querySub(t *testing.T, stub *shimtest.Stub, service string, consumer string) subscription {
+	t.Helper()
+	var s subscription
+	payload := mustInvoke(t, stub, addr1, QuerySubscription, service, consumer)
+	if err := json.Unmarshal(payload, &s); err != nil {
+		t.Fatalf("unmarshal subscription %s: %v", payload, err)
+	}
+	return s
+}
+
+func getEntitlement(t *testing.T, stub *shimtest.Stub, service string, consumer string) entitlement {
+	t.Helper()
+	var e entitlement
+	payload := mustInvoke(t, stub, gateway, CheckEntitlement, service, consumer)
+	if err := json.Unmarshal(payload, &e); err != nil {
+		t.Fatalf("unmarshal entitlement %s: %v", payload, err)
+	}
+	return e
+}
+
+func TestSubscriptionPlans(t *testing.T) {
+	stub := newSubscriptionStub(t)
+	mustInvoke(t, stub, addr2, SetSubscriptionPlan, "Twitter", "pro", tokenType, "500", "720h", "0")
+
+	var plans []subscriptionPlan
+	if err := json.Unmarshal(mustInvoke(t, stub, addr3, QueryPlans, "Twitter"), &plans); err != nil || len(plans) != 2 {
+		t.Fatalf("plans = %+v, %v", plans, err)
+	}
+	if plans[0] != (subscriptionPlan{"Twitter", "basic", tokenType, "100", "720h0m0s", 1000}) {
+		t.Fatalf("basic plan = %+v", plans[0])
+	}
+
+	mustInvoke(t, stub, addr2, RemoveSubscriptionPlan, "Twitter", "pro")
+	mustFail(t, stub, addr3, Subscribe, "Twitter", "pro")
+	mustFail(t, stub, addr2, RemoveSubscriptionPlan, "Twitter", "pro")
+
+	mustFail(t, stub, addr1, SetSubscriptionPlan, "Twitter", "basic", tokenType, "1", "720h", "0")
+	mustFail(t, stub, addr2, SetSubscriptionPlan, "Twitter", "", tokenType, "1", "720h", "0")
+	mustFail(t, stub, addr2, SetSubscriptionPlan, "Twitter", "cheap", tokenType, "-1", "720h", "0")
+	mustFail(t, stub, addr2, SetSubscriptionPlan, "Twitter", "cheap", tokenType, "1", "a month", "0")
+	mustFail(t, stub, addr2, SetSubscriptionPlan, "Twitter", "cheap", tokenType, "1", "-720h", "0")
+	mustFail(t, stub, addr2, SetSubscriptionPlan, "Twitter", "cheap", tokenType, "1", "720h", "-1")
+	mustFail(t, stub, addr2, SetSubscriptionPlan, "Flickr", "cheap", tokenType, "1", "720h", "0")
+}
+
+func TestSubscribe(t *testing.T) {
+	stub := newSubscriptionStub(t)
+
+	mustInvoke(t, stub, addr3, Subscribe, "Twitter", "basic")
+	if b2, b3 := balance(stub, addr2), balance(stub, addr3); b2 != "1100" || b3 != "900" {
+		t.Fatalf("balances = %s, %s", b2, b3)
+	}
+	s := querySub(t, stub, "Twitter", addr3)
+	if s.Status != SubActive || s.Plan != "basic" || s.Quota != 1000 || s.Paid != "100" {
+		t.Fatalf("subscription = %+v", s)
+	}
+	if e := getEntitlement(t, stub, "Twitter", addr3); !e.Entitled || e.Remaining != 1000 {
+		t.Fatalf("entitlement = %+v", e)
+	}
+	if e := getEntitlement(t, stub, "Twitter", addr1); e.Entitled {
+		t.Fatalf("entitlement without subscription = %+v", e)
+	}
+
+	// one subscription per service
+	mustFail(t, stub, addr3, Subscribe, "Twitter", "basic")
+	// only available services
+	mustInvoke(t, stub, addr2, SetSubscriptionPlan, "YouTube", "basic", tokenType, "10", "720h", "0")
+	mustFail(t, stub, addr3, Subscribe, "YouTube", "basic")
+	mustFail(t, stub, addr3, Subscribe, "Twitter", "gold")
+
+	// the subscription expires with the transaction time
+	stub.Advance(month)
+	if s := querySub(t, stub, "Twitter", addr3); s.Status != SubExpired {
+		t.Fatalf("status after a month = %s", s.Status)
+	}
+	if e := getEntitlement(t, stub, "Twitter", addr3); e.Entitled {
+		t.Fatalf("entitlement after a month = %+v", e)
+	}
+	mustInvoke(t, stub, addr3, Subscribe, "Twitter", "basic")
+	if balance(stub, addr3) != "800" {
+		t.Fatalf("balance = %s", balance(stub, addr3))
+	}
+}
+
+func TestRenewAndCancelSubscription(t *testing.T) {
+	stub := newSubscriptionStub(t)
+	mustInvoke(t, stub, addr3, Subscribe, "Twitter", "basic")
+	expiry := querySub(t, stub, "Twitter", addr3).Expiry
+
+	// an active subscription is extended by a term
+	stub.Advance(10 * 24 * time.Hour)
+	mustInvoke(t, stub, addr3, RenewSubscription, "Twitter")
+	s := querySub(t, stub, "Twitter", addr3)
+	old, _ := time.Parse(time.UnixDate, expiry)
+	if s.Expiry != old.Add(month).Format(time.UnixDate) || s.Quota != 2000 || s.Paid != "200" {
+		t.Fatalf("renewed subscription = %+v", s)
+	}
+
+	// an expired one starts over
+	stub.Advance(3 * month)
+	mustInvoke(t, stub, addr3, RenewSubscription, "Twitter")
+	s = querySub(t, stub, "Twitter", addr3)
+	if s.Status != SubActive || s.Quota != 1000 || s.Used != 0 || s.Paid != "300" {
+		t.Fatalf("restarted subscription = %+v", s)
+	}
+
+	mustInvoke(t, stub, addr3, CancelSubscription, "Twitter")
+	if s := querySub(t, stub, "Twitter", addr3); s.Status != SubCancelled {
+		t.Fatalf("status = %s", s.Status)
+	}
+	if e := getEntitlement(t, stub, "Twitter", addr3); e.Entitled {
+		t.Fatalf("entitlement after cancel = %+v", e)
+	}
+	mustFail(t, stub, addr3, CancelSubscription, "Twitter")
+	mustFail(t, stub, addr3, RenewSubscription, "Twitter")
+	mustFail(t, stub, addr1, RenewSubscription, "Twitter")
+	mustFail(t, stub, addr1, QuerySubscription, "Twitter", addr1)
+
+	// a cancelled subscription can be bought again
+	mustInvoke(t, stub, addr3, Subscribe, "Twitter", "basic")
+}
+
+func TestSubscriptionCoversUsage(t *testing.T) {
+	stub := newSubscriptionStub(t)
+	mustInvoke(t, stub, addr1, AuthorizeGateway, gateway)
+	mustInvoke(t, stub, addr2, SetServicePrice, "Twitter", tokenType, "2")
+	mustInvoke(t, stub, addr2, SetSubscriptionPlan, "Twitter", "small", tokenType, "10", "720h", "50")
+	mustInvoke(t, stub, addr3, Subscribe, "Twitter", "small")
+
+	// 50 calls are covered, the other 20 are charged
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-01", "b1", batch(usage(addr3, "Twitter", 30), usage(addr3, "Twitter", 40)))
+	if got := getUsage(t, stub, "2018-01", addr3); got != "Twitter:70:40INK" {
+		t.Fatalf("usage = %s", got)
+	}
+	if s := querySub(t, stub, "Twitter", addr3); s.Used != 50 {
+		t.Fatalf("used = %d", s.Used)
+	}
+	if e := getEntitlement(t, stub, "Twitter", addr3); e.Entitled || e.Remaining != 0 {
+		t.Fatalf("entitlement after the quota = %+v", e)
+	}
+}
+
+func TestLateUsageCoverage(t *testing.T) {
+	stub := newSubscriptionStub(t)
+	mustInvoke(t, stub, addr1, AuthorizeGateway, gateway)
+	mustInvoke(t, stub, addr2, SetServicePrice, "Twitter", tokenType, "2")
+	mustInvoke(t, stub, addr2, SetSubscriptionPlan, "Twitter", "small", tokenType, "10", "720h", "50")
+	stub.Advance(10 * 24 * time.Hour)
+	mustInvoke(t, stub, addr3, Subscribe, "Twitter", "small")
+
+	// the subscription expired on 9 February, a late batch of January is covered
+	stub.SetTime(time.Date(2018, 2, 20, 0, 0, 0, 0, time.UTC))
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-01", "b1", batch(usage(addr3, "Twitter", 30)))
+	if got := getUsage(t, stub, "2018-01", addr3); got != "Twitter:30:0INK" {
+		t.Fatalf("usage of January = %s", got)
+	}
+	// so is February's usage, within the quota left
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-02", "b2", batch(usage(addr3, "Twitter", 30)))
+	if got := getUsage(t, stub, "2018-02", addr3); got != "Twitter:30:20INK" {
+		t.Fatalf("usage of February = %s", got)
+	}
+	// but not December's, before the subscription, nor March's, after it
+	mustInvoke(t, stub, gateway, RecordUsage, "2017-12", "b3", batch(usage(addr3, "Twitter", 1)))
+	stub.SetTime(time.Date(2018, 3, 2, 0, 0, 0, 0, time.UTC))
+	mustInvoke(t, stub, addr3, RenewSubscription, "Twitter", "small")
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-02", "b4", batch(usage(addr3, "Twitter", 1)))
+	if got := getUsage(t, stub, "2017-12", addr3) + "," + getUsage(t, stub, "2018-02", addr3); got != "Twitter:1:2INK,Twitter:31:22INK" {
+		t.Fatalf("usage = %s", got)
+	}
+
+	// a cancelled subscription covers its period until the cancellation
+	mustInvoke(t, stub, addr3, CancelSubscription, "Twitter")
+	stub.SetTime(time.Date(2018, 4, 2, 0, 0, 0, 0, time.UTC))
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-03", "b5", batch(usage(addr3, "Twitter", 5)))
+	mustInvoke(t, stub, gateway, RecordUsage, "2018-04", "b6", batch(usage(addr3, "Twitter", 5)))
+	if got := getUsage(t, stub, "2018-03", addr3) + "," + getUsage(t, stub, "2018-04", addr3); got != "Twitter:5:0INK,Twitter:5:10INK" {
+		t.Fatalf("usage after the cancellation = %s", got)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// Service ownership transfer
+// ==================================================================================
+//
+// A developer hands a service over in two steps: it offers the service to
+// another registered user, and only that user's address can accept. Until
+// then the developer can withdraw the offer and the offered user can decline
+// it, both with cancelServiceTransfer; a new offer replaces the pending one.
+//
+// On acceptance the service's "Developer" becomes the new owner, so the
+// developer indexes, the type boards and every later payment (mashup
+// incentives, rewards, usage and subscriptions) follow it. Rewards and
+// contribution points earned before stay with the previous developer. The
+// transfer is appended to the service's transition log with both developers.
+// Suspended services can not change hands.
+//
+// Removing a user drops the offers of its services and the offers made to
+// it, so an orphaned service never changes hands.
+
+// Structure definition for a pending transfer offer
+type transferOffer struct {
+	To   string `json:"to"` // name of the offered user
+	Time string `json:"time"`
+}
+
+// ===============================================================
+// offerServiceTransfer: offer a service to another user
+// ===============================================================
+func (t *serviceChaincode) offerServiceTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var new_dev string
+	var err error
+
+	service_name = args[0]
+	new_dev = args[1]
+
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: only the developer offers its service
+	_, err = authorize(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if serviceJSON.Status == S_Suspended {
+		return shim.Error("A suspended service can not be transferred: " + service_name)
+	}
+	devJSON, err := lookupDeveloper(stub, serviceJSON.Developer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: the new developer is another active user
+	if new_dev == devJSON.Name {
+		return shim.Error("The service is already developed by " + new_dev + ".")
+	}
+	err = checkActiveUser(stub, new_dev)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: record the offer
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setTransfer(stub, serviceJSON, &transferOffer{new_dev, txTime.Format(time.UnixDate)})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return storeTransfer(stub, serviceJSON, OfferServiceTransfer, devJSON.Name, new_dev)
+}
+
+// ===============================================================
+// acceptServiceTransfer: take over a service offered to the sender
+// ===============================================================
+func (t *serviceChaincode) acceptServiceTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var err error
+
+	service_name = args[0]
+
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if serviceJSON.Transfer == nil {
+		return shim.Error("This service is not offered for transfer: " + service_name)
+	}
+	if serviceJSON.Status == S_Suspended {
+		return shim.Error("A suspended service can not be transferred: " + service_name)
+	}
+
+	// STEP 0: only the offered user accepts, while active
+	newJSON, err := offeredUser(stub, serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkActiveUser(stub, newJSON.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	oldJSON, err := lookupDeveloper(stub, serviceJSON.Developer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: move the developer indexes to the new developer
+	index_key, err := stub.CreateCompositeKey(DeveloperServiceIndex, []string{serviceJSON.Developer, service_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.DelState(index_key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = addDeveloperIndex(stub, newJSON.Name, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = unindexDeveloperType(stub, oldJSON.Name, serviceJSON.Type, service_name, oldJSON.Contribution)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = indexDeveloperType(stub, newJSON.Name, serviceJSON.Type, service_name, newJSON.Contribution)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: hand the service over and log it
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	serviceJSON.Developer = newJSON.Name
+	serviceJSON.Orphaned = false
+	err = setTransfer(stub, serviceJSON, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	handOver(serviceJSON, newJSON.Name)
+	serviceJSON.UpdatedTime = txTime.Format(time.UnixDate)
+	err = logEntry(stub, &transition{Service: service_name, From: serviceJSON.Status, To: serviceJSON.Status,
+		PreviousDeveloper: oldJSON.Name, Developer: newJSON.Name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return storeTransfer(stub, serviceJSON, AcceptServiceTransfer, oldJSON.Name, newJSON.Name)
+}
+
+// ===============================================================
+// cancelServiceTransfer: withdraw or decline a transfer offer
+// ===============================================================
+func (t *serviceChaincode) cancelServiceTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var service_name string
+	var err error
+
+	service_name = args[0]
+
+	serviceJSON, err := readService(stub, service_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if serviceJSON.Transfer == nil {
+		return shim.Error("This service is not offered for transfer: " + service_name)
+	}
+
+	// STEP 0: the developer withdraws the offer, the offered user declines it
+	_, err = offeredUser(stub, serviceJSON)
+	if err != nil {
+		_, err = authorize(stub, serviceJSON)
+		if err != nil {
+			return shim.Error("Authority err! Not invoked by the service's developer or the offered user.")
+		}
+	}
+	devJSON, err := lookupDeveloper(stub, serviceJSON.Developer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	to := serviceJSON.Transfer.To
+	err = setTransfer(stub, serviceJSON, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return storeTransfer(stub, serviceJSON, CancelServiceTransfer, devJSON.Name, to)
+}
+
+// setTransfer replaces the pending offer of a service, nil drops it, and
+// keeps the offers indexed by offered user. The caller stores the service.
+func setTransfer(stub shim.ChaincodeStubInterface, serviceJSON *service, offer *transferOffer) error {
+	if serviceJSON.Transfer != nil {
+		offer_key, err := stub.CreateCompositeKey(TransferOfferIndex, []string{serviceJSON.Transfer.To, serviceJSON.Name})
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(offer_key)
+		if err != nil {
+			return err
+		}
+	}
+	serviceJSON.Transfer = offer
+	if offer == nil {
+		return nil
+	}
+	offer_key, err := stub.CreateCompositeKey(TransferOfferIndex, []string{offer.To, serviceJSON.Name})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(offer_key, []byte{0x00})
+}
+
+// offeredServices lists the services offered to user_name.
+func offeredServices(stub shim.ChaincodeStubInterface, user_name string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(TransferOfferIndex, []string{user_name})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	services := []string{}
+	for resultsIterator.HasNext() {
+		indexResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, keyParts[1])
+	}
+	return services, nil
+}
+
+// offeredUser reads the user a service is offered to, and fails unless the
+// sender is that user's address.
+func offeredUser(stub shim.ChaincodeStubInterface, serviceJSON *service) (*user, error) {
+	senderAdd, err := senderAddress(stub)
+	if err != nil {
+		return nil, errors.New("Fail to get the sender's address.")
+	}
+	userJSON, err := readUser(stub, serviceJSON.Transfer.To)
+	if err != nil {
+		return nil, err
+	}
+	if senderAdd != userJSON.Address {
+		return nil, errors.New("Authority err! Not invoked by the offered user.")
+	}
+	return userJSON, nil
+}
+
+// storeTransfer stores a service whose transfer changed and emits the event.
+func storeTransfer(stub shim.ChaincodeStubInterface, serviceJSON *service, name string, from string, to string) pb.Response {
+	serviceJSONasBytes, err := json.Marshal(serviceJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(ServicePrefix+serviceJSON.Name, serviceJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitEvent(stub, name, []string{ServicePrefix + serviceJSON.Name, UserPrefix + from, UserPrefix + to},
+		serviceJSON.Status, serviceJSON.Status, events.ServiceTransfer{Service: serviceJSON.Name, From: from, To: to})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(serviceJSONasBytes)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+)
+
+func TestServiceTransfer(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr4, RegisterUser, "user4", "The new team.")
+	mustInvoke(t, stub, addr2, PublishService, "YouTube")
+
+	// only the developer offers, to another registered user
+	mustFail(t, stub, addr3, OfferServiceTransfer, "YouTube", "user3")
+	mustFail(t, stub, addr2, OfferServiceTransfer, "YouTube", "user2")
+	mustFail(t, stub, addr2, OfferServiceTransfer, "YouTube", "user9")
+	mustFail(t, stub, addr2, OfferServiceTransfer, "Vimeo", "user4")
+	mustInvoke(t, stub, addr2, OfferServiceTransfer, "YouTube", "user3")
+	mustInvoke(t, stub, addr2, OfferServiceTransfer, "YouTube", "user4")
+	if envelope, data := lastEvent(t, stub); envelope != "offerServiceTransfer "+addr2+" SER_YouTube,USER_user2,USER_user4 available>available" ||
+		*data.(*events.ServiceTransfer) != (events.ServiceTransfer{Service: "YouTube", From: "user2", To: "user4"}) {
+		t.Fatalf("event = %s %+v", envelope, data)
+	}
+	if s := getService(t, stub, "YouTube"); s.Developer != "user2" || s.Transfer == nil || s.Transfer.To != "user4" {
+		t.Fatalf("offered service = %+v", s)
+	}
+
+	// a new offer replaced the previous one, only the offered user accepts
+	mustFail(t, stub, addr3, AcceptServiceTransfer, "YouTube")
+	mustFail(t, stub, addr2, AcceptServiceTransfer, "YouTube")
+	mustFail(t, stub, addr4, AcceptServiceTransfer, "Twitter")
+	stub.Advance(time.Minute)
+	mustInvoke(t, stub, addr4, AcceptServiceTransfer, "YouTube")
+	if envelope, _ := lastEvent(t, stub); envelope != "acceptServiceTransfer "+addr4+" SER_YouTube,USER_user2,USER_user4 available>available" {
+		t.Fatalf("event = %s", envelope)
+	}
+	if s := getService(t, stub, "YouTube"); s.Developer != "user4" || s.Transfer != nil {
+		t.Fatalf("transferred service = %+v", s)
+	}
+	mustFail(t, stub, addr4, AcceptServiceTransfer, "YouTube")
+
+	// the transfer is logged and the indexes follow the new developer
+	h := getHistory(t, stub, "YouTube")
+	if last := h[len(h)-1]; last.From != S_Available || last.To != S_Available ||
+		last.PreviousDeveloper != "user2" || last.Developer != "user4" || last.Actor != addr4 {
+		t.Fatalf("history = %+v", h)
+	}
+	if got := serviceNames(queryPage(t, stub, QueryServiceByUser, "user2")); got != "Twitter" {
+		t.Fatalf("services of user2 = %s", got)
+	}
+	if got := serviceNames(queryPage(t, stub, QueryServiceByUser, "user4")); got != "YouTube" {
+		t.Fatalf("services of user4 = %s", got)
+	}
+	if got := getLeaderboard(t, stub, "", "Video"); !strings.Contains(got, "user4") || strings.Contains(got, "user2") {
+		t.Fatalf("Video board = %s", got)
+	}
+
+	// so do the payments
+	mustInvoke(t, stub, addr3, CreateMashup, "MapVideos", "Video", "Videos on a map.", "Google Maps", "YouTube")
+	if _, payments := getPayout(t, stub, "MapVideos"); payments != "Google Maps:user1:1:10,YouTube:user4:1:10" {
+		t.Fatalf("payments = %s", payments)
+	}
+	mustInvoke(t, stub, addr1, RewardService, "YouTube", tokenType, "5")
+	if got := balance(stub, addr4); got != "15" {
+		t.Fatalf("balance of user4 = %s, want 15", got)
+	}
+
+	// the new developer manages the service, the previous one no longer
+	mustFail(t, stub, addr2, EditService, "YouTube", "Description", "Mine.")
+	mustInvoke(t, stub, addr4, EditService, "YouTube", "Description", "Videos of the new team.")
+}
+
+func TestCancelServiceTransfer(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr4, RegisterUser, "user4", "The new team.")
+	mustFail(t, stub, addr2, CancelServiceTransfer, "Twitter")
+
+	// the developer withdraws an offer
+	mustInvoke(t, stub, addr2, OfferServiceTransfer, "Twitter", "user4")
+	mustFail(t, stub, addr3, CancelServiceTransfer, "Twitter")
+	mustInvoke(t, stub, addr2, CancelServiceTransfer, "Twitter")
+	if envelope, data := lastEvent(t, stub); envelope != "cancelServiceTransfer "+addr2+" SER_Twitter,USER_user2,USER_user4 created>created" ||
+		data.(*events.ServiceTransfer).To != "user4" {
+		t.Fatalf("event = %s %+v", envelope, data)
+	}
+	mustFail(t, stub, addr4, AcceptServiceTransfer, "Twitter")
+
+	// the offered user declines one
+	mustInvoke(t, stub, addr2, OfferServiceTransfer, "Twitter", "user4")
+	mustInvoke(t, stub, addr4, CancelServiceTransfer, "Twitter")
+	if s := getService(t, stub, "Twitter"); s.Developer != "user2" || s.Transfer != nil {
+		t.Fatalf("service = %+v", s)
+	}
+
+	// suspended users and services do not change hands
+	mustInvoke(t, stub, addr2, OfferServiceTransfer, "Twitter", "user4")
+	mustInvoke(t, stub, addr1, SuspendUser, "user4", "Spam.")
+	mustFail(t, stub, addr4, AcceptServiceTransfer, "Twitter")
+	mustFail(t, stub, addr2, OfferServiceTransfer, "YouTube", "user4")
+	mustInvoke(t, stub, addr1, ReinstateUser, "user4")
+	mustInvoke(t, stub, addr1, SuspendService, "Twitter", "Spam.")
+	mustFail(t, stub, addr4, AcceptServiceTransfer, "Twitter")
+}
+
+func TestRemovedUsersDropTransfers(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr4, RegisterUser, "user4", "The new team.")
+	mustInvoke(t, stub, addr2, PublishService, "YouTube")
+	mustInvoke(t, stub, addr2, OfferServiceTransfer, "YouTube", "user3")
+	mustInvoke(t, stub, addr2, OfferServiceTransfer, "YouTube", "user4")
+	mustInvoke(t, stub, addr1, OfferServiceTransfer, "Google Maps", "user3")
+
+	// an orphaned service is no longer on offer
+	mustInvoke(t, stub, addr2, RemoveUser, "user2", RemoveOrphan)
+	if s := getService(t, stub, "YouTube"); !s.Orphaned || s.Transfer != nil {
+		t.Fatalf("orphaned service = %+v", s)
+	}
+	mustFail(t, stub, addr4, AcceptServiceTransfer, "YouTube")
+
+	// neither are the services offered to a removed user
+	mustInvoke(t, stub, addr3, RemoveUser, "user3")
+	if envelope, _ := lastEvent(t, stub); envelope != "removeUser "+addr3+" USER_user3,SER_Google Maps registered>removed" {
+		t.Fatalf("event = %s", envelope)
+	}
+	if s := getService(t, stub, "Google Maps"); s.Transfer != nil {
+		t.Fatalf("offered service = %+v", s)
+	}
+	for key := range stub.State {
+		if strings.Contains(key, TransferOfferIndex) {
+			t.Fatalf("offer index left: %q", key)
+		}
+	}
+}
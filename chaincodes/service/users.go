@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+// User profiles and address rotation
+// ==================================================================================
+//
+// A user edits its profile with editUser, and moves to a new payout address
+// in two steps: the current address starts the rotation and the new address
+// confirms it. Services record their developer's name, so they follow the
+// user to its new address; the retired address keeps resolving to the user
+// for the mashups of earlier versions, which recorded an address.
+//
+// A user who lost its key is recovered by an admin or by one of the guardian
+// addresses it set beforehand: they start the rotation, and the new address
+// can confirm it only RecoveryDelay later. Until then the user's current
+// address, the initiator or an admin can cancel it, so a stolen guardian key
+// can not take an account over unnoticed. Roles belong to addresses and do
+// not move with a user.
+
+// Address rotation limits
+const (
+	RecoveryDelay = 72 * time.Hour
+	MaxGuardians  = 5
+)
+
+// Profile fields of a user editUser changes
+var editableUserFields = map[string]bool{
+	"Introduction": true,
+}
+
+// Structure definition for a pending address rotation
+type addressRotation struct {
+	Address   string `json:"address"`   // the new address
+	Initiator string `json:"initiator"` // address that started the rotation
+	Recovery  bool   `json:"recovery,omitempty"`
+	Time      string `json:"time"`
+	ReadyTime string `json:"readyTime"` // earliest confirmation
+}
+
+// ===============================================================
+// editUser: edit a profile field of the sender's user
+// ===============================================================
+func (t *serviceChaincode) editUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var user_name string
+	var field_name string
+	var field_value string
+	var err error
+
+	user_name = args[0]
+	field_name = args[1]
+	field_value = args[2]
+
+	if !editableUserFields[field_name] {
+		return shim.Error("This field can not be edited: " + field_name)
+	}
+
+	// STEP 0: only the user edits its profile
+	userJSON, err := readUser(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkUserSender(stub, userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkActiveUser(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: change the field
+	var old_value string
+	switch field_name {
+	case "Introduction":
+		old_value = userJSON.Introduction
+		userJSON.Introduction = field_value
+	}
+
+	return storeUser(stub, userJSON, EditUser,
+		events.UserEdited{Name: user_name, Field: field_name, OldValue: old_value, NewValue: field_value})
+}
+
+// ===============================================================
+// setGuardians: set the addresses allowed to recover the sender's user
+// args[1] is a JSON array of addresses, [] to clear them
+// ===============================================================
+func (t *serviceChaincode) setGuardians(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var user_name string
+	var err error
+
+	user_name = args[0]
+
+	// STEP 0: only the user chooses its guardians
+	userJSON, err := readUser(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkUserSender(stub, userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkActiveUser(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: check the guardians
+	var guardians []string
+	err = json.Unmarshal([]byte(args[1]), &guardians)
+	if err != nil {
+		return shim.Error("Error unmarshal guardians: " + err.Error())
+	}
+	if len(guardians) > MaxGuardians {
+		return shim.Error("A user can not have more than " + strconv.Itoa(MaxGuardians) + " guardians.")
+	}
+	seen := make(map[string]bool)
+	for i, guardian := range guardians {
+		guardian = normalizeAddress(guardian)
+		if guardian == "" || guardian == userJSON.Address || seen[guardian] {
+			return shim.Error("Invalid guardian: " + guardians[i])
+		}
+		seen[guardian] = true
+		guardians[i] = guardian
+	}
+	userJSON.Guardians = guardians
+	if len(guardians) == 0 {
+		userJSON.Guardians = nil
+	}
+
+	return storeUser(stub, userJSON, SetGuardians, events.Guardians{Name: user_name, Guardians: guardians})
+}
+
+// ===============================================================
+// rotateAddress: move the sender's user to a new address,
+// confirmed by the new address
+// ===============================================================
+func (t *serviceChaincode) rotateAddress(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	userJSON, err := readUser(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: only the current address starts a rotation
+	err = checkUserSender(stub, userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return startRotation(stub, userJSON, normalizeAddress(args[1]), false)
+}
+
+// ===============================================================
+// recoverAddress: move a user that lost its key to a new address,
+// by an admin or a guardian, confirmed after the recovery delay
+// ===============================================================
+func (t *serviceChaincode) recoverAddress(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	userJSON, err := readUser(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 0: admins and the user's guardians recover it
+	senderAdd, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	if !isGuardian(userJSON, senderAdd) {
+		_, err = authorize(stub, nil, R_Admin)
+		if err != nil {
+			return shim.Error("Authority err! Not invoked by an admin or a guardian of the user.")
+		}
+	}
+
+	return startRotation(stub, userJSON, normalizeAddress(args[1]), true)
+}
+
+// startRotation records a pending rotation of userJSON to new_add,
+// replacing the previous one.
+func startRotation(stub shim.ChaincodeStubInterface, userJSON *user, new_add string, recovery bool) pb.Response {
+	if userJSON.Removed {
+		return shim.Error("This user was removed: " + userJSON.Name)
+	}
+	err := checkFreeAddress(stub, userJSON, new_add)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	senderAdd, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ready := txTime
+	name := RotateAddress
+	if recovery {
+		ready = txTime.Add(RecoveryDelay)
+		name = RecoverAddress
+	}
+	userJSON.Rotation = &addressRotation{new_add, senderAdd, recovery,
+		txTime.Format(time.UnixDate), ready.Format(time.UnixDate)}
+
+	return storeUser(stub, userJSON, name, rotationEvent(userJSON))
+}
+
+// ===============================================================
+// confirmAddress: confirm a rotation from the new address
+// ===============================================================
+func (t *serviceChaincode) confirmAddress(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var user_name string
+	var err error
+
+	user_name = args[0]
+
+	userJSON, err := readUser(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rotation := userJSON.Rotation
+	if rotation == nil {
+		return shim.Error("No address rotation is pending for this user: " + user_name)
+	}
+
+	// STEP 0: only the new address confirms, once the rotation is ready
+	senderAdd, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	if senderAdd != rotation.Address {
+		return shim.Error("Authority err! Not invoked by the new address.")
+	}
+	if userJSON.Removed {
+		return shim.Error("This user was removed: " + user_name)
+	}
+	txTime, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ready, err := time.Parse(time.UnixDate, rotation.ReadyTime)
+	if err != nil {
+		return shim.Error("Error parse the ready time of the rotation.")
+	}
+	if txTime.Before(ready) {
+		return shim.Error("This recovery can not be confirmed before " + rotation.ReadyTime + ".")
+	}
+	err = checkFreeAddress(stub, userJSON, rotation.Address)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 1: move the address index, the old address keeps resolving
+	old_key, err := stub.CreateCompositeKey(AddressUserIndex, []string{userJSON.Address, user_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.DelState(old_key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	retired_key, err := stub.CreateCompositeKey(RetiredAddressIndex, []string{userJSON.Address, user_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(retired_key, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	new_key, err := stub.CreateCompositeKey(AddressUserIndex, []string{rotation.Address, user_name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(new_key, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// STEP 2: store the user at its new address
+	data := rotationEvent(userJSON)
+	userJSON.Address = rotation.Address
+	userJSON.Rotation = nil
+
+	return storeUser(stub, userJSON, ConfirmAddress, data)
+}
+
+// ===============================================================
+// cancelRotation: cancel a pending rotation, by the user's current
+// address, the initiator or an admin
+// ===============================================================
+func (t *serviceChaincode) cancelRotation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var user_name string
+	var err error
+
+	user_name = args[0]
+
+	userJSON, err := readUser(stub, user_name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if userJSON.Rotation == nil {
+		return shim.Error("No address rotation is pending for this user: " + user_name)
+	}
+
+	senderAdd, err := senderAddress(stub)
+	if err != nil {
+		return shim.Error("Fail to get the sender's address.")
+	}
+	if senderAdd != userJSON.Address && senderAdd != userJSON.Rotation.Initiator {
+		_, err = authorize(stub, nil, R_Admin)
+		if err != nil {
+			return shim.Error("Authority err! Not invoked by the user, the initiator or an admin.")
+		}
+	}
+
+	data := rotationEvent(userJSON)
+	userJSON.Rotation = nil
+
+	return storeUser(stub, userJSON, CancelRotation, data)
+}
+
+// checkUserSender fails unless the sender is the address of userJSON.
+func checkUserSender(stub shim.ChaincodeStubInterface, userJSON *user) error {
+	senderAdd, err := senderAddress(stub)
+	if err != nil {
+		return errors.New("Fail to get the sender's address.")
+	}
+	if senderAdd != userJSON.Address {
+		return errors.New("Authority err! Not invoked by the user.")
+	}
+	return nil
+}
+
+// checkFreeAddress fails unless userJSON can move to new_add.
+func checkFreeAddress(stub shim.ChaincodeStubInterface, userJSON *user, new_add string) error {
+	if new_add == "" || new_add == userJSON.Address {
+		return errors.New("Invalid new address: " + new_add)
+	}
+	user_name, err := userNameByAddress(stub, new_add)
+	if err != nil {
+		return err
+	} else if user_name != "" {
+		return errors.New("This address already registered a user: " + user_name)
+	}
+	return nil
+}
+
+// retiredAddresses lists the addresses user_name rotated away from.
+func retiredAddresses(stub shim.ChaincodeStubInterface, user_name string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(RetiredAddressIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	addresses := []string{}
+	for resultsIterator.HasNext() {
+		indexResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if keyParts[1] == user_name {
+			addresses = append(addresses, keyParts[0])
+		}
+	}
+	return addresses, nil
+}
+
+// isGuardian reports whether address is a guardian of userJSON.
+func isGuardian(userJSON *user, address string) bool {
+	for _, guardian := range userJSON.Guardians {
+		if guardian == address {
+			return true
+		}
+	}
+	return false
+}
+
+// userStatus is the status of a user in events.
+func userStatus(userJSON *user) string {
+	if userJSON.Removed {
+		return U_Removed
+	}
+	if userJSON.Suspension != nil {
+		return U_Suspended
+	}
+	return U_Registered
+}
+
+// rotationEvent describes the pending rotation of userJSON.
+func rotationEvent(userJSON *user) events.AddressRotation {
+	rotation := userJSON.Rotation
+	return events.AddressRotation{Name: userJSON.Name, Address: userJSON.Address, NewAddress: rotation.Address,
+		Recovery: rotation.Recovery, ReadyTime: rotation.ReadyTime}
+}
+
+// storeUser stores a user whose record changed and emits the event.
+func storeUser(stub shim.ChaincodeStubInterface, userJSON *user, name string, data interface{}) pb.Response {
+	user_key := UserPrefix + userJSON.Name
+	userJSONasBytes, err := json.Marshal(userJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(user_key, userJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	status := userStatus(userJSON)
+	err = emitEvent(stub, name, []string{user_key}, status, status, data)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(userJSONasBytes)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+)
+
+func TestEditUser(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	mustInvoke(t, stub, addr1, EditUser, "user1", "Introduction", "Maps and routes.")
+	if envelope, data := lastEvent(t, stub); envelope != "editUser "+addr1+" USER_user1 registered>registered" ||
+		*data.(*events.UserEdited) != (events.UserEdited{Name: "user1", Field: "Introduction",
+			OldValue: "An active service developer.", NewValue: "Maps and routes."}) {
+		t.Fatalf("event = %s %+v", envelope, data)
+	}
+	if u := getUser(t, stub, "user1"); u.Introduction != "Maps and routes." {
+		t.Fatalf("user = %+v", u)
+	}
+
+	mustFail(t, stub, addr2, EditUser, "user1", "Introduction", "Not mine.")
+	mustFail(t, stub, addr1, EditUser, "user1", "Address", addr2)
+	mustFail(t, stub, addr1, EditUser, "user1", "Contribution", "1000")
+	mustFail(t, stub, addr1, EditUser, "user9", "Introduction", "Nobody.")
+	mustInvoke(t, stub, addr1, GrantRole, R_Moderator, addr3)
+	mustInvoke(t, stub, addr3, SuspendUser, "user2", "Spam.")
+	mustFail(t, stub, addr2, EditUser, "user2", "Introduction", "Buy now!")
+}
+
+func TestRotateAddress(t *testing.T) {
+	stub := newEcosystemStub(t)
+	mustInvoke(t, stub, addr2, PublishService, "Twitter")
+	putMashupBy(t, stub, addr2, "OldVideos", "YouTube")
+	mustInvoke(t, stub, addr2, PublishService, "OldVideos")
+
+	// the current address starts, to a free address
+	mustFail(t, stub, addr4, RotateAddress, "user2", addr4)
+	mustFail(t, stub, addr2, RotateAddress, "user2", addr3)
+	mustFail(t, stub, addr2, RotateAddress, "user2", addr2)
+	mustFail(t, stub, addr2, RotateAddress, "user2", "")
+	mustInvoke(t, stub, addr2, RotateAddress, "user2", strings.ToUpper(addr4))
+	if envelope, _ := lastEvent(t, stub); envelope != "rotateAddress "+addr2+" USER_user2 registered>registered" {
+		t.Fatalf("event = %s", envelope)
+	}
+
+	// the new address confirms
+	mustFail(t, stub, addr2, ConfirmAddress, "user2")
+	mustFail(t, stub, addr3, ConfirmAddress, "user2")
+	mustInvoke(t, stub, addr4, ConfirmAddress, "user2")
+	if _, data := lastEvent(t, stub); data.(*events.AddressRotation).Address != addr2 || data.(*events.AddressRotation).NewAddress != addr4 {
+		t.Fatalf("event data = %+v", data)
+	}
+	if u := getUser(t, stub, "user2"); u.Address != addr4 || u.Rotation != nil {
+		t.Fatalf("user = %+v", u)
+	}
+	mustFail(t, stub, addr4, ConfirmAddress, "user2")
+	mustFail(t, stub, addr1, QueryUserByAddress, addr2)
+	var u user
+	if err := json.Unmarshal(mustInvoke(t, stub, addr1, QueryUserByAddress, addr4), &u); err != nil || u.Name != "user2" {
+		t.Fatalf("user of addr4 = %+v", u)
+	}
+
+	// services follow the user to its new address
+	mustFail(t, stub, addr2, EditService, "Twitter", "Description", "Stolen.")
+	mustInvoke(t, stub, addr4, EditService, "Twitter", "Description", "Tweets from the new key.")
+	mustInvoke(t, stub, addr1, RewardService, "Twitter", tokenType, "10")
+	mustInvoke(t, stub, addr1, RewardService, "OldVideos", tokenType, "5")
+	if b2, b4 := balance(stub, addr2), balance(stub, addr4); b2 != "1000" || b4 != "15" {
+		t.Fatalf("balances = %s, %s", b2, b4)
+	}
+
+	// the old address is free again, and does not take the old mashup along
+	mustInvoke(t, stub, addr2, RegisterUser, "user5", "A new user.")
+	mustInvoke(t, stub, addr1, RewardService, "OldVideos", tokenType, "5")
+	if b2, b4 := balance(stub, addr2), balance(stub, addr4); b2 != "1000" || b4 != "20" {
+		t.Fatalf("balances = %s, %s", b2, b4)
+	}
+}
+
+func TestRecoverAddress(t *testing.T) {
+	stub := newEcosystemStub(t)
+
+	mustFail(t, stub, addr3, SetGuardians, "user2", `["`+addr3+`"]`)
+	mustFail(t, stub, addr2, SetGuardians, "user2", `["`+addr2+`"]`)
+	mustFail(t, stub, addr2, SetGuardians, "user2", `["`+addr3+`","`+addr3+`"]`)
+	mustFail(t, stub, addr2, SetGuardians, "user2", `"`+addr3+`"`)
+	mustInvoke(t, stub, addr2, SetGuardians, "user2", `["`+strings.ToUpper(addr3)+`"]`)
+	if u := getUser(t, stub, "user2"); len(u.Guardians) != 1 || u.Guardians[0] != addr3 {
+		t.Fatalf("guardians = %v", u.Guardians)
+	}
+
+	// a guardian starts a recovery, confirmed after the delay
+	mustFail(t, stub, addr4, RecoverAddress, "user2", addr4)
+	mustInvoke(t, stub, addr3, RecoverAddress, "user2", addr4)
+	if envelope, data := lastEvent(t, stub); envelope != "recoverAddress "+addr3+" USER_user2 registered>registered" ||
+		!data.(*events.AddressRotation).Recovery {
+		t.Fatalf("event = %s %+v", envelope, data)
+	}
+	stub.Advance(RecoveryDelay - time.Minute)
+	mustFail(t, stub, addr4, ConfirmAddress, "user2")
+
+	// the user cancels a recovery it did not ask for
+	mustFail(t, stub, addr4, CancelRotation, "user2")
+	mustInvoke(t, stub, addr2, CancelRotation, "user2")
+	stub.Advance(time.Hour)
+	mustFail(t, stub, addr4, ConfirmAddress, "user2")
+
+	// an admin recovers any user
+	mustFail(t, stub, addr2, RecoverAddress, "user3", addr4)
+	mustInvoke(t, stub, addr1, RecoverAddress, "user3", addr4)
+	stub.Advance(RecoveryDelay)
+	mustInvoke(t, stub, addr4, ConfirmAddress, "user3")
+	if u := getUser(t, stub, "user3"); u.Address != addr4 {
+		t.Fatalf("recovered user = %+v", u)
+	}
+	mustInvoke(t, stub, addr4, EditUser, "user3", "Introduction", "Recovered.")
+
+	mustInvoke(t, stub, addr2, SetGuardians, "user2", `[]`)
+	if u := getUser(t, stub, "user2"); u.Guardians != nil {
+		t.Fatalf("guardians = %v", u.Guardians)
+	}
+	mustFail(t, stub, addr3, RecoverAddress, "user2", "9d3e6f0a5b7c2e41d8f6a3b0c9e2d7f4a1b6c8e0")
+}
@@ -0,0 +1,477 @@
+/*
+Package shimtest provides an in-memory implementation of Inkchain's
+shim.ChaincodeStubInterface so that the DSES chaincodes can be unit-tested
+without a running peer.
+
+Besides the world state, the stub keeps a wallet ledger of token balances
+and tx counters, which backs the Inkchain extensions GetSender, Transfer and
+GetAccount. The sender is chosen per call:
+
+	stub := shimtest.NewStub("service", new(serviceChaincode))
+	stub.SetBalance("addr1", "INK", 100)
+	res := stub.InvokeAs("addr1", "registerUser", "user1", "introduction")
+
+Like a real peer, writes and transfers made during a transaction are only
+committed when the chaincode returns a successful response, and reads inside
+the transaction see the state as it was before the transaction started.
+*/
+package shimtest
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+	"github.com/inklabsfoundation/inkchain/core/wallet"
+	"github.com/inklabsfoundation/inkchain/protos/ledger/queryresult"
+	pb "github.com/inklabsfoundation/inkchain/protos/peer"
+)
+
+const (
+	compositeKeyNamespace = "\x00"
+	minUnicodeRuneValue   = rune(0)
+	maxUnicodeRuneValue   = utf8.MaxRune
+)
+
+// Event is a chaincode event committed by a successful transaction.
+type Event struct {
+	TxID    string
+	Name    string
+	Payload []byte
+}
+
+// Ledger holds the token accounts shared by every chaincode of a test network.
+type Ledger struct {
+	Accounts map[string]*wallet.Account
+}
+
+// NewLedger returns an empty wallet ledger.
+func NewLedger() *Ledger {
+	return &Ledger{Accounts: make(map[string]*wallet.Account)}
+}
+
+func (l *Ledger) account(address string) *wallet.Account {
+	acc, ok := l.Accounts[address]
+	if !ok {
+		acc = &wallet.Account{Balance: make(map[string]*big.Int)}
+		l.Accounts[address] = acc
+	}
+	return acc
+}
+
+// Stub is an in-memory shim.ChaincodeStubInterface bound to one chaincode.
+// Interface methods the DSES chaincodes never call are left to the embedded
+// nil interface and panic if reached.
+type Stub struct {
+	shim.ChaincodeStubInterface
+
+	Name   string
+	cc     shim.Chaincode
+	ledger *Ledger
+
+	// State is the committed world state of the chaincode.
+	State map[string][]byte
+	// Events lists the events of all committed transactions, oldest first.
+	Events []Event
+	// Invokables maps chaincode names to the stubs InvokeChaincode calls into.
+	Invokables map[string]*Stub
+
+	txSeq  int
+	txTime time.Time
+
+	// per-transaction context
+	args      [][]byte
+	txID      string
+	sender    string
+	writes    map[string][]byte
+	deletes   map[string]bool
+	transfers []transfer
+	event     *Event
+}
+
+type transfer struct {
+	from        string
+	to          string
+	balanceType string
+	amount      *big.Int
+}
+
+// NewStub returns a stub for cc with an empty state and its own ledger.
+func NewStub(name string, cc shim.Chaincode) *Stub {
+	return &Stub{
+		Name:       name,
+		cc:         cc,
+		ledger:     NewLedger(),
+		State:      make(map[string][]byte),
+		Invokables: make(map[string]*Stub),
+		txTime:     time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// Ledger returns the wallet ledger backing the stub.
+func (s *Stub) Ledger() *Ledger {
+	return s.ledger
+}
+
+// ShareLedger makes the stub use l for balances, so that several chaincodes
+// in a test see the same accounts.
+func (s *Stub) ShareLedger(l *Ledger) {
+	s.ledger = l
+}
+
+// SetBalance seeds the balance of address for balanceType.
+func (s *Stub) SetBalance(address, balanceType string, amount int64) {
+	s.ledger.account(address).Balance[balanceType] = big.NewInt(amount)
+}
+
+// Balance returns the committed balance of address for balanceType, or nil.
+func (s *Stub) Balance(address, balanceType string) *big.Int {
+	acc, ok := s.ledger.Accounts[address]
+	if !ok {
+		return nil
+	}
+	return acc.Balance[balanceType]
+}
+
+// SetCounter seeds the tx counter of address.
+func (s *Stub) SetCounter(address string, counter uint64) {
+	s.ledger.account(address).Counter = counter
+}
+
+// SetTime sets the timestamp reported to the following transactions.
+func (s *Stub) SetTime(t time.Time) {
+	s.txTime = t
+}
+
+// Advance moves the transaction clock forward by d.
+func (s *Stub) Advance(d time.Duration) {
+	s.txTime = s.txTime.Add(d)
+}
+
+// InitAs calls the chaincode's Init with args, sent by sender.
+func (s *Stub) InitAs(sender string, args ...string) pb.Response {
+	return s.run(sender, toBytes(args), s.cc.Init)
+}
+
+// InvokeAs calls the chaincode's Invoke with function and args, sent by sender.
+func (s *Stub) InvokeAs(sender string, function string, args ...string) pb.Response {
+	return s.run(sender, toBytes(append([]string{function}, args...)), s.cc.Invoke)
+}
+
+func (s *Stub) run(sender string, args [][]byte, fn func(shim.ChaincodeStubInterface) pb.Response) pb.Response {
+	s.txSeq++
+	s.begin(sender, args, "tx"+strconv.Itoa(s.txSeq))
+	res := fn(s)
+	if res.Status < shim.ERRORTHRESHOLD {
+		s.commit()
+	}
+	s.end()
+	return res
+}
+
+func (s *Stub) begin(sender string, args [][]byte, txID string) {
+	s.args = args
+	s.txID = txID
+	s.sender = sender
+	s.writes = make(map[string][]byte)
+	s.deletes = make(map[string]bool)
+	s.transfers = nil
+	s.event = nil
+}
+
+func (s *Stub) commit() {
+	for k := range s.deletes {
+		delete(s.State, k)
+	}
+	for k, v := range s.writes {
+		s.State[k] = v
+	}
+	for _, tr := range s.transfers {
+		from := s.ledger.account(tr.from)
+		to := s.ledger.account(tr.to)
+		from.Balance[tr.balanceType] = new(big.Int).Sub(from.Balance[tr.balanceType], tr.amount)
+		if to.Balance[tr.balanceType] == nil {
+			to.Balance[tr.balanceType] = big.NewInt(0)
+		}
+		to.Balance[tr.balanceType] = new(big.Int).Add(to.Balance[tr.balanceType], tr.amount)
+	}
+	if len(s.transfers) > 0 {
+		s.ledger.account(s.sender).Counter++
+	}
+	if s.event != nil {
+		s.Events = append(s.Events, *s.event)
+	}
+}
+
+func (s *Stub) end() {
+	s.args = nil
+	s.writes = nil
+	s.deletes = nil
+	s.transfers = nil
+	s.event = nil
+}
+
+func toBytes(args []string) [][]byte {
+	bargs := make([][]byte, len(args))
+	for i, arg := range args {
+		bargs[i] = []byte(arg)
+	}
+	return bargs
+}
+
+// LastEvent returns the most recently committed event, or nil.
+func (s *Stub) LastEvent() *Event {
+	if len(s.Events) == 0 {
+		return nil
+	}
+	return &s.Events[len(s.Events)-1]
+}
+
+// ==================================================================================
+// shim.ChaincodeStubInterface
+// ==================================================================================
+
+func (s *Stub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *Stub) GetStringArgs() []string {
+	strargs := make([]string, len(s.args))
+	for i, arg := range s.args {
+		strargs[i] = string(arg)
+	}
+	return strargs
+}
+
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	allargs := s.GetStringArgs()
+	if len(allargs) == 0 {
+		return "", []string{}
+	}
+	return allargs[0], allargs[1:]
+}
+
+func (s *Stub) GetTxID() string {
+	return s.txID
+}
+
+func (s *Stub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.txTime.Unix(), Nanos: int32(s.txTime.Nanosecond())}, nil
+}
+
+// GetState returns the committed value of key; pending writes of the
+// current transaction are not visible, as on a real peer.
+func (s *Stub) GetState(key string) ([]byte, error) {
+	return s.State[key], nil
+}
+
+func (s *Stub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	if s.writes == nil {
+		return errors.New("PutState called outside a transaction")
+	}
+	delete(s.deletes, key)
+	s.writes[key] = value
+	return nil
+}
+
+func (s *Stub) DelState(key string) error {
+	if s.deletes == nil {
+		return errors.New("DelState called outside a transaction")
+	}
+	delete(s.writes, key)
+	s.deletes[key] = true
+	return nil
+}
+
+func (s *Stub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return s.rangeIterator(startKey, endKey), nil
+}
+
+func (s *Stub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	partialKey, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return s.rangeIterator(partialKey, partialKey+string(maxUnicodeRuneValue)), nil
+}
+
+func (s *Stub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+	ck := compositeKeyNamespace + objectType + string(minUnicodeRuneValue)
+	for _, att := range attributes {
+		if err := validateCompositeKeyAttribute(att); err != nil {
+			return "", err
+		}
+		ck += att + string(minUnicodeRuneValue)
+	}
+	return ck, nil
+}
+
+func (s *Stub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	componentIndex := 1
+	components := []string{}
+	for i := 1; i < len(compositeKey); i++ {
+		if rune(compositeKey[i]) == minUnicodeRuneValue {
+			components = append(components, compositeKey[componentIndex:i])
+			componentIndex = i + 1
+		}
+	}
+	if len(components) == 0 {
+		return "", nil, fmt.Errorf("not a composite key: %q", compositeKey)
+	}
+	return components[0], components[1:], nil
+}
+
+func validateCompositeKeyAttribute(str string) error {
+	if !utf8.ValidString(str) {
+		return fmt.Errorf("not a valid utf8 string: [%x]", str)
+	}
+	for index, runeValue := range str {
+		if runeValue == minUnicodeRuneValue || runeValue == maxUnicodeRuneValue {
+			return fmt.Errorf(`input contain unicode %#U starting at position [%d]. %#U and %#U are not allowed in the input attribute of a composite key`,
+				runeValue, index, minUnicodeRuneValue, maxUnicodeRuneValue)
+		}
+	}
+	return nil
+}
+
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be nil string")
+	}
+	s.event = &Event{TxID: s.txID, Name: name, Payload: payload}
+	return nil
+}
+
+// InvokeChaincode calls the chaincode registered under chaincodeName in
+// Invokables as a nested transaction of the same sender.
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
+	other, ok := s.Invokables[chaincodeName]
+	if !ok {
+		return shim.Error("Chaincode " + chaincodeName + " not found")
+	}
+	return other.run(s.sender, args, other.cc.Invoke)
+}
+
+// ==================================================================================
+// Inkchain extensions
+// ==================================================================================
+
+func (s *Stub) GetSender() (string, error) {
+	if s.sender == "" {
+		return "", errors.New("no sender set for the transaction")
+	}
+	return s.sender, nil
+}
+
+// Transfer moves amount of balanceType from the sender to the to address.
+// The sender must hold enough tokens, counting earlier transfers of the
+// same transaction.
+func (s *Stub) Transfer(to string, balanceType string, amount *big.Int) error {
+	if s.sender == "" {
+		return errors.New("no sender set for the transaction")
+	}
+	if amount == nil || amount.Sign() < 0 {
+		return errors.New("transfer amount must be non-negative")
+	}
+	available := big.NewInt(0)
+	if acc, ok := s.ledger.Accounts[s.sender]; ok && acc.Balance[balanceType] != nil {
+		available.Set(acc.Balance[balanceType])
+	}
+	for _, tr := range s.transfers {
+		if tr.balanceType != balanceType {
+			continue
+		}
+		if tr.from == s.sender {
+			available.Sub(available, tr.amount)
+		}
+		if tr.to == s.sender {
+			available.Add(available, tr.amount)
+		}
+	}
+	if available.Cmp(amount) < 0 {
+		return fmt.Errorf("insufficient %s balance of %s", balanceType, s.sender)
+	}
+	s.transfers = append(s.transfers, transfer{s.sender, to, balanceType, new(big.Int).Set(amount)})
+	return nil
+}
+
+// GetAccount returns a copy of the committed account of address.
+func (s *Stub) GetAccount(address string) (*wallet.Account, error) {
+	acc, ok := s.ledger.Accounts[address]
+	if !ok {
+		return nil, errors.New("account not exists")
+	}
+	cp := &wallet.Account{Balance: make(map[string]*big.Int), Counter: acc.Counter}
+	for k, v := range acc.Balance {
+		cp.Balance[k] = new(big.Int).Set(v)
+	}
+	return cp, nil
+}
+
+// ==================================================================================
+// Iterator
+// ==================================================================================
+
+type iterator struct {
+	namespace string
+	keys      []string
+	values    [][]byte
+	pos       int
+}
+
+func (s *Stub) rangeIterator(startKey, endKey string) *iterator {
+	it := &iterator{namespace: s.Name}
+	for k := range s.State {
+		if k < startKey || (endKey != "" && k >= endKey) {
+			continue
+		}
+		it.keys = append(it.keys, k)
+	}
+	sort.Strings(it.keys)
+	for _, k := range it.keys {
+		it.values = append(it.values, s.State[k])
+	}
+	return it
+}
+
+func (it *iterator) HasNext() bool {
+	return it.pos < len(it.keys)
+}
+
+func (it *iterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	kv := &queryresult.KV{Namespace: it.namespace, Key: it.keys[it.pos], Value: it.values[it.pos]}
+	it.pos++
+	return kv, nil
+}
+
+func (it *iterator) Close() error {
+	return nil
+}
+
+// Keys returns the committed keys starting with prefix, sorted.
+func (s *Stub) Keys(prefix string) []string {
+	var keys []string
+	for k := range s.State {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gzf09/DSES/chaincodes/events"
+	"github.com/gzf09/DSES/chaincodes/shimtest"
+	"github.com/inklabsfoundation/inkchain/core/chaincode/shim"
+)
+
+const (
+	addrA = "07caf88941eafcaaa3370657fccc261acb75dfba"
+	addrB = "a5ff00eb44bf19d5dfbde501c90e286badb58df4"
+)
+
+func newTokenStub(t *testing.T) *shimtest.Stub {
+	stub := shimtest.NewStub("token", new(tokenChaincode))
+	if res := stub.InitAs(addrA); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	stub.SetBalance(addrA, "INK", 100)
+	return stub
+}
+
+func TestGetBalance(t *testing.T) {
+	stub := newTokenStub(t)
+
+	res := stub.InvokeAs(addrA, GetBalance, addrA, "INK")
+	if res.Status != shim.OK || string(res.Payload) != `{"INK":"100"}` {
+		t.Fatalf("unexpected balance: %d %s %s", res.Status, res.Payload, res.Message)
+	}
+	// addresses are case-insensitive
+	res = stub.InvokeAs(addrA, GetBalance, "07CAF88941EAFCAAA3370657FCCC261ACB75DFBA", "INK")
+	if res.Status != shim.OK {
+		t.Fatalf("upper-case address rejected: %s", res.Message)
+	}
+	if res = stub.InvokeAs(addrA, GetBalance, addrA, "CCToken"); res.Status == shim.OK {
+		t.Fatal("expected error for a token type the account does not hold")
+	}
+	if res = stub.InvokeAs(addrA, GetBalance, addrB, "INK"); res.Status == shim.OK {
+		t.Fatal("expected error for an unknown account")
+	}
+	if res = stub.InvokeAs(addrA, GetBalance, addrA); res.Status == shim.OK {
+		t.Fatal("expected error for a missing argument")
+	}
+}
+
+func TestGetAccount(t *testing.T) {
+	stub := newTokenStub(t)
+
+	res := stub.InvokeAs(addrA, GetAccount, addrA)
+	want := `{"Name":"` + addrA + `","Balance":"{"INK":100}"}`
+	if res.Status != shim.OK || string(res.Payload) != want {
+		t.Fatalf("unexpected account: %d %s %s", res.Status, res.Payload, res.Message)
+	}
+	if res = stub.InvokeAs(addrA, GetAccount, addrB); res.Status == shim.OK {
+		t.Fatal("expected error for an unknown account")
+	}
+	if res = stub.InvokeAs(addrA, GetAccount); res.Status == shim.OK {
+		t.Fatal("expected error for a missing argument")
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	stub := newTokenStub(t)
+
+	res := stub.InvokeAs(addrA, Transfer, addrB, "INK", "30")
+	if res.Status != shim.OK {
+		t.Fatalf("transfer failed: %s", res.Message)
+	}
+	if got := stub.Balance(addrA, "INK").String(); got != "70" {
+		t.Fatalf("sender balance = %s, want 70", got)
+	}
+	if got := stub.Balance(addrB, "INK").String(); got != "30" {
+		t.Fatalf("receiver balance = %s, want 30", got)
+	}
+
+	if res = stub.InvokeAs(addrA, Transfer, addrB, "INK", "71"); res.Status == shim.OK {
+		t.Fatal("expected error when overdrawing")
+	}
+	if res = stub.InvokeAs(addrA, Transfer, addrB, "INK", "ten"); res.Status == shim.OK {
+		t.Fatal("expected error for a non-integer amount")
+	}
+	if res = stub.InvokeAs(addrA, Transfer, addrB, "INK"); res.Status == shim.OK {
+		t.Fatal("expected error for a missing argument")
+	}
+	if got := stub.Balance(addrA, "INK").String(); got != "70" {
+		t.Fatalf("failed transfers changed the balance to %s", got)
+	}
+}
+
+func TestTransferEvent(t *testing.T) {
+	stub := newTokenStub(t)
+
+	if res := stub.InvokeAs(addrA, Transfer, addrB, "INK", "30"); res.Status != shim.OK {
+		t.Fatalf("transfer failed: %s", res.Message)
+	}
+	e := stub.LastEvent()
+	if e == nil || e.Name != events.Transfer {
+		t.Fatalf("event = %+v", e)
+	}
+	event, data, err := events.Decode(e.Payload)
+	if err != nil {
+		t.Fatalf("decode %s: %v", e.Payload, err)
+	}
+	if event.Actor != addrA || len(event.Keys) != 2 || event.TxID != stub.GetTxID() {
+		t.Fatalf("event = %+v", event)
+	}
+	if got := *data.(*events.TokenTransfer); got != (events.TokenTransfer{From: addrA, To: addrB, TokenType: "INK", Amount: "30"}) {
+		t.Fatalf("transfer = %+v", got)
+	}
+
+	// failed transfers emit nothing
+	stub.InvokeAs(addrA, Transfer, addrB, "INK", "71")
+	if len(stub.Events) != 1 {
+		t.Fatalf("events = %d", len(stub.Events))
+	}
+}
+
+func TestCounter(t *testing.T) {
+	stub := newTokenStub(t)
+	stub.SetCounter(addrA, 3)
+
+	res := stub.InvokeAs(addrA, Counter, addrA)
+	if res.Status != shim.OK || string(res.Payload) != "3" {
+		t.Fatalf("unexpected counter: %d %s %s", res.Status, res.Payload, res.Message)
+	}
+	if res = stub.InvokeAs(addrA, Transfer, addrB, "INK", "1"); res.Status != shim.OK {
+		t.Fatalf("transfer failed: %s", res.Message)
+	}
+	if res = stub.InvokeAs(addrA, Counter, addrA); string(res.Payload) != "4" {
+		t.Fatalf("counter after transfer = %s, want 4", res.Payload)
+	}
+	if res = stub.InvokeAs(addrA, Counter, addrB); res.Status != shim.OK || string(res.Payload) != "0" {
+		t.Fatalf("unexpected counter for receiver: %s %s", res.Payload, res.Message)
+	}
+	if res = stub.InvokeAs(addrA, Counter, "ffff"); res.Status == shim.OK {
+		t.Fatal("expected error for an unknown account")
+	}
+	if res = stub.InvokeAs(addrA, Counter); res.Status == shim.OK {
+		t.Fatal("expected error for a missing argument")
+	}
+}
+
+func TestSender(t *testing.T) {
+	stub := newTokenStub(t)
+
+	res := stub.InvokeAs(addrB, Sender)
+	if res.Status != shim.OK || string(res.Payload) != addrB {
+		t.Fatalf("unexpected sender: %s %s", res.Payload, res.Message)
+	}
+	if res = stub.InvokeAs("", Sender); res.Status == shim.OK {
+		t.Fatal("expected error without a sender")
+	}
+}
+
+func TestInvalidFunction(t *testing.T) {
+	stub := newTokenStub(t)
+
+	if res := stub.InvokeAs(addrA, "burn", addrA); res.Status == shim.OK {
+		t.Fatal("expected error for an unknown function")
+	}
+}